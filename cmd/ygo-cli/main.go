@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"ygo-card-processor/pkg/cli"
+	"ygo-card-processor/pkg/config"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/external"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/processor"
+	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/progress"
+	"ygo-card-processor/pkg/reader"
+	"ygo-card-processor/pkg/tracing"
+)
+
+func main() {
+	cfg, args, err := config.Parse(cli.Name, os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		logrus.WithError(err).Fatal("Could not load configuration")
+	}
+	if err := logging.Setup(cfg.Logging); err != nil {
+		logrus.WithError(err).Fatal("Could not set up logging")
+	}
+
+	// Interrupting cancels ctx, so running work stops and the program shuts down cleanly.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		cancel()
+	}()
+
+	// Like events, spans meant for stdout go to stderr.
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing, os.Stderr)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not set up tracing")
+	}
+
+	// Stdout is kept for command output, so events meant for it are written to stderr instead.
+	sinkConfig := producer.NewSinkConfig(cfg.Events)
+	for i, sinkType := range sinkConfig.Types {
+		if sinkType == producer.SinkTypeStdout {
+			sinkConfig.Types[i] = producer.SinkTypeStderr
+		}
+	}
+	sink, err := producer.CreateEventSink(sinkConfig)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not create event sink")
+	}
+
+	mongoClient, err := dao.Connect(ctx, cfg.Mongo)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not connect to database")
+	}
+	dbHandler := dao.Instrument(mongoClient)
+
+	progressBroadcaster := progress.NewBroadcaster()
+	app := cli.App{
+		Handler: dbHandler,
+		Processor: &processor.Processor{
+			Handler:    dbHandler,
+			Retriever:  external.Trace(external.NewRetriever(cfg.Tcgplayer)),
+			Sink:       &producer.TracedSink{Sink: sink},
+			PublicKey:  cfg.Tcgplayer.PublicKey,
+			PrivateKey: cfg.Tcgplayer.PrivateKey,
+			Delay:      processor.CardDelay,
+			Progress:   progressBroadcaster,
+		},
+		Progress: progressBroadcaster,
+		Reader:   &reader.Reader{},
+		Out:      os.Stdout,
+		Err:      os.Stderr,
+		Clock:    time.Now,
+	}
+
+	err = app.Run(ctx, args)
+	if closeErr := sink.Close(); closeErr != nil {
+		logrus.WithError(closeErr).Error("Error closing event sink")
+	}
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	if flushErr := shutdownTracing(flushCtx); flushErr != nil {
+		logrus.WithError(flushErr).Error("Error flushing spans")
+	}
+	cancelFlush()
+
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+		return
+	case errors.Is(err, cli.ErrUsage):
+		os.Exit(2)
+	default:
+		logrus.WithError(err).Error("Command failed")
+		os.Exit(1)
+	}
+}
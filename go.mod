@@ -5,12 +5,22 @@ go 1.13
 require (
 	github.com/benbjohnson/phantomjs v0.0.0-20181211182228-6499a20f5cd6
 	github.com/confluentinc/confluent-kafka-go v1.6.1
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/k4s/phantomgo v0.0.0-20161104020322-11963773aa04
+	github.com/prometheus/client_golang v1.11.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.6.0
-	github.com/stretchr/testify v1.6.1
+	github.com/stretchr/testify v1.7.0
 	github.com/tealeg/xlsx v1.0.5
 	go.mongodb.org/mongo-driver v1.4.6
-	golang.org/x/net v0.0.0-20200813134508-3edf25e44fcc
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	golang.org/x/net v0.0.0-20200822124328-c89045814202
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -1,12 +1,70 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"time"
+
 	"github.com/sirupsen/logrus"
 	"ygo-card-processor/pkg/api"
+	"ygo-card-processor/pkg/config"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/external"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/reader"
+	"ygo-card-processor/pkg/tracing"
 )
 
 func main() {
-	if err := api.ListenAndServe(); err != nil {
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not load configuration")
+	}
+	if err := logging.Setup(cfg.Logging); err != nil {
+		logrus.WithError(err).Fatal("Could not set up logging")
+	}
+
+	// Interrupting cancels ctx, so running work stops and the program shuts down cleanly.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		cancel()
+	}()
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing, os.Stdout)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not set up tracing")
+	}
+
+	sink, err := producer.CreateEventSink(producer.NewSinkConfig(cfg.Events))
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not create event sink")
+	}
+
+	dbHandler, err := dao.Connect(ctx, cfg.Mongo)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not connect to database")
+	}
+
+	retriever := external.Trace(external.NewRetriever(cfg.Tcgplayer))
+	server := api.NewServer(cfg, dao.Instrument(dbHandler), retriever, &reader.Reader{}, &producer.TracedSink{Sink: sink}, time.Now)
+	if err := server.Run(ctx); err != nil {
 		logrus.WithError(err).Fatal("Could not serve API")
 	}
+
+	if err := sink.Close(); err != nil {
+		logrus.WithError(err).Error("Error closing event sink")
+	}
+
+	// The signal context is done by now, so flushing the remaining spans gets a context of its own.
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logrus.WithError(err).Error("Error flushing spans")
+	}
 }
@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type Card struct {
 	ProductId    int            `json:"productId" bson:"productId"`
@@ -16,9 +20,55 @@ type Card struct {
 	ExtendedData []ExtendedData `json:"extendedData" bson:"extendedData"`
 }
 
+// Serial returns the serial number a card is stored under.
+func (c Card) Serial() string {
+	if len(c.ExtendedData) == 0 {
+		return ""
+	}
+	return c.ExtendedData[0].Value
+}
+
 type CardWithPriceInfo struct {
-	CardInfo  Card           `json:"card" bson:"card"`
-	PriceInfo []PriceResults `json:"priceInfo" bson:"priceInfo"`
+	CardInfo     Card           `json:"card" bson:"card"`
+	PriceInfo    []PriceResults `json:"priceInfo" bson:"priceInfo"`
+	SkuPriceInfo []SkuPrice     `json:"skuPriceInfo" bson:"skuPriceInfo"`
+	Condition    string         `json:"condition" bson:"condition"`
+	Printing     string         `json:"printing" bson:"printing"`
+	Language     string         `json:"language" bson:"language"`
+	PricedAt     time.Time      `json:"pricedAt" bson:"pricedAt"`
+	PriceSource  string         `json:"priceSource" bson:"priceSource"`
+	NoMarketData bool           `json:"noMarketData" bson:"noMarketData"`
+	AddedAt      time.Time      `json:"addedAt" bson:"addedAt,omitempty"`
+	PriceHistory []PricePoint   `json:"priceHistory" bson:"priceHistory"`
+	CollectionId string         `json:"collectionId" bson:"collectionId"`
+}
+
+// PricePoint is the highest market price among a card's printings at the time it was priced.
+type PricePoint struct {
+	At          time.Time `json:"at" bson:"at"`
+	MarketPrice float64   `json:"marketPrice" bson:"marketPrice"`
+}
+
+// CardFilter selects stored cards. Zero valued fields do not filter.
+type CardFilter struct {
+	Serial         string    `json:"serial,omitempty"`
+	Serials        []string  `json:"serials,omitempty"`
+	GroupId        int       `json:"groupId,omitempty"`
+	Rarity         string    `json:"rarity,omitempty"`
+	MinMarketPrice float64   `json:"minMarketPrice,omitempty"`
+	AddedAfter     time.Time `json:"addedAfter,omitempty"`
+	PricedBefore   time.Time `json:"pricedBefore,omitempty"`
+}
+
+type SkuPrice struct {
+	SkuId              int     `json:"skuId" bson:"skuId"`
+	Condition          string  `json:"condition" bson:"condition"`
+	Printing           string  `json:"printing" bson:"printing"`
+	Language           string  `json:"language" bson:"language"`
+	LowPrice           float64 `json:"lowPrice" bson:"lowPrice"`
+	MarketPrice        float64 `json:"marketPrice" bson:"marketPrice"`
+	DirectLowPrice     float64 `json:"directLowPrice" bson:"directLowPrice"`
+	MissingMarketPrice bool    `json:"missingMarketPrice" bson:"missingMarketPrice"`
 }
 
 type PresaleInfo struct {
@@ -56,23 +106,111 @@ type PriceResponse struct {
 }
 
 type PriceResults struct {
-	ProductId      int     `json:"productId" bson:"productId"`
-	LowPrice       float64 `json:"lowPrice" bson:"lowPrice"`
-	MidPrice       float64 `json:"midPrice" bson:"midPrice"`
-	HighPrice      float64 `json:"highPrice" bson:"highPrice"`
-	MarketPrice    float64 `json:"marketPrice" bson:"marketPrice"`
-	DirectLowPrice float64 `json:"directLowPrice" bson:"directLowPrice"`
-	SubTypeName    string  `json:"subTypeName" bson:"subTypeName"`
+	ProductId          int     `json:"productId" bson:"productId"`
+	LowPrice           float64 `json:"lowPrice" bson:"lowPrice"`
+	MidPrice           float64 `json:"midPrice" bson:"midPrice"`
+	HighPrice          float64 `json:"highPrice" bson:"highPrice"`
+	MarketPrice        float64 `json:"marketPrice" bson:"marketPrice"`
+	DirectLowPrice     float64 `json:"directLowPrice" bson:"directLowPrice"`
+	SubTypeName        string  `json:"subTypeName" bson:"subTypeName"`
+	MissingMarketPrice bool    `json:"missingMarketPrice" bson:"missingMarketPrice"`
 }
 
-type ExtendedSearchResponse struct {
+type Sku struct {
+	SkuId       int    `json:"skuId" bson:"skuId"`
+	ProductId   int    `json:"productId" bson:"productId"`
+	LanguageId  int    `json:"languageId" bson:"languageId"`
+	PrintingId  int    `json:"printingId" bson:"printingId"`
+	ConditionId int    `json:"conditionId" bson:"conditionId"`
+	Language    string `json:"language" bson:"language"`
+	Printing    string `json:"printing" bson:"printing"`
+	Condition   string `json:"condition" bson:"condition"`
+}
+
+type SkuResponse struct {
 	Success bool     `json:"success" bson:"success"`
 	Errors  []string `json:"errors" bson:"errors"`
-	Results []Card   `json:"results" bson:"results"`
+	Results []Sku    `json:"results" bson:"results"`
+}
+
+type SkuPriceResponse struct {
+	Success bool              `json:"success" bson:"success"`
+	Errors  []string          `json:"errors" bson:"errors"`
+	Results []SkuPriceResults `json:"results" bson:"results"`
+}
+
+type SkuPriceResults struct {
+	SkuId              int     `json:"skuId" bson:"skuId"`
+	LowPrice           float64 `json:"lowPrice" bson:"lowPrice"`
+	LowestShipping     float64 `json:"lowestShipping" bson:"lowestShipping"`
+	LowestListingPrice float64 `json:"lowestListingPrice" bson:"lowestListingPrice"`
+	MarketPrice        float64 `json:"marketPrice" bson:"marketPrice"`
+	DirectLowPrice     float64 `json:"directLowPrice" bson:"directLowPrice"`
+}
+
+type Condition struct {
+	ConditionId  int    `json:"conditionId" bson:"conditionId"`
+	Name         string `json:"name" bson:"name"`
+	Abbreviation string `json:"abbreviation" bson:"abbreviation"`
+}
+
+type ConditionResponse struct {
+	Success bool        `json:"success" bson:"success"`
+	Errors  []string    `json:"errors" bson:"errors"`
+	Results []Condition `json:"results" bson:"results"`
+}
+
+type Printing struct {
+	PrintingId int    `json:"printingId" bson:"printingId"`
+	Name       string `json:"name" bson:"name"`
+}
+
+type PrintingResponse struct {
+	Success bool       `json:"success" bson:"success"`
+	Errors  []string   `json:"errors" bson:"errors"`
+	Results []Printing `json:"results" bson:"results"`
+}
+
+type Language struct {
+	LanguageId int    `json:"languageId" bson:"languageId"`
+	Name       string `json:"name" bson:"name"`
+	Abbr       string `json:"abbr" bson:"abbr"`
+}
+
+type LanguageResponse struct {
+	Success bool       `json:"success" bson:"success"`
+	Errors  []string   `json:"errors" bson:"errors"`
+	Results []Language `json:"results" bson:"results"`
+}
+
+type ExtendedSearchResponse struct {
+	TotalItems int      `json:"totalItems" bson:"totalItems"`
+	Success    bool     `json:"success" bson:"success"`
+	Errors     []string `json:"errors" bson:"errors"`
+	Results    []Card   `json:"results" bson:"results"`
+}
+
+type Group struct {
+	GroupId        int    `json:"groupId" bson:"groupId"`
+	Name           string `json:"name" bson:"name"`
+	Abbreviation   string `json:"abbreviation" bson:"abbreviation"`
+	IsSupplemental bool   `json:"isSupplemental" bson:"isSupplemental"`
+	PublishedOn    string `json:"publishedOn" bson:"publishedOn"`
+	ModifiedOn     string `json:"modifiedOn" bson:"modifiedOn"`
+	CategoryId     int    `json:"categoryId" bson:"categoryId"`
+}
+
+type GroupResponse struct {
+	TotalItems int      `json:"totalItems" bson:"totalItems"`
+	Success    bool     `json:"success" bson:"success"`
+	Errors     []string `json:"errors" bson:"errors"`
+	Results    []Group  `json:"results" bson:"results"`
 }
 
 type CardSearchBody struct {
 	Filters []CardSearchFilter `json:"filters" bson:"filters"`
+	Offset  int                `json:"offset,omitempty" bson:"offset,omitempty"`
+	Limit   int                `json:"limit,omitempty" bson:"limit,omitempty"`
 }
 
 type CardSearchFilter struct {
@@ -80,6 +218,199 @@ type CardSearchFilter struct {
 	Values []string `json:"values" bson:"values"`
 }
 
+type CatalogSearchResult struct {
+	TotalItems int                 `json:"totalItems" bson:"totalItems"`
+	Offset     int                 `json:"offset" bson:"offset"`
+	Limit      int                 `json:"limit" bson:"limit"`
+	Results    []CardWithPriceInfo `json:"results" bson:"results"`
+}
+
+type Set struct {
+	GroupId      int                `json:"groupId" bson:"groupId"`
+	Name         string             `json:"name" bson:"name"`
+	Abbreviation string             `json:"abbreviation" bson:"abbreviation"`
+	ReleaseDate  string             `json:"releaseDate" bson:"releaseDate"`
+	CardCount    int                `json:"cardCount" bson:"cardCount"`
+	Products     []ProductCandidate `json:"products" bson:"products"`
+	SyncedAt     time.Time          `json:"syncedAt" bson:"syncedAt"`
+}
+
+type SetCompletion struct {
+	GroupId        int                `json:"groupId" bson:"groupId"`
+	Name           string             `json:"name" bson:"name"`
+	CardCount      int                `json:"cardCount" bson:"cardCount"`
+	OwnedCount     int                `json:"ownedCount" bson:"ownedCount"`
+	MissingCount   int                `json:"missingCount" bson:"missingCount"`
+	Completion     float64            `json:"completion" bson:"completion"`
+	CostToComplete float64            `json:"costToComplete" bson:"costToComplete"`
+	Owned          []ProductCandidate `json:"owned" bson:"owned"`
+	Missing        []MissingCard      `json:"missing" bson:"missing"`
+}
+
+type MissingCard struct {
+	ProductCandidate `bson:",inline"`
+	MarketPrice      float64 `json:"marketPrice" bson:"marketPrice"`
+}
+
+// DuplicateCards are the cards stored under one serial number. Deduping keeps Kept and removes the others.
+type DuplicateCards struct {
+	Serial  string   `json:"serial"`
+	Kept    string   `json:"kept"`
+	Removed []string `json:"removed"`
+}
+
+type CollectionValue struct {
+	CardCount        int         `json:"cardCount" bson:"cardCount"`
+	UnpricedCount    int         `json:"unpricedCount" bson:"unpricedCount"`
+	TotalMarketValue float64     `json:"totalMarketValue" bson:"totalMarketValue"`
+	Cards            []CardValue `json:"cards" bson:"cards"`
+}
+
+type CardValue struct {
+	Serial       string    `json:"serial" bson:"serial"`
+	Name         string    `json:"name" bson:"name"`
+	Condition    string    `json:"condition" bson:"condition"`
+	Printing     string    `json:"printing" bson:"printing"`
+	Language     string    `json:"language" bson:"language"`
+	MarketPrice  float64   `json:"marketPrice" bson:"marketPrice"`
+	PriceSource  string    `json:"priceSource" bson:"priceSource"`
+	NoMarketData bool      `json:"noMarketData" bson:"noMarketData"`
+	PricedAt     time.Time `json:"pricedAt" bson:"pricedAt"`
+}
+
+type PriceReport struct {
+	Count int              `json:"count" bson:"count"`
+	Cards []PriceReportRow `json:"cards" bson:"cards"`
+}
+
+type PriceReportRow struct {
+	Serial       string    `json:"serial" bson:"serial"`
+	Name         string    `json:"name" bson:"name"`
+	ProductId    int       `json:"productId" bson:"productId"`
+	PricedAt     time.Time `json:"pricedAt" bson:"pricedAt"`
+	PriceSource  string    `json:"priceSource" bson:"priceSource"`
+	NoMarketData bool      `json:"noMarketData" bson:"noMarketData"`
+}
+
+const (
+	PriceSourceSku     = "sku"
+	PriceSourceProduct = "product"
+
+	PriceSourceTcgplayer = "tcgplayer"
+)
+
+type OutboxEvent struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Event     DomainEvent        `json:"event" bson:"event"`
+	Sent      bool               `json:"sent" bson:"sent"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	SentAt    time.Time          `json:"sentAt" bson:"sentAt"`
+}
+
+// DomainEvent describes a change to a card, its prices or a job. Which of Card, Prices and Job are set depends on Type.
+type DomainEvent struct {
+	Id            string       `json:"id" bson:"id"`
+	Type          string       `json:"type" bson:"type"`
+	SchemaVersion int          `json:"schemaVersion" bson:"schemaVersion"`
+	Source        string       `json:"source" bson:"source"`
+	Time          time.Time    `json:"time" bson:"time"`
+	CorrelationId string       `json:"correlationId,omitempty" bson:"correlationId,omitempty"`
+	JobId         string       `json:"jobId,omitempty" bson:"jobId,omitempty"`
+	Card          *CardRef     `json:"card,omitempty" bson:"card,omitempty"`
+	Prices        *PriceChange `json:"prices,omitempty" bson:"prices,omitempty"`
+	Job           *JobSummary  `json:"job,omitempty" bson:"job,omitempty"`
+	Command       *CommandRef  `json:"command,omitempty" bson:"command,omitempty"`
+}
+
+type CardRef struct {
+	Serial    string `json:"serial" bson:"serial"`
+	ProductId int    `json:"productId" bson:"productId"`
+	GroupId   int    `json:"groupId" bson:"groupId"`
+	Name      string `json:"name" bson:"name"`
+}
+
+type PriceChange struct {
+	Old []PrintingPrice `json:"old" bson:"old"`
+	New []PrintingPrice `json:"new" bson:"new"`
+}
+
+type PrintingPrice struct {
+	Printing    string  `json:"printing" bson:"printing"`
+	LowPrice    float64 `json:"lowPrice" bson:"lowPrice"`
+	MarketPrice float64 `json:"marketPrice" bson:"marketPrice"`
+}
+
+type JobSummary struct {
+	Id             string    `json:"id" bson:"id"`
+	Type           string    `json:"type" bson:"type"`
+	Status         string    `json:"status" bson:"status"`
+	Total          int       `json:"total" bson:"total"`
+	Processed      int       `json:"processed" bson:"processed"`
+	Failed         int       `json:"failed" bson:"failed"`
+	CatalogChanged int       `json:"catalogChanged" bson:"catalogChanged"`
+	PriceOnly      int       `json:"priceOnly" bson:"priceOnly"`
+	Ambiguous      int       `json:"ambiguous" bson:"ambiguous"`
+	NotFound       int       `json:"notFound" bson:"notFound"`
+	StartedAt      time.Time `json:"startedAt" bson:"startedAt"`
+	FinishedAt     time.Time `json:"finishedAt" bson:"finishedAt"`
+}
+
+const (
+	EventCardAdded    = "card_added"
+	EventCardUpdated  = "card_updated"
+	EventCardDeleted  = "card_deleted"
+	EventPriceChanged = "price_changed"
+	EventJobStarted   = "job_started"
+	EventJobFinished  = "job_finished"
+
+	EventCommandAccepted = "command_accepted"
+	EventCommandRejected = "command_rejected"
+)
+
+// Command asks the processor to start a job. Which of Serial, GroupId and Serials is required depends on Type.
+type Command struct {
+	Id         string   `json:"id"`
+	Type       string   `json:"type"`
+	Serial     string   `json:"serial,omitempty"`
+	GroupId    int      `json:"groupId,omitempty"`
+	Serials    []string `json:"serials,omitempty"`
+	Collection string   `json:"collection,omitempty"`
+}
+
+const (
+	CommandRefreshCard   = "refresh_card"
+	CommandRefreshSet    = "refresh_set"
+	CommandRefreshAll    = "refresh_all"
+	CommandImportSerials = "import_serials"
+)
+
+type CommandRef struct {
+	Id    string `json:"id" bson:"id"`
+	Type  string `json:"type" bson:"type"`
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// Schedule refreshes the cards selected by Target whenever its cron expression comes due.
+type Schedule struct {
+	Name           string    `json:"name" bson:"name"`
+	Cron           string    `json:"cron" bson:"cron"`
+	Target         string    `json:"target" bson:"target"`
+	MinMarketPrice float64   `json:"minMarketPrice,omitempty" bson:"minMarketPrice,omitempty"`
+	Budget         int       `json:"budget,omitempty" bson:"budget,omitempty"`
+	Disabled       bool      `json:"disabled" bson:"disabled"`
+	CreatedAt      time.Time `json:"createdAt" bson:"createdAt"`
+	LastRunAt      time.Time `json:"lastRunAt" bson:"lastRunAt"`
+	LastJobId      string    `json:"lastJobId,omitempty" bson:"lastJobId,omitempty"`
+	NextRunAt      time.Time `json:"nextRunAt" bson:"-"`
+	Collection     string    `json:"collection,omitempty" bson:"collection,omitempty"`
+}
+
+const (
+	ScheduleTargetAll       = "all"
+	ScheduleTargetHighValue = "high_value"
+	ScheduleTargetNew       = "new"
+)
+
 type Log struct {
 	AppName   string    `json:"appName" bson:"appName"`
 	Event     string    `json:"event" bson:"event"`
@@ -87,3 +418,109 @@ type Log struct {
 	IsError   bool      `json:"isError" bson:"isError"`
 	TimeStamp time.Time `json:"timeStamp" bson:"timeStamp"`
 }
+
+type ProductCandidate struct {
+	ProductId int    `json:"productId" bson:"productId"`
+	Name      string `json:"name" bson:"name"`
+	GroupId   int    `json:"groupId" bson:"groupId"`
+	Number    string `json:"number" bson:"number"`
+	Rarity    string `json:"rarity" bson:"rarity"`
+	ImageUrl  string `json:"imageUrl" bson:"imageUrl"`
+}
+
+type AmbiguousSerial struct {
+	Row        int                `json:"row" bson:"row"`
+	Serial     string             `json:"serial" bson:"serial"`
+	Candidates []ProductCandidate `json:"candidates" bson:"candidates"`
+}
+
+type Job struct {
+	Id             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Type           string             `json:"type" bson:"type"`
+	Status         string             `json:"status" bson:"status"`
+	Total          int                `json:"total" bson:"total"`
+	Processed      int                `json:"processed" bson:"processed"`
+	Failed         int                `json:"failed" bson:"failed"`
+	CatalogChanged int                `json:"catalogChanged" bson:"catalogChanged"`
+	PriceOnly      int                `json:"priceOnly" bson:"priceOnly"`
+	Checkpoint     int                `json:"checkpoint" bson:"checkpoint"`
+	Serials        []string           `json:"-" bson:"serials,omitempty"`
+	Ambiguous      []AmbiguousSerial  `json:"ambiguous" bson:"ambiguous"`
+	NotFound       []AmbiguousSerial  `json:"notFound" bson:"notFound"`
+	StartedAt      time.Time          `json:"startedAt" bson:"startedAt"`
+	FinishedAt     time.Time          `json:"finishedAt" bson:"finishedAt"`
+	CollectionId   string             `json:"collectionId" bson:"collectionId"`
+}
+
+// JobProgress is a snapshot of a running job, streamed to clients following the job. A positive RateLimitWaitMs means
+// the job is pausing between cards to stay within the TCG Player API limit.
+type JobProgress struct {
+	JobId           string    `json:"jobId"`
+	Type            string    `json:"type"`
+	Status          string    `json:"status"`
+	CurrentCard     string    `json:"currentCard,omitempty"`
+	Total           int       `json:"total"`
+	Processed       int       `json:"processed"`
+	Failed          int       `json:"failed"`
+	Unresolved      int       `json:"unresolved"`
+	EtaSeconds      float64   `json:"etaSeconds"`
+	RateLimitWaitMs int64     `json:"rateLimitWaitMs"`
+	Time            time.Time `json:"time"`
+}
+
+const (
+	JobTypeImport  = "import"
+	JobTypeRefresh = "refresh"
+	JobTypeSetSync = "set_sync"
+
+	JobStatusRunning   = "running"
+	JobStatusPaused    = "paused"
+	JobStatusCancelled = "cancelled"
+	JobStatusFinished  = "finished"
+)
+
+// ApiKey grants its holder a role on the API. Only a hash of the key is stored; the key itself is shown once, when
+// it is created.
+type ApiKey struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name      string             `json:"name" bson:"name"`
+	Role      string             `json:"role" bson:"role"`
+	Prefix    string             `json:"prefix" bson:"prefix"`
+	Hash      string             `json:"-" bson:"hash"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// NewApiKey is a created API key together with the key itself.
+type NewApiKey struct {
+	ApiKey
+	Key string `json:"key"`
+}
+
+// Roles, each allowed everything the ones before it are.
+const (
+	RoleReader = "reader"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// DefaultCollectionId is the ID of the collection shared by every user, holding the cards stored before collections
+// existed. It has no stored Collection; access to it is decided by role alone.
+const DefaultCollectionId = "default"
+
+// Collection is a set of cards owned by one user, who can share a read-only view of it with others. Users are the
+// names of API keys or the subjects of JWTs.
+type Collection struct {
+	Id         string                `json:"id" bson:"_id"`
+	Name       string                `json:"name" bson:"name"`
+	Owner      string                `json:"owner" bson:"owner"`
+	SharedWith []string              `json:"sharedWith" bson:"sharedWith"`
+	Tcgplayer  *TcgplayerCredentials `json:"tcgplayer,omitempty" bson:"tcgplayer,omitempty"`
+	CreatedAt  time.Time             `json:"createdAt" bson:"createdAt"`
+}
+
+// TcgplayerCredentials are the tcgplayer.com API keys a collection's cards are retrieved with, instead of the configured
+// ones. The private key is never returned by the API.
+type TcgplayerCredentials struct {
+	PublicKey  string `json:"publicKey" bson:"publicKey"`
+	PrivateKey string `json:"-" bson:"privateKey"`
+}
@@ -3,442 +3,1165 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"os/signal"
+	"strconv"
 	"time"
 
 	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/config"
 	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/events"
 	"ygo-card-processor/pkg/external"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/processor"
 	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/progress"
 	"ygo-card-processor/pkg/reader"
+	"ygo-card-processor/pkg/scheduler"
+	"ygo-card-processor/pkg/valuation"
 
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var (
-	publicKey  = os.Getenv("PUBLIC_KEY")
-	privateKey = os.Getenv("PRIVATE_KEY")
+const (
+	defaultCatalogSearchLimit = 10
+	maxCatalogSearchLimit     = 50
+
+	// catalogPageSize is the largest page TCGplayer returns when listing groups or products.
+	catalogPageSize = 100
+
+	// defaultStalePriceAge is how old prices have to be to show up in the stale price report by default.
+	defaultStalePriceAge = 7 * 24 * time.Hour
 )
 
-func ListenAndServe() error {
-	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type"})
-	origins := handlers.AllowedOrigins([]string{"*"})
-	methods := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS", "DELETE"})
+// progressHeartbeat is how often a job progress stream without updates is kept alive, so proxies do not close it.
+const progressHeartbeat = 15 * time.Second
+
+// progressUpgrader upgrades job progress requests to WebSockets. Any origin is allowed, since clients authenticate with
+// a token rather than cookies, so other sites cannot make requests with a visitor's credentials.
+var progressUpgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+
+// catalogSearchParams maps the query parameters of GET /catalog/search to TCGplayer search filter names.
+var catalogSearchParams = []struct {
+	query  string
+	filter string
+}{
+	{query: "name", filter: "ProductName"},
+	{query: "set", filter: "SetName"},
+	{query: "rarity", filter: "Rarity"},
+}
 
-	router, err := route()
-	if err != nil {
-		return err
-	}
+func checkHealth(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
 
-	server := &http.Server{
-		Handler:      handlers.CORS(headers, origins, methods)(router),
-		Addr:         ":8001",
-		WriteTimeout: 5 * time.Second,
-		ReadTimeout:  5 * time.Second,
+		if err := handler.Ping(ctx); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "API is running but database ping failed")
+			return
+		}
+		respondWithSuccess(w, http.StatusOK, "API is running and connected to database")
+		return
 	}
-	shutdownGracefully(server)
-
-	logrus.Info("Starting API server...")
-	return server.ListenAndServe()
 }
 
-func route() (*mux.Router, error) {
-	dbClient, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGO_URI")))
-	if err != nil {
-		return nil, err
-	}
+func processCards(cardProcessor processor.CardProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
 
-	dbHandler := dao.MongoClient{
-		Client:     dbClient,
-		Database:   "db",
-		Collection: "yugioh",
-	}
+		budget, err := intQueryParam(r, "budget", 0)
+		if err != nil || budget < 0 {
+			respondWithError(w, http.StatusBadRequest, "Budget must be a non-negative integer")
+			return
+		}
 
-	client := http.Client{
-		Timeout: 5 * time.Second,
-	}
+		// The cards to refresh can be selected by a JSON body as well as by query parameters.
+		params := queryCardFilterParams(r)
+		if r.ContentLength > 0 {
+			if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+				logging.From(ctx).WithError(err).Error("Error decoding request body")
+				respondWithBodyError(w, err, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+		}
 
-	externalRetriever := external.Retriever{
-		Url:    "https://api.tcgplayer.com",
-		Client: client,
-		Token:  "",
-	}
+		filter, err := params.toCardFilter()
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 
-	fileReader := reader.Reader{}
+		job, err := cardProcessor.Refresh(ctx, filter, budget)
+		if errors.Is(err, processor.ErrRefreshInProgress) {
+			respondWithError(w, http.StatusConflict, "A refresh is already in progress")
+			return
+		} else if err != nil {
+			logging.From(ctx).WithError(err).Error("Error starting card processing")
+			respondWithError(w, http.StatusInternalServerError, "Error processing cards")
+			return
+		}
 
-	p, err := producer.CreateProducer(os.Getenv("BROKER"), os.Getenv("TOPIC"))
-	if err != nil {
-		logrus.WithError(err).Fatal("Failed to create producer")
-		return nil, err
+		respondWithSuccess(w, http.StatusOK, job)
+		return
 	}
+}
+
+func getCardByNumber(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
 
-	r := mux.NewRouter()
+		id := mux.Vars(r)["id"]
 
-	r.HandleFunc("/health", checkHealth(&dbHandler)).Methods(http.MethodGet)
-	r.HandleFunc("/process", processCards(&dbHandler, &externalRetriever, p)).Methods(http.MethodPost)
-	r.HandleFunc("/card/{id}", getCardByNumber(&dbHandler)).Methods(http.MethodGet)
-	r.HandleFunc("/card/{id}", addCardById(&dbHandler, &externalRetriever)).Methods(http.MethodPost)
-	r.HandleFunc("/card/{id}", updateCard(&dbHandler)).Methods(http.MethodPut)
-	r.HandleFunc("/card/{id}", deleteCard(&dbHandler)).Methods(http.MethodDelete)
-	r.HandleFunc("/cards", addCardsFromFile(&dbHandler, &externalRetriever, &fileReader)).Methods(http.MethodPost)
-	r.HandleFunc("/cards", getCards(&dbHandler)).Methods(http.MethodGet)
+		result, err := handler.GetCardByNumber(ctx, id)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving card")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving card")
+			return
+		}
 
-	return r, nil
+		respondWithSuccess(w, http.StatusOK, result)
+		return
+	}
 }
 
-func checkHealth(handler dao.DbHandler) http.HandlerFunc {
+func addCardsFromFile(fileReader reader.FileReader, cardProcessor processor.CardProcessor) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer closeRequestBody(r)
 		ctx := r.Context()
 
-		if err := handler.Ping(ctx); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "API is running but database ping failed")
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			logging.From(ctx).WithError(err).Error("Error parsing file")
+			respondWithBodyError(w, err, http.StatusInternalServerError, "Error adding cards")
 			return
 		}
-		respondWithSuccess(w, http.StatusOK, "API is running and connected to database")
+		f, _, err := r.FormFile("input")
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Failed to find file with key 'input'")
+			respondWithError(w, http.StatusInternalServerError, "Error adding cards")
+			return
+		}
+
+		defer func() {
+			closeRequestBody(r)
+			if err = f.Close(); err != nil {
+				logging.From(ctx).WithError(err).Error("Error closing file")
+			}
+		}()
+
+		cardList, err := fileReader.OpenAndReadFile(f)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error reading card list file")
+			respondWithError(w, http.StatusInternalServerError, "Error adding cards")
+			return
+		}
+
+		job, err := cardProcessor.ImportSerials(ctx, cardList)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error starting card import")
+			respondWithError(w, http.StatusInternalServerError, "Error adding cards")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, job)
 		return
 	}
 }
 
-func processCards(handler dao.DbHandler, retriever external.ExtRetriever, p producer.KafkaProducer) http.HandlerFunc {
+func addCardById(handler dao.DbHandler, retriever external.ExtRetriever, tcgplayer config.TcgplayerConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer closeRequestBody(r)
-		ctx := context.Background()
+		ctx := r.Context()
 
-		cardList, err := handler.GetCards(ctx, nil)
+		productId, err := intQueryParam(r, "productId", 0)
 		if err != nil {
-			logrus.WithError(err).Error("Error getting cards from database")
-			respondWithError(w, http.StatusInternalServerError, "Error getting cards from database")
+			logging.From(ctx).WithError(err).Error("Error parsing product ID")
+			respondWithError(w, http.StatusBadRequest, "Product ID must be an integer")
 			return
 		}
 
-		if err := retriever.RefreshToken(ctx, publicKey, privateKey); err != nil {
-			logrus.WithError(err).Error("Error refreshing token")
+		if err := processor.RefreshToken(ctx, handler, retriever, tcgplayer.PublicKey, tcgplayer.PrivateKey); err != nil {
+			logging.From(ctx).WithError(err).Error("Error refreshing token")
 			respondWithError(w, http.StatusInternalServerError, "Error adding card")
 			return
 		}
 
-		cardsAdded := 0
-		go func() {
-			p.Produce("processing_initiated", "card processing has started", false)
-			for _, serial := range cardList {
-				basicCardInfo, err := retriever.BasicCardSearch(ctx, serial.CardInfo.ExtendedData[0].Value)
-				if err != nil {
-					logrus.WithError(err).Error("Error performing basic card search")
-					p.Produce("processing_error", fmt.Sprintf("error performing basic card search on card with name '%v'", serial.CardInfo.Name), true)
-					continue
-				}
-				productId := basicCardInfo.Results[0]
+		serial := mux.Vars(r)["id"]
 
-				extendedCardInfo, err := retriever.ExtendedCardSearch(ctx, productId)
-				if err != nil {
-					logrus.WithError(err).Error("Error performing extended card search")
-					p.Produce("processing_error", fmt.Sprintf("error performing extended card search on card with name '%v'", serial.CardInfo.Name), true)
-					continue
-				}
-				cardInfo := extendedCardInfo.Results[0]
+		if productId == 0 {
+			var candidates []models.ProductCandidate
+			productId, candidates, err = processor.FindProduct(ctx, retriever, serial)
+			if err != nil {
+				logging.From(ctx).WithError(err).Error("Error performing basic card search")
+				respondWithError(w, http.StatusInternalServerError, "Error adding card")
+				return
+			}
+			if productId == 0 && len(candidates) == 0 {
+				respondWithError(w, http.StatusNotFound, "No products found with given serial number")
+				return
+			}
+			if productId == 0 {
+				respondWithSuccess(w, http.StatusMultipleChoices, models.AmbiguousSerial{Serial: serial, Candidates: candidates})
+				return
+			}
+		}
 
-				cardPricingInfo, err := retriever.GetCardPricingInfo(ctx, productId)
-				if err != nil {
-					logrus.WithError(err).Error("Error performing card price search")
-					p.Produce("processing_error", fmt.Sprintf("error performing card price search on card with name '%v'", serial.CardInfo.Name), true)
-					continue
-				}
+		cardInfoWithPrice, err := processor.GetCardWithPriceInfo(ctx, retriever, productId)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving card information")
+			respondWithError(w, http.StatusInternalServerError, "Error adding card")
+			return
+		}
 
-				priceResults := make([]models.PriceResults, 0)
-				for i := range cardPricingInfo.Results {
-					if cardPricingInfo.Results[i].MarketPrice != 0.0 {
-						priceResults = append(priceResults, cardPricingInfo.Results[i])
-					}
-				}
+		setOwnership(r, cardInfoWithPrice)
 
-				cardInfoWithPrice := models.CardWithPriceInfo{
-					CardInfo:  cardInfo,
-					PriceInfo: priceResults,
-				}
+		result, err := handler.AddCard(ctx, *cardInfoWithPrice)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error adding card to database")
+			respondWithError(w, http.StatusInternalServerError, "Error adding card")
+			return
+		}
 
-				if _, err := handler.UpdateCardByNumber(ctx, cardInfoWithPrice.CardInfo.ExtendedData[0].Value, cardInfoWithPrice); err != nil {
-					logrus.WithError(err).Error("Error updating card")
-					p.Produce("processing_error", fmt.Sprintf("error updating card with name '%v'", serial.CardInfo.Name), true)
-					continue
-				}
+		respondWithSuccess(w, http.StatusOK, result)
+		return
+	}
+}
 
-				cardsAdded++
-				logrus.Info(fmt.Sprintf("%v out of %v cards processed", cardsAdded, len(cardList)))
+func updateCard(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
 
-				/* One second delay after each card because TCG Player API limits users to 300 API calls per minute.
-				With three calls occurring per card, this ensures a maximum of 180 calls per minutes. */
-				time.Sleep(1 * time.Second)
-			}
-			p.Produce("processing_terminated", fmt.Sprintf("card processing has finished - %v cards processed", cardsAdded), false)
-		}()
+		id := mux.Vars(r)["id"]
+		objectId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error updating card")
+			respondWithError(w, http.StatusInternalServerError, "Error updating card")
+			return
+		}
 
-		respondWithSuccess(w, http.StatusOK, fmt.Sprintf("Processing cards has started"))
+		var card models.CardWithPriceInfo
+		if err := json.NewDecoder(r.Body).Decode(&card); err != nil {
+			logging.From(ctx).WithError(err).Error("Error decoding request body")
+			respondWithBodyError(w, err, http.StatusBadRequest, "Error updating card")
+			return
+		}
+
+		result, err := handler.UpdateCardById(ctx, objectId, card)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error updating card")
+			respondWithError(w, http.StatusInternalServerError, "Error updating card")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, result)
 		return
 	}
 }
 
-func getCardByNumber(handler dao.DbHandler) http.HandlerFunc {
+func deleteCard(handler dao.DbHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer closeRequestBody(r)
 		ctx := r.Context()
 
 		id := mux.Vars(r)["id"]
 
-		result, err := handler.GetCardByNumber(ctx, id)
+		err := handler.DeleteCard(ctx, id)
 		if err != nil {
-			logrus.WithError(err).Error("Error retrieving card")
-			respondWithError(w, http.StatusInternalServerError, "Error retrieving card")
+			logging.From(ctx).WithError(err).Error("Error deleting card")
+			respondWithError(w, http.StatusInternalServerError, "Error deleting card")
 			return
 		}
 
-		respondWithSuccess(w, http.StatusOK, result)
+		respondWithSuccess(w, http.StatusOK, "Deleted card")
+	}
+}
+
+func getCards(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		filter, err := queryCardFilterParams(r).toCardFilter()
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		results, err := handler.GetCards(ctx, dao.CardQuery(filter))
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error getting cards from database")
+			respondWithError(w, http.StatusInternalServerError, "Error getting cards from database")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, results)
 		return
 	}
 }
 
-func addCardsFromFile(handler dao.DbHandler, retriever external.ExtRetriever, fileReader reader.FileReader) http.HandlerFunc {
+func searchCatalog(handler dao.DbHandler, retriever external.ExtRetriever, tcgplayer config.TcgplayerConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer closeRequestBody(r)
 		ctx := r.Context()
 
-		if err := r.ParseMultipartForm(32 << 20); err != nil {
-			logrus.WithError(err).Error("Error parsing file")
-			respondWithError(w, http.StatusInternalServerError, "Error adding cards")
+		query := r.URL.Query()
+		filters := make([]models.CardSearchFilter, 0)
+		for _, param := range catalogSearchParams {
+			if value := query.Get(param.query); value != "" {
+				filters = append(filters, models.CardSearchFilter{Name: param.filter, Values: []string{value}})
+			}
+		}
+		if len(filters) == 0 {
+			respondWithError(w, http.StatusBadRequest, "At least one of name, set or rarity is required")
 			return
 		}
-		f, _, err := r.FormFile("input")
+
+		offset, err := intQueryParam(r, "offset", 0)
+		if err != nil || offset < 0 {
+			respondWithError(w, http.StatusBadRequest, "Offset must be a non-negative integer")
+			return
+		}
+		limit, err := intQueryParam(r, "limit", defaultCatalogSearchLimit)
+		if err != nil || limit <= 0 {
+			respondWithError(w, http.StatusBadRequest, "Limit must be a positive integer")
+			return
+		}
+		if limit > maxCatalogSearchLimit {
+			limit = maxCatalogSearchLimit
+		}
+
+		if err := processor.RefreshToken(ctx, handler, retriever, tcgplayer.PublicKey, tcgplayer.PrivateKey); err != nil {
+			logging.From(ctx).WithError(err).Error("Error refreshing token")
+			respondWithError(w, http.StatusInternalServerError, "Error searching catalog")
+			return
+		}
+
+		searchResponse, err := retriever.CatalogSearch(ctx, filters, offset, limit)
 		if err != nil {
-			logrus.WithError(err).Error("Failed to find file with key 'input'")
-			respondWithError(w, http.StatusInternalServerError, "Error adding cards")
+			logging.From(ctx).WithError(err).Error("Error performing catalog search")
+			respondWithError(w, http.StatusInternalServerError, "Error searching catalog")
 			return
 		}
 
-		defer func() {
-			closeRequestBody(r)
-			if err = f.Close(); err != nil {
-				logrus.WithError(err).Error("Error closing file")
+		result := models.CatalogSearchResult{
+			TotalItems: searchResponse.TotalItems,
+			Offset:     offset,
+			Limit:      limit,
+			Results:    make([]models.CardWithPriceInfo, 0),
+		}
+		if len(searchResponse.Results) == 0 {
+			respondWithSuccess(w, http.StatusOK, result)
+			return
+		}
+
+		extendedCardInfo, err := retriever.ExtendedCardSearchMultiple(ctx, searchResponse.Results)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error performing extended card search")
+			respondWithError(w, http.StatusInternalServerError, "Error searching catalog")
+			return
+		}
+
+		cardPricingInfo, err := retriever.GetCardPricingInfoMultiple(ctx, searchResponse.Results)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error performing card price search")
+			respondWithError(w, http.StatusInternalServerError, "Error searching catalog")
+			return
+		}
+
+		pricesByProduct := make(map[int][]models.PriceResults)
+		for _, price := range cardPricingInfo.Results {
+			pricesByProduct[price.ProductId] = append(pricesByProduct[price.ProductId], price)
+		}
+
+		for _, card := range extendedCardInfo.Results {
+			priceInfo := pricesByProduct[card.ProductId]
+			if priceInfo == nil {
+				priceInfo = make([]models.PriceResults, 0)
 			}
-		}()
+			result.Results = append(result.Results, models.CardWithPriceInfo{CardInfo: card, PriceInfo: priceInfo})
+		}
 
-		cardList, err := fileReader.OpenAndReadFile(f)
+		respondWithSuccess(w, http.StatusOK, result)
+		return
+	}
+}
+
+func addCardByProductId(handler dao.DbHandler, retriever external.ExtRetriever, tcgplayer config.TcgplayerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		productId, err := strconv.Atoi(mux.Vars(r)["productId"])
 		if err != nil {
-			logrus.WithError(err).Error("Error reading card list file")
-			respondWithError(w, http.StatusInternalServerError, "Error adding cards")
+			logging.From(ctx).WithError(err).Error("Error parsing product ID")
+			respondWithError(w, http.StatusBadRequest, "Product ID must be an integer")
 			return
 		}
 
-		if err := retriever.RefreshToken(ctx, publicKey, privateKey); err != nil {
-			logrus.WithError(err).Error("Error refreshing token")
+		if err := processor.RefreshToken(ctx, handler, retriever, tcgplayer.PublicKey, tcgplayer.PrivateKey); err != nil {
+			logging.From(ctx).WithError(err).Error("Error refreshing token")
 			respondWithError(w, http.StatusInternalServerError, "Error adding card")
 			return
 		}
 
-		cardsAdded := 0
-		go func() {
-			for _, serial := range cardList {
-				basicCardInfo, err := retriever.BasicCardSearch(ctx, serial)
-				if err != nil {
-					logrus.WithError(err).Error("Error performing basic card search")
-					continue
-				}
-				productId := basicCardInfo.Results[0]
+		cardInfoWithPrice, err := processor.GetCardWithPriceInfo(ctx, retriever, productId)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving card information")
+			respondWithError(w, http.StatusInternalServerError, "Error adding card")
+			return
+		}
 
-				extendedCardInfo, err := retriever.ExtendedCardSearch(ctx, productId)
-				if err != nil {
-					logrus.WithError(err).Error("Error performing extended card search")
-					continue
-				}
-				cardInfo := extendedCardInfo.Results[0]
+		setOwnership(r, cardInfoWithPrice)
+
+		result, err := handler.AddCard(ctx, *cardInfoWithPrice)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error adding card to database")
+			respondWithError(w, http.StatusInternalServerError, "Error adding card")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, result)
+		return
+	}
+}
 
-				cardPricingInfo, err := retriever.GetCardPricingInfo(ctx, productId)
+func syncSets(handler dao.DbHandler, retriever external.ExtRetriever, p producer.EventSink, tcgplayer config.TcgplayerConfig, clock Clock) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		if err := processor.RefreshToken(ctx, handler, retriever, tcgplayer.PublicKey, tcgplayer.PrivateKey); err != nil {
+			logging.From(ctx).WithError(err).Error("Error refreshing token")
+			respondWithError(w, http.StatusInternalServerError, "Error syncing sets")
+			return
+		}
+
+		groups, err := getAllGroups(ctx, retriever)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving groups")
+			respondWithError(w, http.StatusInternalServerError, "Error syncing sets")
+			return
+		}
+
+		job, err := processor.StartJob(ctx, handler, models.JobTypeSetSync, len(groups))
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error creating job")
+			respondWithError(w, http.StatusInternalServerError, "Error syncing sets")
+			return
+		}
+
+		// The job keeps changing while the sync runs, so the response is encoded from a copy.
+		snapshot := *job
+		go processor.RunJob(ctx, job, func(ctx context.Context) {
+			processor.PublishEvent(ctx, p, events.NewJobStartedEvent(ctx, *job))
+			for _, group := range groups {
+				products, err := getAllGroupProducts(ctx, retriever, group.GroupId)
 				if err != nil {
-					logrus.WithError(err).Error("Error performing card price search")
+					logging.From(ctx).WithError(err).Error("Error retrieving group products")
+					job.Failed++
+					processor.SaveJob(ctx, handler, job)
 					continue
 				}
 
-				priceResults := make([]models.PriceResults, 0)
-				for i := range cardPricingInfo.Results {
-					if cardPricingInfo.Results[i].MarketPrice != 0.0 {
-						priceResults = append(priceResults, cardPricingInfo.Results[i])
-					}
+				set := models.Set{
+					GroupId:      group.GroupId,
+					Name:         group.Name,
+					Abbreviation: group.Abbreviation,
+					ReleaseDate:  group.PublishedOn,
+					CardCount:    len(products),
+					Products:     make([]models.ProductCandidate, 0),
+					SyncedAt:     clock(),
 				}
-
-				cardInfoWithPrice := models.CardWithPriceInfo{
-					CardInfo:  cardInfo,
-					PriceInfo: priceResults,
+				for _, product := range products {
+					set.Products = append(set.Products, processor.ToProductCandidate(product))
 				}
 
-				_, err = handler.AddCard(ctx, cardInfoWithPrice)
-				if err != nil {
-					logrus.WithError(err).Error("Error adding card to database")
+				if err := handler.UpsertSet(ctx, set); err != nil {
+					logging.From(ctx).WithError(err).Error("Error saving set")
+					job.Failed++
+					processor.SaveJob(ctx, handler, job)
 					continue
 				}
-				cardsAdded++
-				logrus.Info(fmt.Sprintf("%v out of %v cards added", cardsAdded, len(cardList)))
 
-				/* One second delay after each card because TCG Player API limits users to 300 API calls per minute.
-				With three calls occurring per card, this ensures a maximum of 180 calls per minutes. */
+				job.Processed++
+				processor.SaveJob(ctx, handler, job)
+				logging.From(ctx).Info(fmt.Sprintf("%v out of %v sets synced", job.Processed, len(groups)))
+
+				// One second delay after each set to stay within the TCG Player API limit of 300 calls per minute.
 				time.Sleep(1 * time.Second)
 			}
-		}()
+			processor.FinishJob(ctx, handler, p, job)
+		})
 
-		respondWithSuccess(w, http.StatusOK, fmt.Sprintf("Adding cards has started. %v cards are being added.", len(cardList)))
+		respondWithSuccess(w, http.StatusOK, snapshot)
 		return
 	}
 }
 
-func addCardById(handler dao.DbHandler, retriever external.ExtRetriever) http.HandlerFunc {
+func getSets(handler dao.DbHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer closeRequestBody(r)
 		ctx := r.Context()
 
-		if err := retriever.RefreshToken(ctx, publicKey, privateKey); err != nil {
-			logrus.WithError(err).Error("Error refreshing token")
-			respondWithError(w, http.StatusInternalServerError, "Error adding card")
+		results, err := handler.GetSets(ctx)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error getting sets from database")
+			respondWithError(w, http.StatusInternalServerError, "Error getting sets from database")
 			return
 		}
 
-		serial := mux.Vars(r)["id"]
+		respondWithSuccess(w, http.StatusOK, results)
+		return
+	}
+}
+
+func getSet(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
 
-		basicCardInfo, err := retriever.BasicCardSearch(ctx, serial)
+		groupId, err := strconv.Atoi(mux.Vars(r)["id"])
 		if err != nil {
-			logrus.WithError(err).Error("Error performing basic card search")
-			respondWithError(w, http.StatusInternalServerError, "Error adding card")
+			logging.From(ctx).WithError(err).Error("Error parsing set ID")
+			respondWithError(w, http.StatusBadRequest, "Set ID must be an integer")
 			return
 		}
-		productId := basicCardInfo.Results[0]
 
-		extendedCardInfo, err := retriever.ExtendedCardSearch(ctx, productId)
+		set, err := handler.GetSet(ctx, groupId)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			respondWithError(w, http.StatusNotFound, "Set not found")
+			return
+		} else if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving set")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving set")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, set)
+		return
+	}
+}
+
+func getSetCompletion(handler dao.DbHandler, retriever external.ExtRetriever, tcgplayer config.TcgplayerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		groupId, err := strconv.Atoi(mux.Vars(r)["id"])
 		if err != nil {
-			logrus.WithError(err).Error("Error performing extended card search")
-			respondWithError(w, http.StatusInternalServerError, "Error adding card")
+			logging.From(ctx).WithError(err).Error("Error parsing set ID")
+			respondWithError(w, http.StatusBadRequest, "Set ID must be an integer")
+			return
+		}
+
+		set, err := handler.GetSet(ctx, groupId)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			respondWithError(w, http.StatusNotFound, "Set not found")
+			return
+		} else if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving set")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving set completion")
 			return
 		}
-		cardInfo := extendedCardInfo.Results[0]
 
-		cardPricingInfo, err := retriever.GetCardPricingInfo(ctx, productId)
+		cards, err := handler.GetCards(ctx, map[string]interface{}{"card.groupId": groupId})
 		if err != nil {
-			logrus.WithError(err).Error("Error performing card price search")
-			respondWithError(w, http.StatusInternalServerError, "Error adding card")
+			logging.From(ctx).WithError(err).Error("Error getting cards from database")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving set completion")
 			return
 		}
 
-		priceResults := make([]models.PriceResults, 0)
-		for i := range cardPricingInfo.Results {
-			if cardPricingInfo.Results[i].MarketPrice != 0.0 {
-				priceResults = append(priceResults, cardPricingInfo.Results[i])
-			}
+		if err := processor.RefreshToken(ctx, handler, retriever, tcgplayer.PublicKey, tcgplayer.PrivateKey); err != nil {
+			logging.From(ctx).WithError(err).Error("Error refreshing token")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving set completion")
+			return
 		}
 
-		cardInfoWithPrice := models.CardWithPriceInfo{
-			CardInfo:  cardInfo,
-			PriceInfo: priceResults,
+		prices, err := retriever.GetGroupPricingInfo(ctx, groupId)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error performing group price search")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving set completion")
+			return
 		}
 
-		result, err := handler.AddCard(ctx, cardInfoWithPrice)
+		respondWithSuccess(w, http.StatusOK, computeSetCompletion(*set, cards, prices.Results))
+		return
+	}
+}
+
+func getCollectionValue(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		cards, err := handler.GetCards(ctx, nil)
 		if err != nil {
-			logrus.WithError(err).Error("Error adding card to database")
-			respondWithError(w, http.StatusInternalServerError, "Error adding card")
+			logging.From(ctx).WithError(err).Error("Error getting cards from database")
+			respondWithError(w, http.StatusInternalServerError, "Error getting cards from database")
 			return
 		}
 
-		respondWithSuccess(w, http.StatusOK, result)
+		respondWithSuccess(w, http.StatusOK, valuation.CollectionValue(cards))
 		return
 	}
 }
 
-func updateCard(handler dao.DbHandler) http.HandlerFunc {
+func getStalePriceReport(handler dao.DbHandler, clock Clock) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer closeRequestBody(r)
 		ctx := r.Context()
 
-		id := mux.Vars(r)["id"]
-		objectId, err := primitive.ObjectIDFromHex(id)
+		olderThan := defaultStalePriceAge
+		if value := r.URL.Query().Get("olderThan"); value != "" {
+			var err error
+			if olderThan, err = time.ParseDuration(value); err != nil || olderThan <= 0 {
+				respondWithError(w, http.StatusBadRequest, "olderThan must be a positive duration such as '72h'")
+				return
+			}
+		}
+
+		// Cards added before prices were timestamped have no pricedAt at all, and are as stale as it gets.
+		filters := map[string]interface{}{
+			"$or": []interface{}{
+				map[string]interface{}{"pricedAt": map[string]interface{}{"$lt": clock().Add(-olderThan)}},
+				map[string]interface{}{"pricedAt": map[string]interface{}{"$exists": false}},
+			},
+		}
+
+		cards, err := handler.GetCards(ctx, filters)
 		if err != nil {
-			logrus.WithError(err).Error("Error updating card")
-			respondWithError(w, http.StatusInternalServerError, "Error updating card")
+			logging.From(ctx).WithError(err).Error("Error getting cards from database")
+			respondWithError(w, http.StatusInternalServerError, "Error getting cards from database")
 			return
 		}
 
-		var card models.CardWithPriceInfo
-		if err := json.NewDecoder(r.Body).Decode(&card); err != nil {
-			logrus.WithError(err).Error("Error decoding request body")
-			respondWithError(w, http.StatusBadRequest, "Error updating card")
+		respondWithSuccess(w, http.StatusOK, toPriceReport(cards))
+		return
+	}
+}
+
+func getNoMarketDataReport(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		cards, err := handler.GetCards(ctx, map[string]interface{}{"noMarketData": true})
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error getting cards from database")
+			respondWithError(w, http.StatusInternalServerError, "Error getting cards from database")
 			return
 		}
 
-		result, err := handler.UpdateCardById(ctx, objectId, card)
+		respondWithSuccess(w, http.StatusOK, toPriceReport(cards))
+		return
+	}
+}
+
+func getEventStats(p producer.EventSink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+
+		respondWithSuccess(w, http.StatusOK, p.Stats())
+		return
+	}
+}
+
+func getSchedules(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		schedules, err := handler.GetSchedules(ctx)
 		if err != nil {
-			logrus.WithError(err).Error("Error updating card")
-			respondWithError(w, http.StatusInternalServerError, "Error updating card")
+			logging.From(ctx).WithError(err).Error("Error retrieving schedules")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving schedules")
 			return
 		}
 
-		respondWithSuccess(w, http.StatusOK, result)
+		for i := range schedules {
+			setNextRun(&schedules[i])
+		}
+
+		respondWithSuccess(w, http.StatusOK, schedules)
 		return
 	}
 }
 
-func deleteCard(handler dao.DbHandler) http.HandlerFunc {
+func getSchedule(handler dao.DbHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer closeRequestBody(r)
 		ctx := r.Context()
 
-		id := mux.Vars(r)["id"]
+		schedule, err := handler.GetSchedule(ctx, mux.Vars(r)["name"])
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			respondWithError(w, http.StatusNotFound, "Schedule not found")
+			return
+		} else if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving schedule")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving schedule")
+			return
+		}
 
-		err := handler.DeleteCard(ctx, id)
+		setNextRun(schedule)
+		respondWithSuccess(w, http.StatusOK, schedule)
+		return
+	}
+}
+
+// putSchedule creates or replaces a schedule. The run history of an existing schedule is kept, so editing a schedule
+// does not make it run again immediately.
+func putSchedule(handler dao.DbHandler, clock Clock) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		var schedule models.Schedule
+		if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+			logging.From(ctx).WithError(err).Error("Error decoding request body")
+			respondWithBodyError(w, err, http.StatusBadRequest, "Error saving schedule")
+			return
+		}
+		schedule.Name = mux.Vars(r)["name"]
+
+		if err := scheduler.Validate(schedule); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if schedule.Collection != "" && schedule.Collection != models.DefaultCollectionId {
+			if _, err := handler.GetCollection(ctx, schedule.Collection); errors.Is(err, mongo.ErrNoDocuments) {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Collection '%v' does not exist", schedule.Collection))
+				return
+			} else if err != nil {
+				logging.From(ctx).WithError(err).Error("Error retrieving collection")
+				respondWithError(w, http.StatusInternalServerError, "Error saving schedule")
+				return
+			}
+		}
+
+		existing, err := handler.GetSchedule(ctx, schedule.Name)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			schedule.CreatedAt = clock()
+			schedule.LastRunAt = time.Time{}
+			schedule.LastJobId = ""
+		} else if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving schedule")
+			respondWithError(w, http.StatusInternalServerError, "Error saving schedule")
+			return
+		} else {
+			schedule.CreatedAt = existing.CreatedAt
+			schedule.LastRunAt = existing.LastRunAt
+			schedule.LastJobId = existing.LastJobId
+		}
+
+		if err := handler.UpsertSchedule(ctx, schedule); err != nil {
+			logging.From(ctx).WithError(err).Error("Error saving schedule")
+			respondWithError(w, http.StatusInternalServerError, "Error saving schedule")
+			return
+		}
+
+		setNextRun(&schedule)
+		respondWithSuccess(w, http.StatusOK, schedule)
+		return
+	}
+}
+
+func deleteSchedule(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		if err := handler.DeleteSchedule(ctx, mux.Vars(r)["name"]); err != nil {
+			logging.From(ctx).WithError(err).Error("Error deleting schedule")
+			respondWithError(w, http.StatusInternalServerError, "Error deleting schedule")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, "Deleted schedule")
+		return
+	}
+}
+
+func getJob(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
 		if err != nil {
-			logrus.WithError(err).Error("Error deleting card")
-			respondWithError(w, http.StatusInternalServerError, "Error deleting card")
+			logging.From(ctx).WithError(err).Error("Error parsing job ID")
+			respondWithError(w, http.StatusBadRequest, "Invalid job ID")
 			return
 		}
 
-		respondWithSuccess(w, http.StatusOK, "Deleted card")
+		job, err := handler.GetJob(ctx, id)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving job")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving job")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, job)
+		return
 	}
 }
 
-func getCards(handler dao.DbHandler) http.HandlerFunc {
+// getJobEvents streams a job's progress until it ends, as Server-Sent Events or, if the client asks to upgrade the
+// connection, as WebSocket messages.
+func getJobEvents(handler dao.DbHandler, broadcaster *progress.Broadcaster) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer closeRequestBody(r)
 		ctx := r.Context()
 
-		results, err := handler.GetCards(ctx, nil)
+		id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
 		if err != nil {
-			logrus.WithError(err).Error("Error getting cards from database")
-			respondWithError(w, http.StatusInternalServerError, "Error getting cards from database")
+			logging.From(ctx).WithError(err).Error("Error parsing job ID")
+			respondWithError(w, http.StatusBadRequest, "Invalid job ID")
 			return
 		}
 
-		respondWithSuccess(w, http.StatusOK, results)
+		// Subscribing before reading the job ensures an update published in between is not missed.
+		updates, latest, unsubscribe := broadcaster.Subscribe(id.Hex())
+		defer unsubscribe()
+
+		job, err := handler.GetJob(ctx, id)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			respondWithError(w, http.StatusNotFound, "Job not found")
+			return
+		} else if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving job")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving job")
+			return
+		}
+
+		current := progress.FromJob(*job)
+		if latest != nil {
+			current = *latest
+		}
+		// Only refresh and import jobs report progress, so for others the stored job is all there is to send.
+		if job.Type != models.JobTypeRefresh && job.Type != models.JobTypeImport {
+			updates = nil
+		}
+
+		if websocket.IsWebSocketUpgrade(r) {
+			streamProgressWebSocket(w, r, current, updates)
+			return
+		}
+		streamProgressEvents(w, r, current, updates)
+	}
+}
+
+func streamProgressEvents(w http.ResponseWriter, r *http.Request, current models.JobProgress, updates <-chan models.JobProgress) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming is not supported")
 		return
 	}
+
+	// The server's write timeout would otherwise end the stream after a few seconds.
+	clearWriteDeadline(r)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	streamProgress(r.Context().Done(), current, updates, func(update models.JobProgress) error {
+		data, err := json.Marshal(update)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}, func() error {
+		if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
 }
 
-func shutdownGracefully(server *http.Server) {
+func streamProgressWebSocket(w http.ResponseWriter, r *http.Request, current models.JobProgress, updates <-chan models.JobProgress) {
+	conn, err := progressUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logging.From(r.Context()).WithError(err).Error("Error upgrading to WebSocket")
+		return
+	}
+	defer conn.Close()
+
+	// Messages from the client are not expected, but reading is how a closed connection is noticed.
+	closed := make(chan struct{})
 	go func() {
-		signals := make(chan os.Signal, 1)
-		signal.Notify(signals, os.Interrupt)
-		<-signals
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
 
-		c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	streamProgress(closed, current, updates, func(update models.JobProgress) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(progressHeartbeat)); err != nil {
+			return err
+		}
+		return conn.WriteJSON(update)
+	}, func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(progressHeartbeat))
+	})
+
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(progressHeartbeat)); err != nil {
+		logging.From(r.Context()).WithError(err).Debug("Error closing WebSocket")
+	}
+}
 
-		if err := server.Shutdown(c); err != nil {
-			logrus.WithError(err).Error("Error shutting down server")
+// streamProgress sends the current progress of a job and then each update, until the job ends, there are no more
+// updates, or the client goes away.
+func streamProgress(done <-chan struct{}, current models.JobProgress, updates <-chan models.JobProgress, send func(models.JobProgress) error, heartbeat func() error) {
+	if err := send(current); err != nil || progress.Ended(current.Status) || updates == nil {
+		return
+	}
+
+	ticker := time.NewTicker(progressHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			if err := send(update); err != nil || progress.Ended(update.Status) {
+				return
+			}
+		case <-ticker.C:
+			if err := heartbeat(); err != nil {
+				return
+			}
 		}
+	}
+}
 
-		<-c.Done()
-		os.Exit(0)
-	}()
+func cancelJob(cardProcessor processor.CardProcessor) http.HandlerFunc {
+	return controlJob(cardProcessor.Cancel, "Cancelled job", "Error cancelling job")
+}
+
+func pauseJob(cardProcessor processor.CardProcessor) http.HandlerFunc {
+	return controlJob(cardProcessor.Pause, "Paused job", "Error pausing job")
+}
+
+func resumeJob(cardProcessor processor.CardProcessor) http.HandlerFunc {
+	return controlJob(cardProcessor.Resume, "Resumed job", "Error resuming job")
+}
+
+// controlJob handles the routes that cancel, pause and resume jobs, which differ only in the processor method called.
+func controlJob(control func(ctx context.Context, id primitive.ObjectID) error, success string, failure string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error parsing job ID")
+			respondWithError(w, http.StatusBadRequest, "Invalid job ID")
+			return
+		}
+
+		err = control(ctx, id)
+		switch {
+		case err == nil:
+			respondWithSuccess(w, http.StatusOK, success)
+		case errors.Is(err, mongo.ErrNoDocuments):
+			respondWithError(w, http.StatusNotFound, "Job not found")
+		case errors.Is(err, processor.ErrJobNotRunning), errors.Is(err, processor.ErrJobNotPaused),
+			errors.Is(err, processor.ErrJobNotControllable), errors.Is(err, processor.ErrRefreshInProgress),
+			errors.Is(err, processor.ErrJobClaimed):
+			respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			logging.From(ctx).WithError(err).Error(failure)
+			respondWithError(w, http.StatusInternalServerError, failure)
+		}
+		return
+	}
+}
+
+// setOwnership sets the condition, printing and language of the copy being added from the request's query parameters.
+func setOwnership(r *http.Request, card *models.CardWithPriceInfo) {
+	query := r.URL.Query()
+	card.Condition = query.Get("condition")
+	card.Printing = query.Get("printing")
+	card.Language = query.Get("language")
+}
+
+func toPriceReport(cards []models.CardWithPriceInfo) models.PriceReport {
+	report := models.PriceReport{
+		Count: len(cards),
+		Cards: make([]models.PriceReportRow, 0),
+	}
+	for _, card := range cards {
+		report.Cards = append(report.Cards, models.PriceReportRow{
+			Serial:       card.CardInfo.Serial(),
+			Name:         card.CardInfo.Name,
+			ProductId:    card.CardInfo.ProductId,
+			PricedAt:     card.PricedAt,
+			PriceSource:  card.PriceSource,
+			NoMarketData: card.NoMarketData,
+		})
+	}
+	return report
+}
+
+func getAllGroups(ctx context.Context, retriever external.ExtRetriever) ([]models.Group, error) {
+	groups := make([]models.Group, 0)
+	for {
+		groupResponse, err := retriever.GetGroups(ctx, len(groups), catalogPageSize)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, groupResponse.Results...)
+		if len(groupResponse.Results) == 0 || len(groups) >= groupResponse.TotalItems {
+			return groups, nil
+		}
+	}
+}
+
+func getAllGroupProducts(ctx context.Context, retriever external.ExtRetriever, groupId int) ([]models.Card, error) {
+	products := make([]models.Card, 0)
+	for {
+		productResponse, err := retriever.GetGroupProducts(ctx, groupId, len(products), catalogPageSize)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, productResponse.Results...)
+		if len(productResponse.Results) == 0 || len(products) >= productResponse.TotalItems {
+			return products, nil
+		}
+	}
+}
+
+// computeSetCompletion splits the products of a set into owned and missing ones. The cost to complete the set is the
+// sum of the lowest market price among the printings of each missing card.
+func computeSetCompletion(set models.Set, cards []models.CardWithPriceInfo, prices []models.PriceResults) models.SetCompletion {
+	owned := make(map[int]bool)
+	for _, card := range cards {
+		owned[card.CardInfo.ProductId] = true
+	}
+
+	marketPrices := make(map[int]float64)
+	for _, price := range prices {
+		if current, ok := marketPrices[price.ProductId]; price.MarketPrice != 0.0 && (!ok || price.MarketPrice < current) {
+			marketPrices[price.ProductId] = price.MarketPrice
+		}
+	}
+
+	completion := models.SetCompletion{
+		GroupId:   set.GroupId,
+		Name:      set.Name,
+		CardCount: set.CardCount,
+		Owned:     make([]models.ProductCandidate, 0),
+		Missing:   make([]models.MissingCard, 0),
+	}
+	for _, product := range set.Products {
+		if owned[product.ProductId] {
+			completion.Owned = append(completion.Owned, product)
+			continue
+		}
+		completion.Missing = append(completion.Missing, models.MissingCard{
+			ProductCandidate: product,
+			MarketPrice:      marketPrices[product.ProductId],
+		})
+		completion.CostToComplete += marketPrices[product.ProductId]
+	}
+
+	completion.OwnedCount = len(completion.Owned)
+	completion.MissingCount = len(completion.Missing)
+	if set.CardCount > 0 {
+		completion.Completion = float64(completion.OwnedCount) / float64(set.CardCount) * 100
+	}
+	return completion
+}
+
+// setNextRun sets when an enabled schedule runs next, leaving NextRunAt as it is for disabled schedules and invalid
+// cron expressions.
+func setNextRun(schedule *models.Schedule) {
+	if nextRun, err := scheduler.NextRun(*schedule); err == nil && !schedule.Disabled {
+		schedule.NextRunAt = nextRun
+	}
+}
+
+// cardFilterParams selects cards for GET /cards and POST /process, given as query parameters or, for POST /process, as
+// a JSON body. Dates are kept as strings so both can accept a plain date as well as a timestamp.
+type cardFilterParams struct {
+	Serials        []string `json:"serials"`
+	GroupId        int      `json:"groupId"`
+	Rarity         string   `json:"rarity"`
+	MinMarketPrice float64  `json:"minMarketPrice"`
+	PricedBefore   string   `json:"pricedBefore"`
+	groupIdErr     error
+	minPriceErr    error
+}
+
+func queryCardFilterParams(r *http.Request) cardFilterParams {
+	query := r.URL.Query()
+	params := cardFilterParams{
+		Serials:      query["serial"],
+		Rarity:       query.Get("rarity"),
+		PricedBefore: query.Get("pricedBefore"),
+	}
+	params.GroupId, params.groupIdErr = intQueryParam(r, "groupId", 0)
+	if value := query.Get("minMarketPrice"); value != "" {
+		params.MinMarketPrice, params.minPriceErr = strconv.ParseFloat(value, 64)
+	}
+	return params
+}
+
+func (p cardFilterParams) toCardFilter() (models.CardFilter, error) {
+	if p.groupIdErr != nil || p.GroupId < 0 {
+		return models.CardFilter{}, errors.New("groupId must be a tcgplayer.com group ID")
+	}
+	if p.minPriceErr != nil || p.MinMarketPrice < 0 {
+		return models.CardFilter{}, errors.New("minMarketPrice must be a non-negative number")
+	}
+
+	filter := models.CardFilter{
+		Serials:        p.Serials,
+		GroupId:        p.GroupId,
+		Rarity:         p.Rarity,
+		MinMarketPrice: p.MinMarketPrice,
+	}
+	if p.PricedBefore != "" {
+		pricedBefore, err := time.Parse(time.RFC3339, p.PricedBefore)
+		if err != nil {
+			if pricedBefore, err = time.Parse("2006-01-02", p.PricedBefore); err != nil {
+				return models.CardFilter{}, errors.New("pricedBefore must be a date such as '2021-03-01' or an RFC 3339 timestamp")
+			}
+		}
+		filter.PricedBefore = pricedBefore
+	}
+	return filter, nil
+}
+
+// intQueryParam parses an integer query parameter, returning defaultValue if the parameter is not given.
+func intQueryParam(r *http.Request, key string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
 }
 
 func respondWithSuccess(w http.ResponseWriter, code int, body interface{}) {
@@ -470,7 +1193,7 @@ func closeRequestBody(req *http.Request) {
 		return
 	}
 	if err := req.Body.Close(); err != nil {
-		logrus.WithError(err).Error("Error closing request body")
+		logging.From(req.Context()).WithError(err).Error("Error closing request body")
 		return
 	}
 	return
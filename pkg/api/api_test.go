@@ -2,7 +2,10 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -11,12 +14,22 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/config"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/processor"
+	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/progress"
+	"ygo-card-processor/pkg/tenant"
 	"ygo-card-processor/pkg/testhelper/mocks"
 )
 
@@ -46,178 +59,151 @@ func TestApi_CheckHealth_ShouldReturn200IfHandlerReturnsNoError(t *testing.T) {
 	require.Equal(t, 200, recorder.Code)
 }
 
-func TestApi_ProcessCards_ShouldReturn500IfUnableToRetrieveCardsFromDatabase(t *testing.T) {
-	dbHandler := &mocks.DbHandler{}
-	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
-
-	retriever := &mocks.ExtRetriever{}
-
-	producer := &mocks.KafkaProducer{}
-	producer.On("Produce", mock.Anything, mock.Anything, mock.Anything)
+func TestApi_ProcessCards_ShouldReturn500IfProcessingCannotStart(t *testing.T) {
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Refresh", mock.Anything, models.CardFilter{}, 0).Return(nil, errors.New("test"))
 
 	req, err := http.NewRequest(http.MethodPost, "/process", nil)
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(processCards(dbHandler, retriever, producer))
+	httpHandler := http.HandlerFunc(processCards(cardProcessor))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 500, recorder.Code)
 }
 
-func TestApi_ProcessCards_ShouldReturn500IfUnableToRefreshToken(t *testing.T) {
-	dbHandler := &mocks.DbHandler{}
-	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{}, nil)
-
-	retriever := &mocks.ExtRetriever{}
-	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("test"))
-
-	producer := &mocks.KafkaProducer{}
-	producer.On("Produce", mock.Anything, mock.Anything, mock.Anything)
+func TestApi_ProcessCards_ShouldReturn200AndJobIfProcessingStarts(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeRefresh, Status: models.JobStatusRunning}
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Refresh", mock.Anything, models.CardFilter{}, 0).Return(job, nil)
 
 	req, err := http.NewRequest(http.MethodPost, "/process", nil)
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(processCards(dbHandler, retriever, producer))
+	httpHandler := http.HandlerFunc(processCards(cardProcessor))
 	httpHandler.ServeHTTP(recorder, req)
-	require.Equal(t, 500, recorder.Code)
+	require.Equal(t, 200, recorder.Code)
+
+	var response models.Job
+	require.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	require.Equal(t, job.Id, response.Id)
 }
 
-func TestApi_ProcessCards_ShouldReturn200ButNotAddCardIfBasicCardSearchFails(t *testing.T) {
-	dbHandler := &mocks.DbHandler{}
-	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{
-		{CardInfo: models.Card{ExtendedData: []models.ExtendedData{{Value: "test"}}}, PriceInfo: []models.PriceResults{}},
-	}, nil)
+func TestApi_ProcessCards_ShouldRespondWithJobWhileItRuns(t *testing.T) {
+	cards := make([]models.CardWithPriceInfo, 0)
+	for i := 0; i < 20; i++ {
+		serial := fmt.Sprintf("LOB-%03d", i)
+		cards = append(cards, models.CardWithPriceInfo{CardInfo: models.Card{ExtendedData: []models.ExtendedData{{Value: serial}}}})
+	}
 
+	finished := make(chan struct{})
+	dbHandler := &mocks.DbHandler{}
+	allowLocks(dbHandler)
+	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return(cards, nil)
+	dbHandler.On("AddJob", mock.Anything, mock.Anything).Return(primitive.NewObjectID(), nil)
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		if args.Get(1).(models.Job).Status == models.JobStatusFinished {
+			close(finished)
+		}
+	})
 	retriever := &mocks.ExtRetriever{}
 	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
-
-	producer := &mocks.KafkaProducer{}
-	producer.On("Produce", mock.Anything, mock.Anything, mock.Anything)
+	cardProcessor := &processor.Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
 
 	req, err := http.NewRequest(http.MethodPost, "/process", nil)
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(processCards(dbHandler, retriever, producer))
+	httpHandler := http.HandlerFunc(processCards(cardProcessor))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 200, recorder.Code)
-}
 
-func TestApi_ProcessCards_ShouldReturn200ButNotAddCardIfExtendedCardSearchFails(t *testing.T) {
-	dbHandler := &mocks.DbHandler{}
-	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{
-		{CardInfo: models.Card{ExtendedData: []models.ExtendedData{{Value: "test"}}}, PriceInfo: []models.PriceResults{}},
-	}, nil)
+	var response models.Job
+	require.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	require.Equal(t, len(cards), response.Total)
 
-	retriever := &mocks.ExtRetriever{}
-	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{
-		Results: []int{123},
-	}, nil)
-	retriever.On("ExtendedCardSearch", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("refresh did not finish")
+	}
+}
 
-	producer := &mocks.KafkaProducer{}
-	producer.On("Produce", mock.Anything, mock.Anything, mock.Anything)
+func TestApi_ProcessCards_ShouldRefreshWithinBudget(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeRefresh, Status: models.JobStatusRunning}
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Refresh", mock.Anything, models.CardFilter{}, 5).Return(job, nil)
 
-	req, err := http.NewRequest(http.MethodPost, "/process", nil)
+	req, err := http.NewRequest(http.MethodPost, "/process?budget=5", nil)
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(processCards(dbHandler, retriever, producer))
+	httpHandler := http.HandlerFunc(processCards(cardProcessor))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 200, recorder.Code)
+	cardProcessor.AssertExpectations(t)
 }
 
-func TestApi_ProcessCards_ShouldReturn200ButNotAddCardIfPricingSearchFails(t *testing.T) {
-	dbHandler := &mocks.DbHandler{}
-	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{
-		{CardInfo: models.Card{ExtendedData: []models.ExtendedData{{Value: "test"}}}, PriceInfo: []models.PriceResults{}},
-	}, nil)
-
-	retriever := &mocks.ExtRetriever{}
-	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{
-		Results: []int{123},
-	}, nil)
-	retriever.On("ExtendedCardSearch", mock.Anything, mock.Anything).Return(&models.ExtendedSearchResponse{
-		Results: []models.Card{{}},
-	}, nil)
-	retriever.On("GetCardPricingInfo", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
-
-	producer := &mocks.KafkaProducer{}
-	producer.On("Produce", mock.Anything, mock.Anything, mock.Anything)
+func TestApi_ProcessCards_ShouldRefreshCardsSelectedByQueryParameters(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeRefresh, Status: models.JobStatusRunning}
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Refresh", mock.Anything, models.CardFilter{
+		Serials:      []string{"LOB-001", "LOB-005"},
+		Rarity:       "Ultra Rare",
+		PricedBefore: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+	}, 0).Return(job, nil)
 
-	req, err := http.NewRequest(http.MethodPost, "/process", nil)
+	req, err := http.NewRequest(http.MethodPost, "/process?serial=LOB-001&serial=LOB-005&rarity=Ultra+Rare&pricedBefore=2021-03-01", nil)
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(processCards(dbHandler, retriever, producer))
+	httpHandler := http.HandlerFunc(processCards(cardProcessor))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 200, recorder.Code)
+	cardProcessor.AssertExpectations(t)
 }
 
-func TestApi_ProcessCards_ShouldReturn200ButNotAddCardIfUpdateFails(t *testing.T) {
-	dbHandler := &mocks.DbHandler{}
-	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{
-		{CardInfo: models.Card{ExtendedData: []models.ExtendedData{{Value: "test"}}}, PriceInfo: []models.PriceResults{}},
-	}, nil)
-	dbHandler.On("UpdateCardByNumber", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("test"))
-
-	retriever := &mocks.ExtRetriever{}
-	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{
-		Results: []int{123},
-	}, nil)
-	retriever.On("ExtendedCardSearch", mock.Anything, mock.Anything).Return(&models.ExtendedSearchResponse{
-		Results: []models.Card{{ExtendedData: []models.ExtendedData{{Value: "test"}}}},
-	}, nil)
-	retriever.On("GetCardPricingInfo", mock.Anything, mock.Anything).Return(&models.PriceResponse{
-		Results: []models.PriceResults{{MarketPrice: 3.00}},
-	}, nil)
-
-	producer := &mocks.KafkaProducer{}
-	producer.On("Produce", mock.Anything, mock.Anything, mock.Anything)
+func TestApi_ProcessCards_ShouldRefreshCardsSelectedByBody(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeRefresh, Status: models.JobStatusRunning}
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Refresh", mock.Anything, models.CardFilter{GroupId: 2750, MinMarketPrice: 20}, 0).Return(job, nil)
 
-	req, err := http.NewRequest(http.MethodPost, "/process", nil)
+	req, err := http.NewRequest(http.MethodPost, "/process", strings.NewReader(`{"groupId": 2750, "minMarketPrice": 20}`))
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(processCards(dbHandler, retriever, producer))
+	httpHandler := http.HandlerFunc(processCards(cardProcessor))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 200, recorder.Code)
+	cardProcessor.AssertExpectations(t)
 }
 
-func TestApi_ProcessCards_ShouldReturn200AndAddCard(t *testing.T) {
-	dbHandler := &mocks.DbHandler{}
-	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{
-		{CardInfo: models.Card{ExtendedData: []models.ExtendedData{{Value: "test"}}}, PriceInfo: []models.PriceResults{}},
-	}, nil)
-	dbHandler.On("UpdateCardByNumber", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+func TestApi_ProcessCards_ShouldReturn400IfFilterInvalid(t *testing.T) {
+	cardProcessor := &mocks.CardProcessor{}
 
-	retriever := &mocks.ExtRetriever{}
-	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{
-		Results: []int{123},
-	}, nil)
-	retriever.On("ExtendedCardSearch", mock.Anything, mock.Anything).Return(&models.ExtendedSearchResponse{
-		Results: []models.Card{{ExtendedData: []models.ExtendedData{{Value: "test"}}}},
-	}, nil)
-	retriever.On("GetCardPricingInfo", mock.Anything, mock.Anything).Return(&models.PriceResponse{
-		Results: []models.PriceResults{{MarketPrice: 3.00}},
-	}, nil)
+	req, err := http.NewRequest(http.MethodPost, "/process", strings.NewReader(`{"pricedBefore": "last week"}`))
+	require.Nil(t, err)
 
-	producer := &mocks.KafkaProducer{}
-	producer.On("Produce", mock.Anything, mock.Anything, mock.Anything)
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(processCards(cardProcessor))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+	cardProcessor.AssertNotCalled(t, "Refresh", mock.Anything, mock.Anything, mock.Anything)
+}
 
-	req, err := http.NewRequest(http.MethodPost, "/process", nil)
+func TestApi_ProcessCards_ShouldReturn400IfBudgetInvalid(t *testing.T) {
+	cardProcessor := &mocks.CardProcessor{}
+
+	req, err := http.NewRequest(http.MethodPost, "/process?budget=-1", nil)
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(processCards(dbHandler, retriever, producer))
+	httpHandler := http.HandlerFunc(processCards(cardProcessor))
 	httpHandler.ServeHTTP(recorder, req)
-	require.Equal(t, 200, recorder.Code)
+	require.Equal(t, 400, recorder.Code)
+	cardProcessor.AssertNotCalled(t, "Refresh", mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestApi_GetCardByNumber_ShouldReturn500IfHandlerReturnsError(t *testing.T) {
@@ -247,8 +233,6 @@ func TestApi_GetCardByNumber_ShouldReturn200IfHandlerReturnsNoError(t *testing.T
 }
 
 func TestApi_AddCardsFromFile_ShouldReturn500IfCannotParseMultipartForm(t *testing.T) {
-	dbHandler := &mocks.DbHandler{}
-	retriever := &mocks.ExtRetriever{}
 	fileReader := &mocks.FileReader{}
 
 	req, err := http.NewRequest(http.MethodPost, "/cards", nil)
@@ -257,7 +241,7 @@ func TestApi_AddCardsFromFile_ShouldReturn500IfCannotParseMultipartForm(t *testi
 	req.MultipartForm = nil
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardsFromFile(dbHandler, retriever, fileReader))
+	httpHandler := http.HandlerFunc(addCardsFromFile(fileReader, &mocks.CardProcessor{}))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 500, recorder.Code)
 }
@@ -283,12 +267,10 @@ func TestApi_AddCardsFromFile_ShouldReturn500IfCannotFindFileWithKeyInput(t *tes
 	require.Nil(t, err)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	dbHandler := &mocks.DbHandler{}
-	retriever := &mocks.ExtRetriever{}
 	fileReader := &mocks.FileReader{}
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardsFromFile(dbHandler, retriever, fileReader))
+	httpHandler := http.HandlerFunc(addCardsFromFile(fileReader, &mocks.CardProcessor{}))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 500, recorder.Code)
 }
@@ -314,18 +296,16 @@ func TestApi_AddCardsFromFile_ShouldReturn500IfReaderReturnsError(t *testing.T)
 	require.Nil(t, err)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	dbHandler := &mocks.DbHandler{}
-	retriever := &mocks.ExtRetriever{}
 	fileReader := &mocks.FileReader{}
 	fileReader.On("OpenAndReadFile", mock.Anything).Return(nil, errors.New("test"))
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardsFromFile(dbHandler, retriever, fileReader))
+	httpHandler := http.HandlerFunc(addCardsFromFile(fileReader, &mocks.CardProcessor{}))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 500, recorder.Code)
 }
 
-func TestApi_AddCardsFromFile_ShouldReturn500IfRefreshTokenReturnsError(t *testing.T) {
+func TestApi_AddCardsFromFile_ShouldReturn500IfImportCannotStart(t *testing.T) {
 	path := "../testhelper/output.xlsx"
 	file, err := os.Open(path)
 	require.Nil(t, err)
@@ -346,173 +326,18 @@ func TestApi_AddCardsFromFile_ShouldReturn500IfRefreshTokenReturnsError(t *testi
 	require.Nil(t, err)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	dbHandler := &mocks.DbHandler{}
-	retriever := &mocks.ExtRetriever{}
-	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("test"))
 	fileReader := &mocks.FileReader{}
 	fileReader.On("OpenAndReadFile", mock.Anything).Return([]string{"TEST"}, nil)
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("ImportSerials", mock.Anything, []string{"TEST"}).Return(nil, errors.New("test"))
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardsFromFile(dbHandler, retriever, fileReader))
+	httpHandler := http.HandlerFunc(addCardsFromFile(fileReader, cardProcessor))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 500, recorder.Code)
 }
 
-func TestApi_AddCardsFromFile_ShouldReturn200ButUpdateNoCardsIfBasicCardSearchFails(t *testing.T) {
-	path := "../testhelper/output.xlsx"
-	file, err := os.Open(path)
-	require.Nil(t, err)
-	defer func() {
-		if err := file.Close(); err != nil {
-			t.Fatal("Unable to close file")
-		}
-	}()
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("input", filepath.Base(path))
-	_, err = io.Copy(part, file)
-	require.Nil(t, err)
-	require.Nil(t, writer.Close())
-
-	req, err := http.NewRequest(http.MethodPost, "/cards", body)
-	require.Nil(t, err)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	dbHandler := &mocks.DbHandler{}
-	retriever := &mocks.ExtRetriever{}
-	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
-	fileReader := &mocks.FileReader{}
-	fileReader.On("OpenAndReadFile", mock.Anything).Return([]string{"TEST"}, nil)
-
-	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardsFromFile(dbHandler, retriever, fileReader))
-	httpHandler.ServeHTTP(recorder, req)
-	require.Equal(t, 200, recorder.Code)
-}
-
-func TestApi_AddCardsFromFile_ShouldReturn200ButUpdateNoCardsIfExtendedCardSearchFails(t *testing.T) {
-	path := "../testhelper/output.xlsx"
-	file, err := os.Open(path)
-	require.Nil(t, err)
-	defer func() {
-		if err := file.Close(); err != nil {
-			t.Fatal("Unable to close file")
-		}
-	}()
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("input", filepath.Base(path))
-	_, err = io.Copy(part, file)
-	require.Nil(t, err)
-	require.Nil(t, writer.Close())
-
-	req, err := http.NewRequest(http.MethodPost, "/cards", body)
-	require.Nil(t, err)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	dbHandler := &mocks.DbHandler{}
-	retriever := &mocks.ExtRetriever{}
-	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{
-		Results: []int{123},
-	}, nil)
-	retriever.On("ExtendedCardSearch", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
-	fileReader := &mocks.FileReader{}
-	fileReader.On("OpenAndReadFile", mock.Anything, mock.Anything).Return([]string{"TEST"}, nil)
-
-	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardsFromFile(dbHandler, retriever, fileReader))
-	httpHandler.ServeHTTP(recorder, req)
-	require.Equal(t, 200, recorder.Code)
-}
-
-func TestApi_AddCardsFromFile_ShouldReturn200ButUpdateNoCardsIfPricingSearchFails(t *testing.T) {
-	path := "../testhelper/output.xlsx"
-	file, err := os.Open(path)
-	require.Nil(t, err)
-	defer func() {
-		if err := file.Close(); err != nil {
-			t.Fatal("Unable to close file")
-		}
-	}()
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("input", filepath.Base(path))
-	_, err = io.Copy(part, file)
-	require.Nil(t, err)
-	require.Nil(t, writer.Close())
-
-	req, err := http.NewRequest(http.MethodPost, "/cards", body)
-	require.Nil(t, err)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	dbHandler := &mocks.DbHandler{}
-	retriever := &mocks.ExtRetriever{}
-	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{
-		Results: []int{123},
-	}, nil)
-	retriever.On("ExtendedCardSearch", mock.Anything, mock.Anything).Return(&models.ExtendedSearchResponse{
-		Results: []models.Card{{}},
-	}, nil)
-	retriever.On("GetCardPricingInfo", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
-	fileReader := &mocks.FileReader{}
-	fileReader.On("OpenAndReadFile", mock.Anything).Return([]string{"TEST"}, nil)
-
-	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardsFromFile(dbHandler, retriever, fileReader))
-	httpHandler.ServeHTTP(recorder, req)
-	require.Equal(t, 200, recorder.Code)
-}
-
-func TestApi_AddCardsFromFile_ShouldReturn200ButUpdateNoCardsIfAddFails(t *testing.T) {
-	path := "../testhelper/output.xlsx"
-	file, err := os.Open(path)
-	require.Nil(t, err)
-	defer func() {
-		if err := file.Close(); err != nil {
-			t.Fatal("Unable to close file")
-		}
-	}()
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("input", filepath.Base(path))
-	_, err = io.Copy(part, file)
-	require.Nil(t, err)
-	require.Nil(t, writer.Close())
-
-	req, err := http.NewRequest(http.MethodPost, "/cards", body)
-	require.Nil(t, err)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	dbHandler := &mocks.DbHandler{}
-	dbHandler.On("AddCard", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
-	retriever := &mocks.ExtRetriever{}
-	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{
-		Results: []int{123},
-	}, nil)
-	retriever.On("ExtendedCardSearch", mock.Anything, mock.Anything).Return(&models.ExtendedSearchResponse{
-		Results: []models.Card{{}},
-	}, nil)
-	retriever.On("GetCardPricingInfo", mock.Anything, mock.Anything).Return(&models.PriceResponse{
-		Results: []models.PriceResults{{MarketPrice: 3.00}},
-	}, nil)
-	fileReader := &mocks.FileReader{}
-	fileReader.On("OpenAndReadFile", mock.Anything).Return([]string{"TEST"}, nil)
-
-	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardsFromFile(dbHandler, retriever, fileReader))
-	httpHandler.ServeHTTP(recorder, req)
-	require.Equal(t, 200, recorder.Code)
-}
-
-func TestApi_AddCardsFromFile_ShouldReturn200AndAddCardIfNoErrors(t *testing.T) {
+func TestApi_AddCardsFromFile_ShouldReturn200AndJobIfImportStarts(t *testing.T) {
 	path := "../testhelper/output.xlsx"
 	file, err := os.Open(path)
 	require.Nil(t, err)
@@ -533,26 +358,17 @@ func TestApi_AddCardsFromFile_ShouldReturn200AndAddCardIfNoErrors(t *testing.T)
 	require.Nil(t, err)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	dbHandler := &mocks.DbHandler{}
-	dbHandler.On("AddCard", mock.Anything, mock.Anything).Return(nil, nil)
-	retriever := &mocks.ExtRetriever{}
-	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{
-		Results: []int{123},
-	}, nil)
-	retriever.On("ExtendedCardSearch", mock.Anything, mock.Anything).Return(&models.ExtendedSearchResponse{
-		Results: []models.Card{{}},
-	}, nil)
-	retriever.On("GetCardPricingInfo", mock.Anything, mock.Anything).Return(&models.PriceResponse{
-		Results: []models.PriceResults{{MarketPrice: 3.00}},
-	}, nil)
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeImport, Status: models.JobStatusRunning}
 	fileReader := &mocks.FileReader{}
 	fileReader.On("OpenAndReadFile", mock.Anything).Return([]string{"TEST"}, nil)
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("ImportSerials", mock.Anything, []string{"TEST"}).Return(job, nil)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardsFromFile(dbHandler, retriever, fileReader))
+	httpHandler := http.HandlerFunc(addCardsFromFile(fileReader, cardProcessor))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 200, recorder.Code)
+	cardProcessor.AssertExpectations(t)
 }
 
 func TestApi_AddCardById_ShouldReturn500IfRefreshTokenFails(t *testing.T) {
@@ -564,7 +380,7 @@ func TestApi_AddCardById_ShouldReturn500IfRefreshTokenFails(t *testing.T) {
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever))
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 500, recorder.Code)
 }
@@ -579,7 +395,7 @@ func TestApi_AddCardById_ShouldReturn500IfBasicSearchFails(t *testing.T) {
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever))
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 500, recorder.Code)
 }
@@ -597,7 +413,7 @@ func TestApi_AddCardById_ShouldReturn500IfExtendedSearchFails(t *testing.T) {
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever))
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 500, recorder.Code)
 }
@@ -618,7 +434,7 @@ func TestApi_AddCardById_ShouldReturn500IfPricingSearchFails(t *testing.T) {
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever))
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 500, recorder.Code)
 }
@@ -637,12 +453,15 @@ func TestApi_AddCardById_ShouldReturn500IfAddFails(t *testing.T) {
 	retriever.On("GetCardPricingInfo", mock.Anything, mock.Anything).Return(&models.PriceResponse{
 		Results: []models.PriceResults{{MarketPrice: 3.00}},
 	}, nil)
+	retriever.On("GetProductSkus", mock.Anything, mock.Anything).Return(&models.SkuResponse{
+		Results: []models.Sku{},
+	}, nil)
 
 	req, err := http.NewRequest(http.MethodPost, "/card/test", nil)
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever))
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 500, recorder.Code)
 }
@@ -661,26 +480,114 @@ func TestApi_AddCardById_ShouldReturn200IfNoErrors(t *testing.T) {
 	retriever.On("GetCardPricingInfo", mock.Anything, mock.Anything).Return(&models.PriceResponse{
 		Results: []models.PriceResults{{MarketPrice: 3.00}},
 	}, nil)
+	retriever.On("GetProductSkus", mock.Anything, mock.Anything).Return(&models.SkuResponse{
+		Results: []models.Sku{},
+	}, nil)
 
 	req, err := http.NewRequest(http.MethodPost, "/card/test", nil)
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever))
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 200, recorder.Code)
 }
 
-func TestApi_UpdateCard_ShouldReturn500IfInvalidId(t *testing.T) {
+func TestApi_AddCardById_ShouldReturn400IfProductIdIsInvalid(t *testing.T) {
 	dbHandler := &mocks.DbHandler{}
+	retriever := &mocks.ExtRetriever{}
 
-	req, err := http.NewRequest(http.MethodPut, "/card/test", nil)
+	req, err := http.NewRequest(http.MethodPost, "/card/test?productId=test", nil)
 	require.Nil(t, err)
 
 	recorder := httptest.NewRecorder()
-	httpHandler := http.HandlerFunc(updateCard(dbHandler))
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
 	httpHandler.ServeHTTP(recorder, req)
-	require.Equal(t, 500, recorder.Code)
+	require.Equal(t, 400, recorder.Code)
+}
+
+func TestApi_AddCardById_ShouldReturn404IfNoProductsMatchSerial(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{
+		Results: []int{},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodPost, "/card/test", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 404, recorder.Code)
+}
+
+func TestApi_AddCardById_ShouldReturn300WithCandidatesIfSerialMatchesMultipleProducts(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{
+		Results: []int{123, 456},
+	}, nil)
+	retriever.On("ExtendedCardSearchMultiple", mock.Anything, []int{123, 456}).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{
+			{ProductId: 123, ExtendedData: []models.ExtendedData{{Name: "Rarity", Value: "Ultra Rare"}}},
+			{ProductId: 456, ExtendedData: []models.ExtendedData{{Name: "Rarity", Value: "Secret Rare"}}},
+		},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodPost, "/card/test", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 300, recorder.Code)
+
+	var body models.AmbiguousSerial
+	require.Nil(t, json.NewDecoder(recorder.Body).Decode(&body))
+	require.Len(t, body.Candidates, 2)
+	require.Equal(t, "Secret Rare", body.Candidates[1].Rarity)
+	dbHandler.AssertNotCalled(t, "AddCard", mock.Anything, mock.Anything)
+	retriever.AssertNotCalled(t, "ExtendedCardSearch", mock.Anything, mock.Anything)
+}
+
+func TestApi_AddCardById_ShouldSkipSerialSearchIfProductIdGiven(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddCard", mock.Anything, mock.Anything).Return("success", nil)
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("ExtendedCardSearch", mock.Anything, 456).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{{ProductId: 456}},
+	}, nil)
+	retriever.On("GetCardPricingInfo", mock.Anything, 456).Return(&models.PriceResponse{
+		Results: []models.PriceResults{{MarketPrice: 3.00}},
+	}, nil)
+	retriever.On("GetProductSkus", mock.Anything, 456).Return(&models.SkuResponse{
+		Results: []models.Sku{},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodPost, "/card/test?productId=456", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+	retriever.AssertNotCalled(t, "BasicCardSearch", mock.Anything, mock.Anything)
+}
+
+func TestApi_UpdateCard_ShouldReturn500IfInvalidId(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+
+	req, err := http.NewRequest(http.MethodPut, "/card/test", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(updateCard(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
 }
 
 func TestApi_UpdateCard_ShouldReturn403IfInvalidBody(t *testing.T) {
@@ -765,6 +672,32 @@ func TestApi_GetCards_ShouldReturn500IfGetFails(t *testing.T) {
 	require.Equal(t, 500, recorder.Code)
 }
 
+func TestApi_GetCards_ShouldFilterCardsByQueryParameters(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCards", mock.Anything, dao.CardQuery(models.CardFilter{GroupId: 2750, MinMarketPrice: 5.5})).Return([]models.CardWithPriceInfo{{}}, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/cards?groupId=2750&minMarketPrice=5.5", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getCards(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+	dbHandler.AssertExpectations(t)
+}
+
+func TestApi_GetCards_ShouldReturn400IfFilterInvalid(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+
+	req, err := http.NewRequest(http.MethodGet, "/cards?minMarketPrice=cheap", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getCards(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+}
+
 func TestApi_GetCards_ShouldReturn200IfNoErrors(t *testing.T) {
 	dbHandler := &mocks.DbHandler{}
 	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{{}}, nil)
@@ -777,3 +710,822 @@ func TestApi_GetCards_ShouldReturn200IfNoErrors(t *testing.T) {
 	httpHandler.ServeHTTP(recorder, req)
 	require.Equal(t, 200, recorder.Code)
 }
+
+func TestApi_GetJob_ShouldReturn400IfInvalidId(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+
+	req, err := http.NewRequest(http.MethodGet, "/jobs/test", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": "test"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getJob(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+}
+
+func TestApi_GetJob_ShouldReturn500IfGetFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJob", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	id := primitive.NewObjectID().Hex()
+	req, err := http.NewRequest(http.MethodGet, "/jobs/"+id, nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getJob(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
+}
+
+func TestApi_GetJob_ShouldReturn200IfNoErrors(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJob", mock.Anything, mock.Anything).Return(&models.Job{}, nil)
+
+	id := primitive.NewObjectID().Hex()
+	req, err := http.NewRequest(http.MethodGet, "/jobs/"+id, nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getJob(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+}
+
+func TestApi_SearchCatalog_ShouldReturn400IfNoFiltersGiven(t *testing.T) {
+	retriever := &mocks.ExtRetriever{}
+
+	req, err := http.NewRequest(http.MethodGet, "/catalog/search", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(searchCatalog(&mocks.DbHandler{}, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+}
+
+func TestApi_SearchCatalog_ShouldReturn400IfLimitIsInvalid(t *testing.T) {
+	retriever := &mocks.ExtRetriever{}
+
+	req, err := http.NewRequest(http.MethodGet, "/catalog/search?name=test&limit=test", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(searchCatalog(&mocks.DbHandler{}, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+}
+
+func TestApi_SearchCatalog_ShouldReturn500IfCatalogSearchFails(t *testing.T) {
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("CatalogSearch", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	req, err := http.NewRequest(http.MethodGet, "/catalog/search?name=test", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(searchCatalog(&mocks.DbHandler{}, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
+}
+
+func TestApi_SearchCatalog_ShouldReturn500IfPricingSearchFails(t *testing.T) {
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("CatalogSearch", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&models.SearchResponse{
+		Results: []int{123},
+	}, nil)
+	retriever.On("ExtendedCardSearchMultiple", mock.Anything, mock.Anything).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{{ProductId: 123}},
+	}, nil)
+	retriever.On("GetCardPricingInfoMultiple", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	req, err := http.NewRequest(http.MethodGet, "/catalog/search?name=test", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(searchCatalog(&mocks.DbHandler{}, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
+}
+
+func TestApi_SearchCatalog_ShouldReturn200WithProductsAndPrices(t *testing.T) {
+	filters := []models.CardSearchFilter{
+		{Name: "ProductName", Values: []string{"Dark Magician"}},
+		{Name: "Rarity", Values: []string{"Ultra Rare"}},
+	}
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("CatalogSearch", mock.Anything, filters, 5, maxCatalogSearchLimit).Return(&models.SearchResponse{
+		TotalItems: 7,
+		Results:    []int{123, 456},
+	}, nil)
+	retriever.On("ExtendedCardSearchMultiple", mock.Anything, []int{123, 456}).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{{ProductId: 123}, {ProductId: 456}},
+	}, nil)
+	retriever.On("GetCardPricingInfoMultiple", mock.Anything, []int{123, 456}).Return(&models.PriceResponse{
+		Results: []models.PriceResults{{ProductId: 456, MarketPrice: 3.00}},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/catalog/search?name=Dark+Magician&rarity=Ultra+Rare&offset=5&limit=500", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(searchCatalog(&mocks.DbHandler{}, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var result models.CatalogSearchResult
+	require.Nil(t, json.NewDecoder(recorder.Body).Decode(&result))
+	require.Equal(t, 7, result.TotalItems)
+	require.Len(t, result.Results, 2)
+	require.Empty(t, result.Results[0].PriceInfo)
+	require.Equal(t, 3.00, result.Results[1].PriceInfo[0].MarketPrice)
+}
+
+func TestApi_AddCardByProductId_ShouldReturn400IfInvalidProductId(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	retriever := &mocks.ExtRetriever{}
+
+	req, err := http.NewRequest(http.MethodPost, "/catalog/products/test", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"productId": "test"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(addCardByProductId(dbHandler, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+}
+
+func TestApi_AddCardByProductId_ShouldReturn500IfAddFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddCard", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("ExtendedCardSearch", mock.Anything, 123).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{{ProductId: 123}},
+	}, nil)
+	retriever.On("GetCardPricingInfo", mock.Anything, 123).Return(&models.PriceResponse{
+		Results: []models.PriceResults{{MarketPrice: 3.00}},
+	}, nil)
+	retriever.On("GetProductSkus", mock.Anything, 123).Return(&models.SkuResponse{
+		Results: []models.Sku{},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodPost, "/catalog/products/123", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"productId": "123"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(addCardByProductId(dbHandler, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
+}
+
+func TestApi_AddCardByProductId_ShouldReturn200IfNoErrors(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddCard", mock.Anything, mock.Anything).Return("success", nil)
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("ExtendedCardSearch", mock.Anything, 123).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{{ProductId: 123}},
+	}, nil)
+	retriever.On("GetCardPricingInfo", mock.Anything, 123).Return(&models.PriceResponse{
+		Results: []models.PriceResults{{MarketPrice: 3.00}},
+	}, nil)
+	retriever.On("GetProductSkus", mock.Anything, 123).Return(&models.SkuResponse{
+		Results: []models.Sku{},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodPost, "/catalog/products/123", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"productId": "123"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(addCardByProductId(dbHandler, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+}
+
+func TestApi_SyncSets_ShouldReturn500IfGetGroupsFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("GetGroups", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	req, err := http.NewRequest(http.MethodPost, "/sets/sync", nil)
+	require.Nil(t, err)
+
+	producer := &mocks.EventSink{}
+	producer.On("Publish", mock.Anything, mock.Anything).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(syncSets(dbHandler, retriever, producer, config.TcgplayerConfig{}, time.Now))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
+}
+
+func TestApi_SyncSets_ShouldUseCredentialsOfCollection(t *testing.T) {
+	inBinder := mock.MatchedBy(func(ctx context.Context) bool { return tenant.CollectionId(ctx) == "binder" })
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCollection", inBinder, "binder").Return(&models.Collection{
+		Id:        "binder",
+		Tcgplayer: &models.TcgplayerCredentials{PublicKey: "own", PrivateKey: "secret"},
+	}, nil)
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", inBinder, "own", "secret").Return(errors.New("test"))
+
+	req, err := http.NewRequest(http.MethodPost, "/sets/sync", nil)
+	require.Nil(t, err)
+	req = req.WithContext(tenant.WithCollection(req.Context(), "binder"))
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(syncSets(dbHandler, retriever, &mocks.EventSink{}, config.TcgplayerConfig{PublicKey: "public", PrivateKey: "private"}, time.Now))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
+	retriever.AssertExpectations(t)
+}
+
+func TestApi_SyncSets_ShouldReturn200AndSaveSets(t *testing.T) {
+	finished := make(chan models.Job, 1)
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddJob", mock.Anything, mock.Anything).Return(primitive.NewObjectID(), nil)
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		if job := args.Get(1).(models.Job); job.Status == models.JobStatusFinished {
+			finished <- job
+		}
+	})
+	dbHandler.On("UpsertSet", mock.Anything, mock.MatchedBy(func(set models.Set) bool {
+		return set.GroupId == 1 && set.CardCount == 2 && set.Abbreviation == "LOB"
+	})).Return(nil)
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("GetGroups", mock.Anything, 0, catalogPageSize).Return(&models.GroupResponse{
+		TotalItems: 1,
+		Results:    []models.Group{{GroupId: 1, Name: "Legend of Blue Eyes White Dragon", Abbreviation: "LOB"}},
+	}, nil)
+	retriever.On("GetGroupProducts", mock.Anything, 1, 0, catalogPageSize).Return(&models.ExtendedSearchResponse{
+		TotalItems: 2,
+		Results:    []models.Card{{ProductId: 123}, {ProductId: 456}},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodPost, "/sets/sync", nil)
+	require.Nil(t, err)
+
+	producer := &mocks.EventSink{}
+	producer.On("Publish", mock.Anything, mock.Anything).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(syncSets(dbHandler, retriever, producer, config.TcgplayerConfig{}, time.Now))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	select {
+	case job := <-finished:
+		require.Equal(t, models.JobTypeSetSync, job.Type)
+		require.Equal(t, 1, job.Processed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Set sync job did not finish")
+	}
+}
+
+func TestApi_GetSets_ShouldReturn500IfGetFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSets", mock.Anything).Return(nil, errors.New("test"))
+
+	req, err := http.NewRequest(http.MethodGet, "/sets", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getSets(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
+}
+
+func TestApi_GetSet_ShouldReturn404IfSetNotFound(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSet", mock.Anything, 1).Return(nil, mongo.ErrNoDocuments)
+
+	req, err := http.NewRequest(http.MethodGet, "/sets/1", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getSet(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 404, recorder.Code)
+}
+
+func TestApi_GetSetCompletion_ShouldReturn400IfInvalidId(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	retriever := &mocks.ExtRetriever{}
+
+	req, err := http.NewRequest(http.MethodGet, "/sets/test/completion", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": "test"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getSetCompletion(dbHandler, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+}
+
+func TestApi_GetSetCompletion_ShouldReturn200WithOwnedAndMissingCards(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSet", mock.Anything, 1).Return(&models.Set{
+		GroupId:   1,
+		CardCount: 3,
+		Products:  []models.ProductCandidate{{ProductId: 123}, {ProductId: 456}, {ProductId: 789}},
+	}, nil)
+	dbHandler.On("GetCards", mock.Anything, map[string]interface{}{"card.groupId": 1}).Return([]models.CardWithPriceInfo{
+		{CardInfo: models.Card{ProductId: 123, GroupId: 1}},
+	}, nil)
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("GetGroupPricingInfo", mock.Anything, 1).Return(&models.PriceResponse{
+		Results: []models.PriceResults{
+			{ProductId: 123, MarketPrice: 10.00},
+			{ProductId: 456, MarketPrice: 4.00, SubTypeName: "1st Edition"},
+			{ProductId: 456, MarketPrice: 1.50, SubTypeName: "Unlimited"},
+			{ProductId: 789, MarketPrice: 0.0},
+		},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/sets/1/completion", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getSetCompletion(dbHandler, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var completion models.SetCompletion
+	require.Nil(t, json.NewDecoder(recorder.Body).Decode(&completion))
+	require.Equal(t, 1, completion.OwnedCount)
+	require.Equal(t, 2, completion.MissingCount)
+	require.Equal(t, 1.50, completion.CostToComplete)
+	require.Equal(t, 456, completion.Missing[0].ProductId)
+}
+
+func TestApi_AddCardById_ShouldStoreSkuPricesAndOwnership(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddCard", mock.Anything, mock.MatchedBy(func(card models.CardWithPriceInfo) bool {
+		return card.Condition == "Lightly Played" && len(card.SkuPriceInfo) == 2 &&
+			card.SkuPriceInfo[1].Condition == "Lightly Played" && card.SkuPriceInfo[1].MarketPrice == 2.50
+	})).Return("success", nil)
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("ExtendedCardSearch", mock.Anything, 123).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{{ProductId: 123}},
+	}, nil)
+	retriever.On("GetCardPricingInfo", mock.Anything, 123).Return(&models.PriceResponse{
+		Results: []models.PriceResults{{MarketPrice: 3.00}},
+	}, nil)
+	retriever.On("GetProductSkus", mock.Anything, 123).Return(&models.SkuResponse{
+		Results: []models.Sku{{SkuId: 1, Condition: "Near Mint"}, {SkuId: 2, Condition: "Lightly Played"}},
+	}, nil)
+	retriever.On("GetSkuPricingInfo", mock.Anything, []int{1, 2}).Return(&models.SkuPriceResponse{
+		Results: []models.SkuPriceResults{{SkuId: 1, MarketPrice: 3.00}, {SkuId: 2, MarketPrice: 2.50}},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodPost, "/card/test?productId=123&condition=Lightly+Played", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+}
+
+func TestApi_GetCollectionValue_ShouldReturn500IfGetFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	req, err := http.NewRequest(http.MethodGet, "/cards/value", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getCollectionValue(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
+}
+
+func TestApi_GetCollectionValue_ShouldUseSkuPriceMatchingOwnedCondition(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{
+		{
+			PriceInfo: []models.PriceResults{{MarketPrice: 10.00, SubTypeName: "1st Edition"}},
+			SkuPriceInfo: []models.SkuPrice{
+				{Condition: "Near Mint", Printing: "1st Edition", Language: "English", MarketPrice: 10.00},
+				{Condition: "Lightly Played", Printing: "1st Edition", Language: "English", MarketPrice: 7.00},
+				{Condition: "Lightly Played", Printing: "1st Edition", Language: "German", MarketPrice: 4.00},
+			},
+			Condition: "Lightly Played",
+			Printing:  "1st Edition",
+		},
+		{
+			PriceInfo: []models.PriceResults{
+				{MarketPrice: 5.00, SubTypeName: "1st Edition"},
+				{MarketPrice: 2.00, SubTypeName: "Unlimited"},
+			},
+		},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/cards/value", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getCollectionValue(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var value models.CollectionValue
+	require.Nil(t, json.NewDecoder(recorder.Body).Decode(&value))
+	require.Equal(t, 9.00, value.TotalMarketValue)
+	require.Equal(t, models.PriceSourceSku, value.Cards[0].PriceSource)
+	require.Equal(t, 7.00, value.Cards[0].MarketPrice)
+	require.Equal(t, models.PriceSourceProduct, value.Cards[1].PriceSource)
+	require.Equal(t, 2.00, value.Cards[1].MarketPrice)
+}
+
+func TestApi_AddCardById_ShouldKeepPriceRowsWithoutMarketPrice(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddCard", mock.Anything, mock.MatchedBy(func(card models.CardWithPriceInfo) bool {
+		return len(card.PriceInfo) == 2 && card.PriceInfo[1].MissingMarketPrice && card.PriceInfo[1].LowPrice == 0.25 &&
+			!card.NoMarketData && card.PriceSource == models.PriceSourceTcgplayer && !card.PricedAt.IsZero()
+	})).Return("success", nil)
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("ExtendedCardSearch", mock.Anything, 123).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{{ProductId: 123}},
+	}, nil)
+	retriever.On("GetCardPricingInfo", mock.Anything, 123).Return(&models.PriceResponse{
+		Results: []models.PriceResults{{MarketPrice: 3.00}, {MarketPrice: 0.0, LowPrice: 0.25}},
+	}, nil)
+	retriever.On("GetProductSkus", mock.Anything, 123).Return(&models.SkuResponse{
+		Results: []models.Sku{},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodPost, "/card/test?productId=123", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(addCardById(dbHandler, retriever, config.TcgplayerConfig{}))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+}
+
+func TestApi_GetStalePriceReport_ShouldReturn400IfInvalidDuration(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+
+	req, err := http.NewRequest(http.MethodGet, "/reports/stale-prices?olderThan=test", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getStalePriceReport(dbHandler, time.Now))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+}
+
+func TestApi_GetStalePriceReport_ShouldReturn200WithCardsPricedBeforeCutoff(t *testing.T) {
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCards", mock.Anything, mock.MatchedBy(func(filters map[string]interface{}) bool {
+		or, ok := filters["$or"].([]interface{})
+		if !ok || len(or) == 0 {
+			return false
+		}
+		pricedAt := or[0].(map[string]interface{})["pricedAt"].(map[string]interface{})
+		return pricedAt["$lt"].(time.Time).Equal(now.Add(-24 * time.Hour))
+	})).Return([]models.CardWithPriceInfo{
+		{CardInfo: models.Card{Name: "test", ExtendedData: []models.ExtendedData{{Value: "test"}}}},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/reports/stale-prices?olderThan=24h", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getStalePriceReport(dbHandler, func() time.Time { return now }))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var report models.PriceReport
+	require.Nil(t, json.NewDecoder(recorder.Body).Decode(&report))
+	require.Equal(t, 1, report.Count)
+	require.Equal(t, "test", report.Cards[0].Serial)
+}
+
+func TestApi_GetNoMarketDataReport_ShouldReturn500IfGetFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCards", mock.Anything, map[string]interface{}{"noMarketData": true}).Return(nil, errors.New("test"))
+
+	req, err := http.NewRequest(http.MethodGet, "/reports/no-market-data", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getNoMarketDataReport(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
+}
+
+func TestApi_GetEventStats_ShouldReturn200WithDeliveryCounts(t *testing.T) {
+	sink := &mocks.EventSink{}
+	sink.On("Stats").Return(producer.DeliveryStats{Delivered: 5, Failed: 1})
+
+	req, err := http.NewRequest(http.MethodGet, "/events/stats", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getEventStats(sink))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var stats producer.DeliveryStats
+	require.Nil(t, json.NewDecoder(recorder.Body).Decode(&stats))
+	require.Equal(t, uint64(5), stats.Delivered)
+}
+
+func TestApi_ProcessCards_ShouldReturn409IfRefreshInProgress(t *testing.T) {
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Refresh", mock.Anything, mock.Anything, mock.Anything).Return(nil, processor.ErrRefreshInProgress)
+
+	req, err := http.NewRequest(http.MethodPost, "/process", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(processCards(cardProcessor))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 409, recorder.Code)
+}
+
+func TestApi_GetSchedules_ShouldReturnSchedulesWithNextRun(t *testing.T) {
+	lastRun := time.Date(2021, 3, 1, 3, 0, 0, 0, time.UTC)
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSchedules", mock.Anything).Return([]models.Schedule{
+		{Name: "nightly", Cron: "0 3 * * *", Target: models.ScheduleTargetAll, LastRunAt: lastRun},
+		{Name: "paused", Cron: "0 3 * * *", Target: models.ScheduleTargetAll, LastRunAt: lastRun, Disabled: true},
+	}, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/schedules", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getSchedules(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var schedules []models.Schedule
+	require.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &schedules))
+	require.True(t, lastRun.Add(24*time.Hour).Equal(schedules[0].NextRunAt))
+	require.True(t, schedules[1].NextRunAt.IsZero())
+}
+
+func TestApi_GetSchedule_ShouldReturn404IfScheduleNotFound(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSchedule", mock.Anything, "nightly").Return(nil, mongo.ErrNoDocuments)
+
+	req, err := http.NewRequest(http.MethodGet, "/schedules/nightly", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"name": "nightly"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getSchedule(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 404, recorder.Code)
+}
+
+func TestApi_PutSchedule_ShouldReturn400IfScheduleInvalid(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+
+	req, err := http.NewRequest(http.MethodPut, "/schedules/nightly", strings.NewReader(`{"cron": "nightly", "target": "all"}`))
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"name": "nightly"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(putSchedule(dbHandler, time.Now))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+	dbHandler.AssertNotCalled(t, "UpsertSchedule", mock.Anything, mock.Anything)
+}
+
+func TestApi_PutSchedule_ShouldKeepRunHistoryOfExistingSchedule(t *testing.T) {
+	lastRun := time.Date(2021, 3, 1, 3, 0, 0, 0, time.UTC)
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSchedule", mock.Anything, "nightly").Return(&models.Schedule{
+		Name: "nightly", Cron: "0 3 * * *", Target: models.ScheduleTargetAll, LastRunAt: lastRun, LastJobId: "job",
+	}, nil)
+	dbHandler.On("UpsertSchedule", mock.Anything, mock.MatchedBy(func(schedule models.Schedule) bool {
+		return schedule.Name == "nightly" && schedule.Cron == "0 4 * * *" && schedule.LastRunAt.Equal(lastRun) && schedule.LastJobId == "job"
+	})).Return(nil)
+
+	req, err := http.NewRequest(http.MethodPut, "/schedules/nightly", strings.NewReader(`{"cron": "0 4 * * *", "target": "all"}`))
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"name": "nightly"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(putSchedule(dbHandler, time.Now))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+	dbHandler.AssertExpectations(t)
+}
+
+func TestApi_DeleteSchedule_ShouldReturn500IfHandlerReturnsError(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("DeleteSchedule", mock.Anything, "nightly").Return(errors.New("test"))
+
+	req, err := http.NewRequest(http.MethodDelete, "/schedules/nightly", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"name": "nightly"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(deleteSchedule(dbHandler))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
+}
+
+func TestApi_CancelJob_ShouldReturn200IfJobCancelled(t *testing.T) {
+	id := primitive.NewObjectID()
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Cancel", mock.Anything, id).Return(nil)
+
+	req, err := http.NewRequest(http.MethodDelete, "/jobs/"+id.Hex(), nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": id.Hex()})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(cancelJob(cardProcessor))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+	cardProcessor.AssertExpectations(t)
+}
+
+func TestApi_CancelJob_ShouldReturn404IfJobNotFound(t *testing.T) {
+	id := primitive.NewObjectID()
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Cancel", mock.Anything, id).Return(fmt.Errorf("error retrieving job: %w", mongo.ErrNoDocuments))
+
+	req, err := http.NewRequest(http.MethodDelete, "/jobs/"+id.Hex(), nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": id.Hex()})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(cancelJob(cardProcessor))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 404, recorder.Code)
+}
+
+func TestApi_CancelJob_ShouldReturn400IfIdInvalid(t *testing.T) {
+	cardProcessor := &mocks.CardProcessor{}
+
+	req, err := http.NewRequest(http.MethodDelete, "/jobs/test", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": "test"})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(cancelJob(cardProcessor))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+}
+
+func TestApi_PauseJob_ShouldReturn409IfJobNotRunning(t *testing.T) {
+	id := primitive.NewObjectID()
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Pause", mock.Anything, id).Return(processor.ErrJobNotRunning)
+
+	req, err := http.NewRequest(http.MethodPost, "/jobs/"+id.Hex()+"/pause", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": id.Hex()})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(pauseJob(cardProcessor))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 409, recorder.Code)
+}
+
+func TestApi_PauseJob_ShouldReturn409IfJobRunOnOtherReplica(t *testing.T) {
+	id := primitive.NewObjectID()
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Pause", mock.Anything, id).Return(processor.ErrJobClaimed)
+
+	req, err := http.NewRequest(http.MethodPost, "/jobs/"+id.Hex()+"/pause", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": id.Hex()})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(pauseJob(cardProcessor))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 409, recorder.Code)
+}
+
+func TestApi_ResumeJob_ShouldReturn200IfJobResumed(t *testing.T) {
+	id := primitive.NewObjectID()
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Resume", mock.Anything, id).Return(nil)
+
+	req, err := http.NewRequest(http.MethodPost, "/jobs/"+id.Hex()+"/resume", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": id.Hex()})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(resumeJob(cardProcessor))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+	cardProcessor.AssertExpectations(t)
+}
+
+func TestApi_GetJobEvents_ShouldSendProgressOfEndedJobAndClose(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeImport, Status: models.JobStatusFinished, Total: 3, Processed: 3}
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(job, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/jobs/"+job.Id.Hex()+"/events", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": job.Id.Hex()})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getJobEvents(dbHandler, progress.NewBroadcaster()))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+	require.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+	require.True(t, strings.HasPrefix(recorder.Body.String(), "event: progress\ndata: {"))
+	require.Contains(t, recorder.Body.String(), `"status":"finished"`)
+}
+
+func TestApi_GetJobEvents_ShouldStreamUpdatesUntilJobEnds(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeRefresh, Status: models.JobStatusRunning, Total: 2}
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(job, nil)
+
+	broadcaster := progress.NewBroadcaster()
+	broadcaster.Publish(models.JobProgress{JobId: job.Id.Hex(), Status: models.JobStatusRunning, CurrentCard: "LOB-001", Total: 2})
+
+	req, err := http.NewRequest(http.MethodGet, "/jobs/"+job.Id.Hex()+"/events", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": job.Id.Hex()})
+
+	done := make(chan struct{})
+	go func() {
+		// The final update only reaches the handler once it has subscribed, so it is repeated until then.
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				broadcaster.Publish(models.JobProgress{JobId: job.Id.Hex(), Status: models.JobStatusFinished, Total: 2, Processed: 2})
+			}
+		}
+	}()
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getJobEvents(dbHandler, broadcaster))
+	httpHandler.ServeHTTP(recorder, req)
+	close(done)
+
+	body := recorder.Body.String()
+	require.Equal(t, 2, strings.Count(body, "event: progress"))
+	require.Contains(t, body, `"currentCard":"LOB-001"`)
+	require.Contains(t, body, `"status":"finished"`)
+}
+
+func TestApi_GetJobEvents_ShouldReturn404IfJobNotFound(t *testing.T) {
+	id := primitive.NewObjectID()
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJob", mock.Anything, id).Return(nil, mongo.ErrNoDocuments)
+
+	req, err := http.NewRequest(http.MethodGet, "/jobs/"+id.Hex()+"/events", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": id.Hex()})
+
+	recorder := httptest.NewRecorder()
+	httpHandler := http.HandlerFunc(getJobEvents(dbHandler, progress.NewBroadcaster()))
+	httpHandler.ServeHTTP(recorder, req)
+	require.Equal(t, 404, recorder.Code)
+}
+
+func TestApi_GetJobEvents_ShouldSendProgressOverWebSocket(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeImport, Status: models.JobStatusCancelled, Total: 3, Processed: 1}
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(job, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/jobs/{id}/events", getJobEvents(dbHandler, progress.NewBroadcaster()))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/jobs/"+job.Id.Hex()+"/events", nil)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	var update models.JobProgress
+	require.Nil(t, conn.ReadJSON(&update))
+	require.Equal(t, models.JobStatusCancelled, update.Status)
+	require.Equal(t, 1, update.Processed)
+
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/auth"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/tenant"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// requireRole lets only requests authenticated as a principal with the given role on the requested collection through
+// to next, scoping their context to that collection. Others get 401 if they are not authenticated, 404 if they have no
+// access to the collection, or 403 if their role is not enough. If allowQuery is set, credentials are also accepted in
+// query parameter access_token.
+func requireRole(authenticator *auth.Authenticator, handler dao.DbHandler, role string, allowQuery bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		principal, err := authenticate(r, authenticator, allowQuery)
+		if errors.Is(err, auth.ErrUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ygo-card-processor"`)
+			respondWithError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		} else if err != nil {
+			logging.From(ctx).WithError(err).Error("Error authenticating request")
+			respondWithError(w, http.StatusInternalServerError, "Error authenticating request")
+			return
+		}
+
+		collectionId := requestedCollection(r)
+		var collection *models.Collection
+		if collectionId != models.DefaultCollectionId {
+			collection, err = handler.GetCollection(ctx, collectionId)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				respondWithError(w, http.StatusNotFound, "Collection not found")
+				return
+			} else if err != nil {
+				logging.From(ctx).WithError(err).Error("Error retrieving collection")
+				respondWithError(w, http.StatusInternalServerError, "Error authenticating request")
+				return
+			}
+		}
+
+		// A collection the principal has no access to is reported as missing, so its ID is not revealed.
+		collectionRole := principal.CollectionRole(collection)
+		if collectionRole == "" {
+			respondWithError(w, http.StatusNotFound, "Collection not found")
+			return
+		} else if !(auth.Principal{Name: principal.Name, Role: collectionRole}).Allows(role) {
+			respondWithError(w, http.StatusForbidden, "Role "+role+" required")
+			return
+		}
+
+		ctx = tenant.WithCollection(auth.WithPrincipal(ctx, *principal), collectionId)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// authenticationKey is the context key of the authentication of a request.
+type authenticationKey struct{}
+
+// authentication is the outcome of authenticating the credentials a request presents. It is kept in the request's
+// context, so that the rate limits and requireRole authenticate each request once.
+type authentication struct {
+	credentials string
+	principal   *auth.Principal
+	err         error
+}
+
+// withAuthentication returns r with the outcome of authenticating the credentials it presents, including those in query
+// parameter access_token. requireRole only reuses the outcome if it accepts the same credentials.
+func withAuthentication(r *http.Request, authenticator *auth.Authenticator) *http.Request {
+	credentials := auth.Credentials(r, true)
+	principal, err := authenticator.Authenticate(r.Context(), credentials)
+	return r.WithContext(context.WithValue(r.Context(), authenticationKey{}, &authentication{credentials, principal, err}))
+}
+
+// authenticate returns the principal the credentials r presents belong to, reusing the outcome of withAuthentication if
+// it authenticated the same credentials.
+func authenticate(r *http.Request, authenticator *auth.Authenticator, allowQuery bool) (*auth.Principal, error) {
+	credentials := auth.Credentials(r, allowQuery)
+	if a, ok := r.Context().Value(authenticationKey{}).(*authentication); ok && a.credentials == credentials {
+		return a.principal, a.err
+	}
+	return authenticator.Authenticate(r.Context(), credentials)
+}
+
+// requestedCollection returns the ID of the collection a request is for, given in header X-Collection or query
+// parameter collection, or the default collection if it names none.
+func requestedCollection(r *http.Request) string {
+	if collectionId := r.Header.Get("X-Collection"); collectionId != "" {
+		return collectionId
+	}
+	if collectionId := r.URL.Query().Get("collection"); collectionId != "" {
+		return collectionId
+	}
+	return models.DefaultCollectionId
+}
+
+// createApiKey creates an API key with the name and role in the request body. The key is only returned now.
+func createApiKey(handler dao.DbHandler, clock Clock) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		var request struct {
+			Name string `json:"name"`
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			logging.From(ctx).WithError(err).Error("Error decoding request body")
+			respondWithBodyError(w, err, http.StatusBadRequest, "Error creating API key")
+			return
+		}
+
+		key, err := auth.GenerateKey(request.Name, request.Role, clock())
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if key.Id, err = handler.AddApiKey(ctx, key.ApiKey); err != nil {
+			logging.From(ctx).WithError(err).Error("Error saving API key")
+			respondWithError(w, http.StatusInternalServerError, "Error creating API key")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusCreated, key)
+		return
+	}
+}
+
+func getApiKeys(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		keys, err := handler.GetApiKeys(ctx)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving API keys")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving API keys")
+			return
+		}
+		if keys == nil {
+			keys = []models.ApiKey{}
+		}
+
+		respondWithSuccess(w, http.StatusOK, keys)
+		return
+	}
+}
+
+func deleteApiKey(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+			return
+		}
+
+		if err := handler.DeleteApiKey(ctx, id); err != nil {
+			logging.From(ctx).WithError(err).Error("Error deleting API key")
+			respondWithError(w, http.StatusInternalServerError, "Error deleting API key")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, "Deleted API key")
+		return
+	}
+}
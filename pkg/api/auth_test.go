@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+const testJwtSecret = "0123456789abcdef0123456789abcdef"
+
+func signTestJwt(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJwtSecret))
+	require.Nil(t, err)
+	return token
+}
+
+func TestServer_Routes_ShouldRejectRequestsWithoutCredentials(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/cards", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	newTestServer(&mocks.DbHandler{}, &mocks.ExtRetriever{}).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	require.Contains(t, recorder.Header().Get("WWW-Authenticate"), "Bearer")
+}
+
+func TestServer_Routes_ShouldRejectUnknownApiKey(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetApiKeyByHash", mock.Anything, mock.Anything).Return(nil, mongo.ErrNoDocuments)
+
+	req, err := http.NewRequest(http.MethodGet, "/cards", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-API-Key", "ygo_unknown")
+
+	recorder := httptest.NewRecorder()
+	newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestServer_Routes_ShouldForbidRoleBelowRequired(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+
+	req, err := http.NewRequest(http.MethodDelete, "/card/SDY-006", nil)
+	require.Nil(t, err)
+	authorize(t, dbHandler, req, models.RoleReader)
+
+	recorder := httptest.NewRecorder()
+	newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusForbidden, recorder.Code)
+	dbHandler.AssertNotCalled(t, "DeleteCard", mock.Anything, mock.Anything)
+}
+
+func TestServer_Routes_ShouldAllowRoleAboveRequired(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("DeleteCard", mock.Anything, "SDY-006").Return(nil)
+
+	req, err := http.NewRequest(http.MethodDelete, "/card/SDY-006", nil)
+	require.Nil(t, err)
+	authorize(t, dbHandler, req, models.RoleAdmin)
+
+	recorder := httptest.NewRecorder()
+	newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestServer_Routes_ShouldAcceptJwtWithRole(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSchedules", mock.Anything).Return([]models.Schedule{}, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/schedules", nil)
+	require.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+signTestJwt(t, jwt.MapClaims{
+		"sub":  "test",
+		"role": models.RoleAdmin,
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	}))
+
+	recorder := httptest.NewRecorder()
+	newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestServer_Routes_ShouldRejectExpiredJwt(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/cards", nil)
+	require.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+signTestJwt(t, jwt.MapClaims{
+		"sub":  "test",
+		"role": models.RoleAdmin,
+		"exp":  time.Now().Add(-time.Hour).Unix(),
+	}))
+
+	recorder := httptest.NewRecorder()
+	newTestServer(&mocks.DbHandler{}, &mocks.ExtRetriever{}).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestServer_Routes_ShouldOnlyAcceptQueryTokenForJobEvents(t *testing.T) {
+	id := primitive.NewObjectID()
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJob", mock.Anything, id).Return(&models.Job{Id: id, Type: models.JobTypeSetSync, Status: models.JobStatusFinished}, nil)
+	token := signTestJwt(t, jwt.MapClaims{
+		"sub":  "test",
+		"role": models.RoleReader,
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	})
+	routes := newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes()
+
+	req, err := http.NewRequest(http.MethodGet, "/jobs/"+id.Hex()+"/events?access_token="+token, nil)
+	require.Nil(t, err)
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	req, err = http.NewRequest(http.MethodGet, "/jobs/"+id.Hex()+"?access_token="+token, nil)
+	require.Nil(t, err)
+	recorder = httptest.NewRecorder()
+	routes.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestApi_CreateApiKey_ShouldStoreHashAndReturnKey(t *testing.T) {
+	id := primitive.NewObjectID()
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddApiKey", mock.Anything, mock.MatchedBy(func(key models.ApiKey) bool {
+		return key.Name == "dashboard" && key.Role == models.RoleEditor && key.Hash != "" && key.CreatedAt.Equal(now)
+	})).Return(id, nil)
+
+	req, err := http.NewRequest(http.MethodPost, "/admin/keys", bytes.NewBufferString(`{"name": "dashboard", "role": "editor"}`))
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	createApiKey(dbHandler, func() time.Time { return now })(recorder, req)
+	require.Equal(t, http.StatusCreated, recorder.Code)
+
+	var created map[string]interface{}
+	require.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &created))
+	require.Equal(t, id.Hex(), created["id"])
+	require.Contains(t, created["key"], "ygo_")
+	require.NotContains(t, created, "hash")
+}
+
+func TestApi_CreateApiKey_ShouldReturnBadRequestIfRoleInvalid(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/admin/keys", bytes.NewBufferString(`{"name": "dashboard", "role": "owner"}`))
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	createApiKey(&mocks.DbHandler{}, time.Now)(recorder, req)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/auth"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/logging"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// collectionIdPattern is what collection IDs look like, so they can be given in headers and query parameters as is.
+var collectionIdPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// noCredentialsKeyMessage tells why a collection cannot be given credentials of its own: there is no key to encrypt its
+// private key with.
+const noCredentialsKeyMessage = "Collections cannot have tcgplayer.com credentials of their own, since no credentials key is configured"
+
+// collectionRequest is the body of requests creating or updating a collection. Unlike models.Collection it accepts the
+// private key of the tcgplayer.com credentials.
+type collectionRequest struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Tcgplayer *struct {
+		PublicKey  string `json:"publicKey"`
+		PrivateKey string `json:"privateKey"`
+	} `json:"tcgplayer"`
+}
+
+func (c collectionRequest) credentials() *models.TcgplayerCredentials {
+	if c.Tcgplayer == nil || c.Tcgplayer.PublicKey == "" {
+		return nil
+	}
+	return &models.TcgplayerCredentials{PublicKey: c.Tcgplayer.PublicKey, PrivateKey: c.Tcgplayer.PrivateKey}
+}
+
+// getCollections returns the collections the principal owns or that are shared with it, or every collection to admins.
+func getCollections(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		principal, _ := auth.FromContext(ctx)
+		user := principal.Name
+		if principal.Role == models.RoleAdmin {
+			user = ""
+		}
+
+		collections, err := handler.GetCollections(ctx, user)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error retrieving collections")
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving collections")
+			return
+		}
+		if collections == nil {
+			collections = []models.Collection{}
+		}
+
+		respondWithSuccess(w, http.StatusOK, collections)
+		return
+	}
+}
+
+// createCollection creates a collection owned by the principal, using the ID, name and tcgplayer.com credentials in the
+// request body.
+func createCollection(handler dao.DbHandler, clock Clock) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		var request collectionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			logging.From(ctx).WithError(err).Error("Error decoding request body")
+			respondWithBodyError(w, err, http.StatusBadRequest, "Error creating collection")
+			return
+		}
+		if !collectionIdPattern.MatchString(request.Id) || request.Id == models.DefaultCollectionId {
+			respondWithError(w, http.StatusBadRequest, "Collection ID must be up to 64 lowercase letters, digits, '-' and '_', and not 'default'")
+			return
+		}
+
+		principal, _ := auth.FromContext(ctx)
+		if principal.Name == "" {
+			respondWithError(w, http.StatusForbidden, "Only named users can own collections")
+			return
+		}
+
+		collection := models.Collection{
+			Id:         request.Id,
+			Name:       request.Name,
+			Owner:      principal.Name,
+			SharedWith: make([]string, 0),
+			Tcgplayer:  request.credentials(),
+			CreatedAt:  clock(),
+		}
+		if err := handler.AddCollection(ctx, collection); errors.Is(err, dao.ErrCollectionExists) {
+			respondWithError(w, http.StatusConflict, "Collection already exists")
+			return
+		} else if errors.Is(err, dao.ErrNoCredentialsKey) {
+			respondWithError(w, http.StatusBadRequest, noCredentialsKeyMessage)
+			return
+		} else if err != nil {
+			logging.From(ctx).WithError(err).Error("Error saving collection")
+			respondWithError(w, http.StatusInternalServerError, "Error creating collection")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusCreated, collection)
+		return
+	}
+}
+
+func getCollection(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+
+		collection, ok := findCollection(w, r, handler, false)
+		if !ok {
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, collection)
+		return
+	}
+}
+
+// updateCollection renames a collection, and replaces its tcgplayer.com credentials if the request body gives them. An
+// empty public key removes the credentials, so the configured ones are used.
+func updateCollection(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		var request collectionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			logging.From(ctx).WithError(err).Error("Error decoding request body")
+			respondWithBodyError(w, err, http.StatusBadRequest, "Error updating collection")
+			return
+		}
+
+		collection, ok := findCollection(w, r, handler, true)
+		if !ok {
+			return
+		}
+		if request.Name != "" {
+			collection.Name = request.Name
+		}
+		if request.Tcgplayer != nil {
+			collection.Tcgplayer = request.credentials()
+		}
+
+		if err := handler.UpdateCollection(ctx, *collection); errors.Is(err, dao.ErrNoCredentialsKey) {
+			respondWithError(w, http.StatusBadRequest, noCredentialsKeyMessage)
+			return
+		} else if err != nil {
+			logging.From(ctx).WithError(err).Error("Error saving collection")
+			respondWithError(w, http.StatusInternalServerError, "Error updating collection")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, collection)
+		return
+	}
+}
+
+// shareCollection gives the user in the request path a read-only view of a collection.
+func shareCollection(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		collection, ok := findCollection(w, r, handler, true)
+		if !ok {
+			return
+		}
+
+		user := mux.Vars(r)["user"]
+		if !containsString(collection.SharedWith, user) {
+			collection.SharedWith = append(collection.SharedWith, user)
+		}
+
+		if err := handler.UpdateCollection(ctx, *collection); err != nil {
+			logging.From(ctx).WithError(err).Error("Error saving collection")
+			respondWithError(w, http.StatusInternalServerError, "Error sharing collection")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, collection)
+		return
+	}
+}
+
+// unshareCollection removes the read-only view of a collection from the user in the request path.
+func unshareCollection(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		collection, ok := findCollection(w, r, handler, true)
+		if !ok {
+			return
+		}
+
+		user := mux.Vars(r)["user"]
+		sharedWith := make([]string, 0)
+		for _, shared := range collection.SharedWith {
+			if shared != user {
+				sharedWith = append(sharedWith, shared)
+			}
+		}
+		collection.SharedWith = sharedWith
+
+		if err := handler.UpdateCollection(ctx, *collection); err != nil {
+			logging.From(ctx).WithError(err).Error("Error saving collection")
+			respondWithError(w, http.StatusInternalServerError, "Error unsharing collection")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, collection)
+		return
+	}
+}
+
+// deleteCollection deletes a collection together with its cards, jobs and schedules.
+func deleteCollection(handler dao.DbHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer closeRequestBody(r)
+		ctx := r.Context()
+
+		collection, ok := findCollection(w, r, handler, true)
+		if !ok {
+			return
+		}
+
+		if err := handler.DeleteCollection(ctx, collection.Id); errors.Is(err, dao.ErrCollectionBusy) {
+			respondWithError(w, http.StatusConflict, "Collection has running or paused jobs")
+			return
+		} else if err != nil {
+			logging.From(ctx).WithError(err).Error("Error deleting collection")
+			respondWithError(w, http.StatusInternalServerError, "Error deleting collection")
+			return
+		}
+
+		respondWithSuccess(w, http.StatusOK, "Deleted collection")
+		return
+	}
+}
+
+// findCollection retrieves the collection in the request path, if the principal has access to it. If manage is set,
+// only its owner and admins have access. Otherwise it responds with an error and returns false.
+func findCollection(w http.ResponseWriter, r *http.Request, handler dao.DbHandler, manage bool) (*models.Collection, bool) {
+	ctx := r.Context()
+
+	collection, err := handler.GetCollection(ctx, mux.Vars(r)["id"])
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		respondWithError(w, http.StatusNotFound, "Collection not found")
+		return nil, false
+	} else if err != nil {
+		logging.From(ctx).WithError(err).Error("Error retrieving collection")
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving collection")
+		return nil, false
+	}
+
+	principal, _ := auth.FromContext(ctx)
+	role := principal.CollectionRole(collection)
+	if role == "" {
+		respondWithError(w, http.StatusNotFound, "Collection not found")
+		return nil, false
+	} else if manage && principal.Name != collection.Owner && principal.Role != models.RoleAdmin {
+		respondWithError(w, http.StatusForbidden, "Only the owner can manage a collection")
+		return nil, false
+	}
+	return collection, true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/auth"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/tenant"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+func inCollection(collectionId string) interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		return tenant.CollectionId(ctx) == collectionId
+	})
+}
+
+func binder() *models.Collection {
+	return &models.Collection{Id: "binder", Name: "Binder", Owner: "owner", SharedWith: []string{"test"}}
+}
+
+func TestServer_Routes_ShouldScopeRequestsToRequestedCollection(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCollection", mock.Anything, "binder").Return(binder(), nil)
+	dbHandler.On("GetCards", inCollection("binder"), mock.Anything).Return([]models.CardWithPriceInfo{}, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/cards", nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Collection", "binder")
+	authorize(t, dbHandler, req, models.RoleReader)
+
+	recorder := httptest.NewRecorder()
+	newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	dbHandler.AssertExpectations(t)
+}
+
+func TestServer_Routes_ShouldOnlyLetSharedUsersRead(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCollection", mock.Anything, "binder").Return(binder(), nil)
+
+	req, err := http.NewRequest(http.MethodDelete, "/card/SDY-006?collection=binder", nil)
+	require.Nil(t, err)
+	authorize(t, dbHandler, req, models.RoleEditor)
+
+	recorder := httptest.NewRecorder()
+	newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusForbidden, recorder.Code)
+	dbHandler.AssertNotCalled(t, "DeleteCard", mock.Anything, mock.Anything)
+}
+
+func TestServer_Routes_ShouldHideCollectionsWithoutAccess(t *testing.T) {
+	collection := binder()
+	collection.SharedWith = []string{}
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCollection", mock.Anything, "binder").Return(collection, nil)
+	dbHandler.On("GetCollection", mock.Anything, "missing").Return(nil, mongo.ErrNoDocuments)
+	routes := newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes()
+
+	for _, collectionId := range []string{"binder", "missing"} {
+		req, err := http.NewRequest(http.MethodGet, "/cards?collection="+collectionId, nil)
+		require.Nil(t, err)
+		authorize(t, dbHandler, req, models.RoleEditor)
+
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusNotFound, recorder.Code, collectionId)
+	}
+}
+
+func TestApi_CreateCollection_ShouldBeOwnedByPrincipal(t *testing.T) {
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddCollection", mock.Anything, models.Collection{
+		Id:         "binder",
+		Name:       "Binder",
+		Owner:      "owner",
+		SharedWith: []string{},
+		Tcgplayer:  &models.TcgplayerCredentials{PublicKey: "public", PrivateKey: "private"},
+		CreatedAt:  now,
+	}).Return(nil)
+
+	body := `{"id": "binder", "name": "Binder", "tcgplayer": {"publicKey": "public", "privateKey": "private"}}`
+	req, err := http.NewRequest(http.MethodPost, "/collections", bytes.NewBufferString(body))
+	require.Nil(t, err)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Name: "owner", Role: models.RoleEditor}))
+
+	recorder := httptest.NewRecorder()
+	createCollection(dbHandler, func() time.Time { return now })(recorder, req)
+	require.Equal(t, http.StatusCreated, recorder.Code)
+	require.NotContains(t, recorder.Body.String(), "private")
+	dbHandler.AssertExpectations(t)
+}
+
+func TestApi_CreateCollection_ShouldReturnConflictIfIdTaken(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddCollection", mock.Anything, mock.Anything).Return(dao.ErrCollectionExists)
+
+	req, err := http.NewRequest(http.MethodPost, "/collections", bytes.NewBufferString(`{"id": "binder"}`))
+	require.Nil(t, err)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Name: "owner", Role: models.RoleEditor}))
+
+	recorder := httptest.NewRecorder()
+	createCollection(dbHandler, time.Now)(recorder, req)
+	require.Equal(t, http.StatusConflict, recorder.Code)
+}
+
+func TestApi_CreateCollection_ShouldReturnBadRequestIfCredentialsCannotBeEncrypted(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddCollection", mock.Anything, mock.Anything).Return(dao.ErrNoCredentialsKey)
+
+	body := `{"id": "binder", "tcgplayer": {"publicKey": "public", "privateKey": "private"}}`
+	req, err := http.NewRequest(http.MethodPost, "/collections", bytes.NewBufferString(body))
+	require.Nil(t, err)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Name: "owner", Role: models.RoleEditor}))
+
+	recorder := httptest.NewRecorder()
+	createCollection(dbHandler, time.Now)(recorder, req)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	require.Contains(t, recorder.Body.String(), "no credentials key is configured")
+}
+
+func TestApi_CreateCollection_ShouldReturnBadRequestIfIdInvalid(t *testing.T) {
+	for _, id := range []string{"", "default", "My Binder"} {
+		body, err := json.Marshal(map[string]string{"id": id})
+		require.Nil(t, err)
+		req, err := http.NewRequest(http.MethodPost, "/collections", bytes.NewBuffer(body))
+		require.Nil(t, err)
+
+		recorder := httptest.NewRecorder()
+		createCollection(&mocks.DbHandler{}, time.Now)(recorder, req)
+		require.Equal(t, http.StatusBadRequest, recorder.Code, id)
+	}
+}
+
+func TestApi_ShareCollection_ShouldAddUser(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCollection", mock.Anything, "binder").Return(binder(), nil)
+	dbHandler.On("UpdateCollection", mock.Anything, mock.MatchedBy(func(collection models.Collection) bool {
+		return len(collection.SharedWith) == 2 && collection.SharedWith[1] == "friend"
+	})).Return(nil)
+
+	req, err := http.NewRequest(http.MethodPut, "/collections/binder/shares/friend", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": "binder", "user": "friend"})
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Name: "owner", Role: models.RoleEditor}))
+
+	recorder := httptest.NewRecorder()
+	shareCollection(dbHandler)(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	dbHandler.AssertExpectations(t)
+}
+
+func TestApi_ShareCollection_ShouldForbidUsersItIsSharedWith(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCollection", mock.Anything, "binder").Return(binder(), nil)
+
+	req, err := http.NewRequest(http.MethodPut, "/collections/binder/shares/friend", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": "binder", "user": "friend"})
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Name: "test", Role: models.RoleEditor}))
+
+	recorder := httptest.NewRecorder()
+	shareCollection(dbHandler)(recorder, req)
+	require.Equal(t, http.StatusForbidden, recorder.Code)
+	dbHandler.AssertNotCalled(t, "UpdateCollection", mock.Anything, mock.Anything)
+}
+
+func TestApi_DeleteCollection_ShouldReturnConflictIfCollectionHasActiveJobs(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCollection", mock.Anything, "binder").Return(binder(), nil)
+	dbHandler.On("DeleteCollection", mock.Anything, "binder").Return(dao.ErrCollectionBusy)
+
+	req, err := http.NewRequest(http.MethodDelete, "/collections/binder", nil)
+	require.Nil(t, err)
+	req = mux.SetURLVars(req, map[string]string{"id": "binder"})
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Name: "owner", Role: models.RoleEditor}))
+
+	recorder := httptest.NewRecorder()
+	deleteCollection(dbHandler)(recorder, req)
+	require.Equal(t, http.StatusConflict, recorder.Code)
+	dbHandler.AssertExpectations(t)
+}
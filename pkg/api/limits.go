@@ -0,0 +1,112 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ygo-card-processor/pkg/metrics"
+	"ygo-card-processor/pkg/ratelimit"
+)
+
+// rateLimit lets through the requests of clients with a token left in limiter, and responds with 429 to the others,
+// counting them in the metrics of the named limit. Responses carry the X-RateLimit-* headers of the client's bucket. A
+// nil limiter lets every request through.
+func rateLimit(limiter *ratelimit.Limiter, limit string, next http.Handler) http.Handler {
+	if limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := limiter.Allow(clientKey(r))
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			metrics.HttpRateLimited.WithLabelValues(limit).Inc()
+			respondWithError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientKey tells clients apart by the principal their credentials authenticate as, or by their IP address if they
+// present no credentials or ones that do not authenticate, so that made-up credentials cannot get a limit of their own.
+func clientKey(r *http.Request) string {
+	if a, ok := r.Context().Value(authenticationKey{}).(*authentication); ok && a.err == nil {
+		return "principal:" + a.principal.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// limitBody responds with 413 to requests whose body is larger than maxBody bytes, or maxUpload bytes for file uploads.
+// Bodies without a length are cut off at the limit instead, which handlers report through respondWithBodyError.
+func limitBody(maxBody int64, maxUpload int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := maxBody
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			limit = maxUpload
+		}
+
+		if r.ContentLength > limit {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body must not exceed %v bytes", limit))
+			return
+		}
+		if r.Body != nil {
+			r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyTooLargeError is returned when reading a request body beyond its size limit.
+type bodyTooLargeError struct {
+	limit int64
+}
+
+func (e *bodyTooLargeError) Error() string {
+	return fmt.Sprintf("request body exceeds %v bytes", e.limit)
+}
+
+// limitedBody reports reading past the limit of http.MaxBytesReader with a bodyTooLargeError, which handlers can tell
+// apart from other read errors. MaxBytesReader fails once it has read limit bytes and finds more.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		return n, &bodyTooLargeError{limit: b.limit}
+	}
+	return n, err
+}
+
+// respondWithBodyError responds to a request whose body could not be read: with 413 if the body exceeded its size
+// limit, and with the given code and message otherwise.
+func respondWithBodyError(w http.ResponseWriter, err error, code int, message string) {
+	var tooLarge *bodyTooLargeError
+	if errors.As(err, &tooLarge) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body must not exceed %v bytes", tooLarge.limit))
+		return
+	}
+	respondWithError(w, code, message)
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/auth"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+func TestServer_Routes_ShouldRateLimitExpensiveRequests(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	server := newTestServer(dbHandler, &mocks.ExtRetriever{})
+	routes := server.Routes()
+
+	req, err := http.NewRequest(http.MethodPost, "/process", strings.NewReader("{"))
+	require.Nil(t, err)
+	authorize(t, dbHandler, req, models.RoleAdmin)
+
+	for i := 0; i < server.config.Limits.ExpensiveBurst; i++ {
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+	}
+
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	require.Equal(t, "3", recorder.Header().Get("X-RateLimit-Limit"))
+	require.Equal(t, "0", recorder.Header().Get("X-RateLimit-Remaining"))
+	require.NotEmpty(t, recorder.Header().Get("Retry-After"))
+
+	// Cheap requests are still allowed.
+	dbHandler.On("GetSets", mock.Anything).Return([]models.Set{}, nil)
+	req, err = http.NewRequest(http.MethodGet, "/sets", nil)
+	require.Nil(t, err)
+	authorize(t, dbHandler, req, models.RoleAdmin)
+
+	recorder = httptest.NewRecorder()
+	routes.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestServer_Routes_ShouldRateLimitInvalidCredentialsByIpAddress(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetApiKeyByHash", mock.Anything, mock.Anything).Return(nil, mongo.ErrNoDocuments)
+	server := newTestServer(dbHandler, &mocks.ExtRetriever{})
+	server.limiter = newLimiter(1, 1, server.clock)
+	routes := server.Routes()
+
+	codes := make([]int, 0)
+	for _, key := range []string{"ygo_first", "ygo_second"} {
+		req, err := http.NewRequest(http.MethodGet, "/cards", nil)
+		require.Nil(t, err)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-API-Key", key)
+
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, req)
+		codes = append(codes, recorder.Code)
+	}
+	require.Equal(t, []int{http.StatusUnauthorized, http.StatusTooManyRequests}, codes)
+}
+
+func TestServer_Routes_ShouldRateLimitPrincipalsApartFromTheirIpAddress(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSets", mock.Anything).Return([]models.Set{}, nil)
+	server := newTestServer(dbHandler, &mocks.ExtRetriever{})
+	server.limiter = newLimiter(1, 1, server.clock)
+	routes := server.Routes()
+
+	for _, name := range []string{"dashboard", "nightly-refresh"} {
+		key, err := auth.GenerateKey(name, models.RoleReader, time.Now())
+		require.Nil(t, err)
+		dbHandler.On("GetApiKeyByHash", mock.Anything, key.Hash).Return(&key.ApiKey, nil)
+
+		req, err := http.NewRequest(http.MethodGet, "/sets", nil)
+		require.Nil(t, err)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-API-Key", key.Key)
+
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusOK, recorder.Code)
+	}
+	dbHandler.AssertNumberOfCalls(t, "GetApiKeyByHash", 2)
+}
+
+func TestServer_Routes_ShouldNotRateLimitHealthChecks(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("Ping", mock.Anything).Return(nil)
+	server := newTestServer(dbHandler, &mocks.ExtRetriever{})
+	server.limiter = newLimiter(1, 1, server.clock)
+	routes := server.Routes()
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodGet, "/health", nil)
+		require.Nil(t, err)
+
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Empty(t, recorder.Header().Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestServer_Routes_ShouldReturn413IfContentLengthExceedsLimit(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	server := newTestServer(dbHandler, &mocks.ExtRetriever{})
+	server.config.Limits.MaxBodyBytes = 8
+
+	req, err := http.NewRequest(http.MethodPost, "/admin/keys", strings.NewReader(`{"name": "test", "role": "reader"}`))
+	require.Nil(t, err)
+	authorize(t, dbHandler, req, models.RoleAdmin)
+
+	recorder := httptest.NewRecorder()
+	server.Routes().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	dbHandler.AssertNotCalled(t, "AddApiKey", mock.Anything, mock.Anything)
+}
+
+func TestServer_Routes_ShouldReturn413IfBodyWithoutLengthExceedsLimit(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	server := newTestServer(dbHandler, &mocks.ExtRetriever{})
+	server.config.Limits.MaxBodyBytes = 8
+
+	body, err := json.Marshal(map[string]string{"name": "test", "role": "reader"})
+	require.Nil(t, err)
+	// Hiding the reader's type keeps http.NewRequest from setting the content length.
+	req, err := http.NewRequest(http.MethodPost, "/admin/keys", struct{ io.Reader }{bytes.NewReader(body)})
+	require.Nil(t, err)
+	require.Equal(t, int64(0), req.ContentLength)
+	authorize(t, dbHandler, req, models.RoleAdmin)
+
+	recorder := httptest.NewRecorder()
+	server.Routes().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	dbHandler.AssertNotCalled(t, "AddApiKey", mock.Anything, mock.Anything)
+}
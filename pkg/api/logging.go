@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"ygo-card-processor/pkg/logging"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+// maxRequestIdLength bounds the request IDs taken from callers, which end up in every log entry of their request.
+const maxRequestIdLength = 128
+
+// assignRequestId serves every request with the ID the caller sent in the X-Request-ID header, or a new one if it sent
+// none or an unusable one, and returns the ID in the same header. Everything logged while serving the request carries
+// its ID, including jobs it starts.
+func assignRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(requestIdHeader)
+		if !validRequestId(requestId) {
+			requestId = primitive.NewObjectID().Hex()
+		}
+		w.Header().Set(requestIdHeader, requestId)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestId(r.Context(), requestId)))
+	})
+}
+
+// validRequestId reports whether a request ID sent by a caller is short and only made of printable ASCII characters
+// other than spaces, so it cannot break up or forge log entries.
+func validRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range requestId {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// logRequest writes the access log entry of a served request. Health checks and metrics scrapes are only logged at
+// debug level, since monitoring makes them all the time.
+func logRequest(ctx context.Context, r *http.Request, route string, recorder *statusRecorder, start time.Time) {
+	entry := logging.From(ctx).WithFields(logrus.Fields{
+		"method":     r.Method,
+		"route":      route,
+		"path":       r.URL.Path,
+		"status":     recorder.status,
+		"durationMs": time.Since(start).Milliseconds(),
+		"bytes":      recorder.bytes,
+	})
+	if route == "/health" || route == "/metrics" {
+		entry.Debug("Request served")
+	} else {
+		entry.Info("Request served")
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+func TestServer_Routes_ShouldKeepRequestIdOfCallerOrAssignOne(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("Ping", mock.Anything).Return(nil)
+	routes := newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes()
+
+	for requestId, kept := range map[string]bool{"client-42": true, "": false, "forged\nentry": false} {
+		req, err := http.NewRequest(http.MethodGet, "/health", nil)
+		require.Nil(t, err)
+		req.Header.Set(requestIdHeader, requestId)
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, req)
+
+		if kept {
+			require.Equal(t, requestId, recorder.Header().Get(requestIdHeader))
+		} else {
+			require.Len(t, recorder.Header().Get(requestIdHeader), 24)
+		}
+	}
+}
+
+func TestServer_Routes_ShouldLogRequestsWithRequestId(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCardByNumber", mock.Anything, "SDY-006").Return(nil, errors.New("test"))
+	routes := newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes()
+
+	req, err := http.NewRequest(http.MethodGet, "/card/SDY-006", nil)
+	require.Nil(t, err)
+	req.Header.Set(requestIdHeader, "client-42")
+	authorize(t, dbHandler, req, models.RoleReader)
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, req)
+
+	entries := hook.AllEntries()
+	require.Len(t, entries, 2)
+	require.Equal(t, "Error retrieving card", entries[0].Message)
+	require.Equal(t, "client-42", entries[0].Data[logging.FieldRequestId])
+
+	access := entries[1]
+	require.Equal(t, "Request served", access.Message)
+	require.Equal(t, "client-42", access.Data[logging.FieldRequestId])
+	require.Equal(t, http.MethodGet, access.Data["method"])
+	require.Equal(t, "/card/{id}", access.Data["route"])
+	require.Equal(t, http.StatusInternalServerError, access.Data["status"])
+	require.Equal(t, recorder.Body.Len(), access.Data["bytes"])
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/metrics"
+	"ygo-card-processor/pkg/tenant"
+	"ygo-card-processor/pkg/tracing"
+	"ygo-card-processor/pkg/valuation"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// collectionMetricsInterval is how often the card count and market value of every collection are recomputed.
+const collectionMetricsInterval = time.Minute
+
+// statusRecorder remembers the status code a handler responds with and counts the bytes of the response body. It passes
+// flushing and hijacking through, which job events need for server-sent events and websockets.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// instrument records requests as spans, continuing the trace of the caller if it sent a traceparent header, and their
+// count and duration by method, route template and status code in the metrics. Routes are given as templates such as
+// /card/{id}, so card numbers and IDs do not each become a metric of their own. Every request is also written to the
+// access log.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("http.request_id", logging.RequestId(ctx)),
+		))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.status_code", recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+		status := strconv.Itoa(recorder.status)
+		metrics.HttpRequests.WithLabelValues(r.Method, route, status).Inc()
+		metrics.HttpRequestDuration.WithLabelValues(r.Method, route, status).Observe(metrics.Since(start))
+		logRequest(ctx, r, route, recorder, start)
+	})
+}
+
+// updateCollectionMetrics sets the card count and market value of every collection, and drops the metrics of
+// collections deleted since the last update.
+func updateCollectionMetrics(ctx context.Context, handler dao.DbHandler, previous map[string]bool) (map[string]bool, error) {
+	collections, err := handler.GetCollections(ctx, "")
+	if err != nil {
+		return previous, err
+	}
+
+	current := map[string]bool{models.DefaultCollectionId: true}
+	for _, collection := range collections {
+		current[collection.Id] = true
+	}
+	for collectionId := range current {
+		cards, err := handler.GetCards(tenant.WithCollection(ctx, collectionId), map[string]interface{}{})
+		if err != nil {
+			return previous, err
+		}
+		value := valuation.CollectionValue(cards)
+		metrics.CollectionCards.WithLabelValues(collectionId).Set(float64(value.CardCount))
+		metrics.CollectionMarketValue.WithLabelValues(collectionId).Set(value.TotalMarketValue)
+	}
+	for collectionId := range previous {
+		if !current[collectionId] {
+			metrics.CollectionCards.DeleteLabelValues(collectionId)
+			metrics.CollectionMarketValue.DeleteLabelValues(collectionId)
+		}
+	}
+	return current, nil
+}
+
+// runCollectionMetrics updates the collection metrics every collectionMetricsInterval until ctx is cancelled.
+func runCollectionMetrics(ctx context.Context, handler dao.DbHandler) {
+	ticker := time.NewTicker(collectionMetricsInterval)
+	defer ticker.Stop()
+
+	var updated map[string]bool
+	for {
+		var err error
+		if updated, err = updateCollectionMetrics(ctx, handler, updated); err != nil {
+			logging.From(ctx).WithError(err).Error("Error updating collection metrics")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/metrics"
+	"ygo-card-processor/pkg/tenant"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+func TestServer_Routes_ShouldCountRequestsByRouteTemplate(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCardByNumber", mock.Anything, "SDY-006").Return(&models.CardWithPriceInfo{}, nil)
+	routes := newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes()
+	counter := metrics.HttpRequests.WithLabelValues(http.MethodGet, "/card/{id}", "200")
+	before := testutil.ToFloat64(counter)
+
+	req, err := http.NewRequest(http.MethodGet, "/card/SDY-006", nil)
+	require.Nil(t, err)
+	authorize(t, dbHandler, req, models.RoleReader)
+	routes.ServeHTTP(httptest.NewRecorder(), req)
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+
+	req, err = http.NewRequest(http.MethodGet, "/metrics", nil)
+	require.Nil(t, err)
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Contains(t, recorder.Body.String(), `ygo_http_requests_total{method="GET",route="/card/{id}",status="200"}`)
+}
+
+func TestServer_Routes_ShouldContinueTraceOfCaller(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(sdktrace.NewTracerProvider()) })
+
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCardByNumber", mock.Anything, "SDY-006").Return(&models.CardWithPriceInfo{}, nil)
+	routes := newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes()
+
+	req, err := http.NewRequest(http.MethodGet, "/card/SDY-006", nil)
+	require.Nil(t, err)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	authorize(t, dbHandler, req, models.RoleReader)
+	routes.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "GET /card/{id}", spans[0].Name())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	require.Contains(t, spans[0].Attributes(), attribute.Int("http.status_code", http.StatusOK))
+}
+
+func TestApi_UpdateCollectionMetrics_ShouldSetGaugesAndDropDeletedCollections(t *testing.T) {
+	card := models.CardWithPriceInfo{PriceInfo: []models.PriceResults{{SubTypeName: "1st Edition", MarketPrice: 12.5}}}
+	inCollection := func(collectionId string) interface{} {
+		return mock.MatchedBy(func(ctx context.Context) bool { return tenant.CollectionId(ctx) == collectionId })
+	}
+
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCollections", mock.Anything, "").Return([]models.Collection{{Id: "binder"}}, nil)
+	dbHandler.On("GetCards", inCollection(models.DefaultCollectionId), map[string]interface{}{}).Return([]models.CardWithPriceInfo{}, nil)
+	dbHandler.On("GetCards", inCollection("binder"), map[string]interface{}{}).Return([]models.CardWithPriceInfo{card, card}, nil)
+
+	metrics.CollectionCards.WithLabelValues("deleted").Set(1)
+	updated, err := updateCollectionMetrics(context.Background(), dbHandler, map[string]bool{"deleted": true})
+	require.Nil(t, err)
+	require.Equal(t, map[string]bool{models.DefaultCollectionId: true, "binder": true}, updated)
+	require.Equal(t, 2.0, testutil.ToFloat64(metrics.CollectionCards.WithLabelValues("binder")))
+	require.Equal(t, 25.0, testutil.ToFloat64(metrics.CollectionMarketValue.WithLabelValues("binder")))
+	require.False(t, metrics.CollectionCards.DeleteLabelValues("deleted"))
+}
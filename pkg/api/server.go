@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/auth"
+	"ygo-card-processor/pkg/config"
+	"ygo-card-processor/pkg/consumer"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/external"
+	"ygo-card-processor/pkg/metrics"
+	"ygo-card-processor/pkg/outbox"
+	"ygo-card-processor/pkg/processor"
+	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/progress"
+	"ygo-card-processor/pkg/ratelimit"
+	"ygo-card-processor/pkg/reader"
+	"ygo-card-processor/pkg/scheduler"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// shutdownTimeout is how long in-flight requests get to finish once the server is asked to stop.
+const shutdownTimeout = 5 * time.Second
+
+// connKey is the context key of the connection a request arrived on.
+type connKey struct{}
+
+// withConn keeps the connection of every request in its context, so handlers streaming responses can lift the write
+// timeout of their connection.
+func withConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+// clearWriteDeadline lifts the server's write timeout from the connection r arrived on, for responses streamed for as
+// long as the client listens. The server sets the deadline again for the next request on the connection.
+func clearWriteDeadline(r *http.Request) {
+	if conn, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+		_ = conn.SetWriteDeadline(time.Time{})
+	}
+}
+
+// Clock returns the current time. Handlers use it instead of time.Now so tests can fix the time.
+type Clock func() time.Time
+
+// Server is the HTTP API together with the background workers it drives. It owns none of its dependencies:
+// the caller connects to Mongo, TCGplayer and the event sink, and closes them once Run returns.
+type Server struct {
+	config    *config.Config
+	handler   dao.DbHandler
+	retriever external.ExtRetriever
+	reader    reader.FileReader
+	sink      producer.EventSink
+	clock     Clock
+	processor *processor.Processor
+	progress  *progress.Broadcaster
+
+	authenticator *auth.Authenticator
+	// limiter limits every request but health checks, and expensiveLimiter additionally those starting jobs or calling
+	// tcgplayer.com. Either is nil if its limit is disabled.
+	limiter          *ratelimit.Limiter
+	expensiveLimiter *ratelimit.Limiter
+}
+
+// NewServer creates a Server from its dependencies. A nil clock defaults to time.Now.
+func NewServer(cfg *config.Config, handler dao.DbHandler, retriever external.ExtRetriever, fileReader reader.FileReader, sink producer.EventSink, clock Clock) *Server {
+	if clock == nil {
+		clock = time.Now
+	}
+	progressBroadcaster := progress.NewBroadcaster()
+	return &Server{
+		config:    cfg,
+		handler:   handler,
+		retriever: retriever,
+		reader:    fileReader,
+		sink:      sink,
+		clock:     clock,
+		processor: &processor.Processor{
+			Handler:    handler,
+			Retriever:  retriever,
+			Sink:       sink,
+			PublicKey:  cfg.Tcgplayer.PublicKey,
+			PrivateKey: cfg.Tcgplayer.PrivateKey,
+			Delay:      processor.CardDelay,
+			Progress:   progressBroadcaster,
+		},
+		progress: progressBroadcaster,
+		authenticator: &auth.Authenticator{
+			Handler:   handler,
+			JwtSecret: []byte(cfg.Auth.JwtSecret),
+			JwtIssuer: cfg.Auth.JwtIssuer,
+			Disabled:  cfg.Auth.Disabled,
+		},
+		limiter:          newLimiter(cfg.Limits.RequestsPerMinute, cfg.Limits.Burst, clock),
+		expensiveLimiter: newLimiter(cfg.Limits.ExpensiveRequestsPerMinute, cfg.Limits.ExpensiveBurst, clock),
+	}
+}
+
+func newLimiter(perMinute int, burst int, clock Clock) *ratelimit.Limiter {
+	if perMinute == 0 {
+		return nil
+	}
+	return ratelimit.New(perMinute, burst, clock)
+}
+
+// require returns middleware letting only requests authenticated with the given role on the requested collection through.
+func (s *Server) require(role string) func(next http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return requireRole(s.authenticator, s.handler, role, false, next)
+	}
+}
+
+// limits applies the rate limit and request body limits to every route but health checks and metrics, which are exempt
+// so that monitoring does not use up requests. Requests are authenticated first, so that clients are limited by the
+// principal they authenticate as.
+func (s *Server) limits(next http.Handler) http.Handler {
+	limited := rateLimit(s.limiter, "requests", limitBody(s.config.Limits.MaxBodyBytes, s.config.Limits.MaxUploadBytes, next))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limited.ServeHTTP(w, withAuthentication(r, s.authenticator))
+	})
+}
+
+// expensive applies the stricter rate limit of requests starting jobs or calling tcgplayer.com.
+func (s *Server) expensive(next http.HandlerFunc) http.HandlerFunc {
+	return rateLimit(s.expensiveLimiter, "expensive", next).ServeHTTP
+}
+
+// Routes returns the API's handler, including request IDs, access logs, metrics, rate limiting, authentication and
+// CORS. It does not start any background work.
+func (s *Server) Routes() http.Handler {
+	r := mux.NewRouter()
+	r.Use(assignRequestId, instrument, s.limits)
+
+	r.HandleFunc("/health", checkHealth(s.handler)).Methods(http.MethodGet)
+	r.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+
+	readers, editors, admins := s.require(models.RoleReader), s.require(models.RoleEditor), s.require(models.RoleAdmin)
+
+	r.HandleFunc("/process", s.expensive(admins(processCards(s.processor)))).Methods(http.MethodPost)
+	r.HandleFunc("/card/{id}", readers(getCardByNumber(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/card/{id}", s.expensive(editors(addCardById(s.handler, s.retriever, s.config.Tcgplayer)))).Methods(http.MethodPost)
+	r.HandleFunc("/card/{id}", editors(updateCard(s.handler))).Methods(http.MethodPut)
+	r.HandleFunc("/card/{id}", editors(deleteCard(s.handler))).Methods(http.MethodDelete)
+	r.HandleFunc("/cards", s.expensive(editors(addCardsFromFile(s.reader, s.processor)))).Methods(http.MethodPost)
+	r.HandleFunc("/cards", readers(getCards(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/cards/value", readers(getCollectionValue(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/reports/stale-prices", readers(getStalePriceReport(s.handler, s.clock))).Methods(http.MethodGet)
+	r.HandleFunc("/reports/no-market-data", readers(getNoMarketDataReport(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/catalog/search", readers(searchCatalog(s.handler, s.retriever, s.config.Tcgplayer))).Methods(http.MethodGet)
+	r.HandleFunc("/catalog/products/{productId}", s.expensive(editors(addCardByProductId(s.handler, s.retriever, s.config.Tcgplayer)))).Methods(http.MethodPost)
+	r.HandleFunc("/sets", readers(getSets(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/sets/sync", s.expensive(admins(syncSets(s.handler, s.retriever, s.sink, s.config.Tcgplayer, s.clock)))).Methods(http.MethodPost)
+	r.HandleFunc("/sets/{id}", readers(getSet(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/sets/{id}/completion", readers(getSetCompletion(s.handler, s.retriever, s.config.Tcgplayer))).Methods(http.MethodGet)
+	r.HandleFunc("/schedules", admins(getSchedules(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/schedules/{name}", admins(getSchedule(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/schedules/{name}", admins(putSchedule(s.handler, s.clock))).Methods(http.MethodPut)
+	r.HandleFunc("/schedules/{name}", admins(deleteSchedule(s.handler))).Methods(http.MethodDelete)
+	r.HandleFunc("/jobs/{id}", readers(getJob(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/jobs/{id}", admins(cancelJob(s.processor))).Methods(http.MethodDelete)
+	r.HandleFunc("/jobs/{id}/events", requireRole(s.authenticator, s.handler, models.RoleReader, true, getJobEvents(s.handler, s.progress))).Methods(http.MethodGet)
+	r.HandleFunc("/jobs/{id}/pause", admins(pauseJob(s.processor))).Methods(http.MethodPost)
+	r.HandleFunc("/jobs/{id}/resume", admins(resumeJob(s.processor))).Methods(http.MethodPost)
+	r.HandleFunc("/events/stats", readers(getEventStats(s.sink))).Methods(http.MethodGet)
+	r.HandleFunc("/collections", readers(getCollections(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/collections", editors(createCollection(s.handler, s.clock))).Methods(http.MethodPost)
+	r.HandleFunc("/collections/{id}", readers(getCollection(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/collections/{id}", editors(updateCollection(s.handler))).Methods(http.MethodPut)
+	r.HandleFunc("/collections/{id}", editors(deleteCollection(s.handler))).Methods(http.MethodDelete)
+	r.HandleFunc("/collections/{id}/shares/{user}", editors(shareCollection(s.handler))).Methods(http.MethodPut)
+	r.HandleFunc("/collections/{id}/shares/{user}", editors(unshareCollection(s.handler))).Methods(http.MethodDelete)
+	r.HandleFunc("/admin/keys", admins(getApiKeys(s.handler))).Methods(http.MethodGet)
+	r.HandleFunc("/admin/keys", admins(createApiKey(s.handler, s.clock))).Methods(http.MethodPost)
+	r.HandleFunc("/admin/keys/{id}", admins(deleteApiKey(s.handler))).Methods(http.MethodDelete)
+
+	// Without configured origins, no cross-origin requests are allowed.
+	if len(s.config.Server.CorsOrigins) == 0 {
+		return r
+	}
+	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", "X-API-Key", "X-Collection", requestIdHeader})
+	exposed := handlers.ExposedHeaders([]string{requestIdHeader})
+	origins := handlers.AllowedOrigins(s.config.Server.CorsOrigins)
+	methods := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS", "DELETE"})
+	return handlers.CORS(headers, exposed, origins, methods)(r)
+}
+
+// Run applies pending database migrations, resumes interrupted jobs and keeps checking for more, starts the command
+// consumer, scheduler, outbox relay and collection metrics, and serves the API until ctx is cancelled. It then gives
+// in-flight requests shutdownTimeout to finish and returns.
+func (s *Server) Run(ctx context.Context) error {
+	if s.config.Auth.Disabled {
+		logrus.Warn("Authentication is disabled, every request is allowed as an admin")
+	}
+
+	// Migrations run before anything else reads the database, since queries rely on the data they backfill, such as the
+	// collection of cards stored before collections existed.
+	applied, err := s.handler.Migrate(ctx)
+	if err != nil {
+		return fmt.Errorf("error migrating database: %w", err)
+	}
+	for _, name := range applied {
+		logrus.Info(fmt.Sprintf("Applied migration %v", name))
+	}
+
+	if err := s.processor.ResumeInterrupted(ctx); err != nil {
+		return err
+	}
+	go s.processor.WatchInterrupted(ctx)
+
+	if s.config.Commands.Topic != "" {
+		commandConsumer, err := consumer.CreateConsumer(s.config.Events.Broker, s.config.Commands.Group, s.config.Commands.Topic, &consumer.Dispatcher{
+			Processor: s.processor,
+			Sink:      s.sink,
+		})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := commandConsumer.Close(); err != nil {
+				logrus.WithError(err).Error("Error closing command consumer")
+			}
+		}()
+		go commandConsumer.Run(ctx)
+	}
+
+	configuredSchedules, err := scheduler.LoadSchedules(s.config.Schedules.File, s.config.Schedules.Json)
+	if err != nil {
+		return err
+	}
+	refreshScheduler := scheduler.Scheduler{
+		Handler:   s.handler,
+		Processor: s.processor,
+	}
+	if err := refreshScheduler.Seed(ctx, configuredSchedules); err != nil {
+		return err
+	}
+	go refreshScheduler.Run(ctx)
+
+	relay := outbox.Relay{
+		Handler: s.handler,
+		Sink:    s.sink,
+	}
+	go relay.Run(ctx)
+
+	go runCollectionMetrics(ctx, s.handler)
+
+	server := &http.Server{
+		Handler:      s.Routes(),
+		Addr:         s.config.Server.Addr,
+		WriteTimeout: s.config.Server.WriteTimeout,
+		ReadTimeout:  s.config.Server.ReadTimeout,
+		ConnContext:  withConn,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logrus.Info("Starting API server...")
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	c, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(c)
+}
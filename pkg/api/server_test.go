@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/auth"
+	"ygo-card-processor/pkg/config"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+func newTestServer(dbHandler *mocks.DbHandler, retriever *mocks.ExtRetriever) *Server {
+	cfg := config.Default()
+	cfg.Server.Addr = "127.0.0.1:0"
+	cfg.Server.CorsOrigins = []string{"http://localhost:3000"}
+	cfg.Tcgplayer.PublicKey = "public"
+	cfg.Tcgplayer.PrivateKey = "private"
+	cfg.Auth.JwtSecret = testJwtSecret
+	return NewServer(&cfg, dbHandler, retriever, &mocks.FileReader{}, &mocks.EventSink{}, time.Now)
+}
+
+// authorize adds an API key with the given role to a request, and makes it known to dbHandler.
+func authorize(t *testing.T, dbHandler *mocks.DbHandler, req *http.Request, role string) {
+	key, err := auth.GenerateKey("test", role, time.Now())
+	require.Nil(t, err)
+	dbHandler.On("GetApiKeyByHash", mock.Anything, key.Hash).Return(&key.ApiKey, nil)
+	req.Header.Set("Authorization", "Bearer "+key.Key)
+}
+
+// allowLocks has dbHandler grant every lock asked for, as if no other replica were running.
+func allowLocks(dbHandler *mocks.DbHandler) {
+	dbHandler.On("AcquireLock", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(true, nil).Maybe()
+	dbHandler.On("ReleaseLock", mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+}
+
+func TestServer_Routes_ShouldServeHealthCheck(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("Ping", mock.Anything).Return(nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/health", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+	dbHandler.AssertExpectations(t)
+}
+
+func TestServer_Routes_ShouldAllowConfiguredOrigins(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("Ping", mock.Anything).Return(nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/health", nil)
+	require.Nil(t, err)
+	req.Header.Set("Origin", "http://localhost:3000")
+
+	recorder := httptest.NewRecorder()
+	newTestServer(dbHandler, &mocks.ExtRetriever{}).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, "http://localhost:3000", recorder.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestServer_Routes_ShouldRefreshTokenWithConfiguredCredentials(t *testing.T) {
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, "public", "private").Return(errors.New("test"))
+	dbHandler := &mocks.DbHandler{}
+
+	req, err := http.NewRequest(http.MethodGet, "/catalog/search?name=test", nil)
+	require.Nil(t, err)
+	authorize(t, dbHandler, req, models.RoleReader)
+
+	recorder := httptest.NewRecorder()
+	newTestServer(dbHandler, retriever).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, 500, recorder.Code)
+	retriever.AssertExpectations(t)
+}
+
+func TestServer_Routes_ShouldReturn405IfMethodNotRouted(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPatch, "/cards", nil)
+	require.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	newTestServer(&mocks.DbHandler{}, &mocks.ExtRetriever{}).Routes().ServeHTTP(recorder, req)
+	require.Equal(t, 405, recorder.Code)
+}
+
+func TestServer_Run_ShouldStopWhenContextCancelled(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("Migrate", mock.Anything).Return([]string{}, nil)
+	dbHandler.On("GetJobsByStatus", mock.Anything, mock.Anything).Return([]models.Job{}, nil)
+	dbHandler.On("GetSchedules", mock.Anything).Return([]models.Schedule{}, nil)
+	dbHandler.On("GetPendingOutboxEvents", mock.Anything, mock.Anything).Return([]models.OutboxEvent{}, nil)
+	dbHandler.On("GetCollections", mock.Anything, "").Return([]models.Collection{}, nil)
+	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- newTestServer(dbHandler, &mocks.ExtRetriever{}).Run(ctx)
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		require.Nil(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not stop")
+	}
+}
+
+func TestServer_Run_ShouldReturnErrorIfMigrationFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("Migrate", mock.Anything).Return(nil, errors.New("test"))
+
+	require.NotNil(t, newTestServer(dbHandler, &mocks.ExtRetriever{}).Run(context.Background()))
+	dbHandler.AssertNotCalled(t, "GetJobsByStatus", mock.Anything, mock.Anything)
+}
+
+func TestServer_Run_ShouldReturnErrorIfJobsCannotBeResumed(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("Migrate", mock.Anything).Return([]string{}, nil)
+	dbHandler.On("GetJobsByStatus", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	require.NotNil(t, newTestServer(dbHandler, &mocks.ExtRetriever{}).Run(context.Background()))
+}
+
+func TestServer_ClearWriteDeadline_ShouldLetResponsesOutlastWriteTimeout(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clearWriteDeadline(r)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("late"))
+	}))
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Config.ConnContext = withConn
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, "late", string(body))
+}
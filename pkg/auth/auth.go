@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/dao"
+)
+
+// KeyPrefix starts every API key, so keys can be told apart from JWTs and recognised by secret scanners.
+const KeyPrefix = "ygo_"
+
+// keyBytes is how many random bytes an API key holds.
+const keyBytes = 32
+
+// displayedPrefixLength is how much of an API key is stored in the clear, so its owner can tell keys apart.
+const displayedPrefixLength = len(KeyPrefix) + 6
+
+// ErrUnauthenticated is returned for requests without credentials, or with credentials that are unknown or invalid.
+var ErrUnauthenticated = errors.New("missing or invalid credentials")
+
+// roleRanks orders the roles, each allowed everything the ones ranked below it are.
+var roleRanks = map[string]int{
+	models.RoleReader: 1,
+	models.RoleEditor: 2,
+	models.RoleAdmin:  3,
+}
+
+type principalKey struct{}
+
+// Principal is the client a request is authenticated as.
+type Principal struct {
+	Name string
+	Role string
+}
+
+// Allows reports whether the principal has the given role, or one ranked above it.
+func (p Principal) Allows(role string) bool {
+	return ValidRole(role) && roleRanks[p.Role] >= roleRanks[role]
+}
+
+// CollectionRole returns the role the principal has on a collection, or "" if it has none. Admins have their role on
+// every collection, the owner has its own role, and the users it is shared with can only read it. Everyone has their
+// own role on the default collection, which is given as nil.
+func (p Principal) CollectionRole(collection *models.Collection) string {
+	if collection == nil || p.Role == models.RoleAdmin || (p.Name != "" && collection.Owner == p.Name) {
+		return p.Role
+	}
+	for _, user := range collection.SharedWith {
+		if user == p.Name && p.Allows(models.RoleReader) {
+			return models.RoleReader
+		}
+	}
+	return ""
+}
+
+// ValidRole reports whether role is reader, editor or admin.
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// WithPrincipal returns a copy of ctx carrying the principal a request is authenticated as.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal carried by ctx, if any.
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// GenerateKey creates an API key with the given name and role. The returned key is only available now; the ApiKey it
+// embeds holds its hash, to be stored.
+func GenerateKey(name string, role string, now time.Time) (models.NewApiKey, error) {
+	if name == "" {
+		return models.NewApiKey{}, errors.New("name is required")
+	} else if !ValidRole(role) {
+		return models.NewApiKey{}, fmt.Errorf("role must be %v, %v or %v", models.RoleReader, models.RoleEditor, models.RoleAdmin)
+	}
+
+	secret := make([]byte, keyBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return models.NewApiKey{}, err
+	}
+	key := KeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	return models.NewApiKey{
+		ApiKey: models.ApiKey{
+			Name:      name,
+			Role:      role,
+			Prefix:    key[:displayedPrefixLength],
+			Hash:      HashKey(key),
+			CreatedAt: now,
+		},
+		Key: key,
+	}, nil
+}
+
+// HashKey returns the hash an API key is stored under. Keys are random, so a fast hash is enough.
+func HashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Credentials returns the API key or JWT a request presents, as a bearer token or in header X-API-Key. If allowQuery
+// is set, query parameter access_token is also accepted, for clients such as EventSource that cannot set headers.
+func Credentials(r *http.Request, allowQuery bool) string {
+	if authorization := r.Header.Get("Authorization"); len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(authorization[len("Bearer "):])
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if allowQuery {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// Authenticator finds the principal presented credentials belong to: the owner of an API key stored through Handler,
+// or the subject of a JWT signed with JwtSecret, if set, and issued by JwtIssuer, if set.
+type Authenticator struct {
+	Handler   dao.DbHandler
+	JwtSecret []byte
+	JwtIssuer string
+	// Disabled authenticates every request as an admin, for local development only.
+	Disabled bool
+}
+
+// jwtClaims are the claims of a JWT presented by a client. The role claim is required.
+type jwtClaims struct {
+	Role string `json:"role"`
+	jwt.StandardClaims
+}
+
+// Authenticate returns the principal given credentials belong to, or ErrUnauthenticated if they belong to none.
+func (a *Authenticator) Authenticate(ctx context.Context, credentials string) (*Principal, error) {
+	if a.Disabled {
+		return &Principal{Name: "anonymous", Role: models.RoleAdmin}, nil
+	}
+
+	if credentials == "" {
+		return nil, ErrUnauthenticated
+	} else if strings.HasPrefix(credentials, KeyPrefix) {
+		return a.authenticateKey(ctx, credentials)
+	} else if len(a.JwtSecret) > 0 {
+		return a.authenticateJwt(credentials)
+	}
+	return nil, ErrUnauthenticated
+}
+
+func (a *Authenticator) authenticateKey(ctx context.Context, key string) (*Principal, error) {
+	stored, err := a.Handler.GetApiKeyByHash(ctx, HashKey(key))
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUnauthenticated
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving API key: %w", err)
+	}
+	return &Principal{Name: stored.Name, Role: stored.Role}, nil
+}
+
+func (a *Authenticator) authenticateJwt(token string) (*Principal, error) {
+	var claims jwtClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return a.JwtSecret, nil
+	})
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	// Tokens must expire, and must come from the configured issuer if there is one.
+	if claims.ExpiresAt == 0 || !ValidRole(claims.Role) {
+		return nil, ErrUnauthenticated
+	} else if a.JwtIssuer != "" && !claims.VerifyIssuer(a.JwtIssuer, true) {
+		return nil, ErrUnauthenticated
+	}
+	return &Principal{Name: claims.Subject, Role: claims.Role}, nil
+}
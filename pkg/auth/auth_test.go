@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+
+	"ygo-card-processor/models"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestAuth_GenerateKey_ShouldStoreOnlyPrefixAndHash(t *testing.T) {
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	key, err := GenerateKey("dashboard", models.RoleEditor, now)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(key.Key, KeyPrefix))
+	require.True(t, strings.HasPrefix(key.Key, key.Prefix))
+	require.Equal(t, HashKey(key.Key), key.Hash)
+	require.NotContains(t, key.Hash, key.Key)
+	require.Equal(t, now, key.CreatedAt)
+
+	other, err := GenerateKey("dashboard", models.RoleEditor, now)
+	require.Nil(t, err)
+	require.NotEqual(t, key.Key, other.Key)
+}
+
+func TestAuth_GenerateKey_ShouldReturnErrorIfRoleInvalid(t *testing.T) {
+	_, err := GenerateKey("dashboard", "owner", time.Now())
+	require.NotNil(t, err)
+	_, err = GenerateKey("", models.RoleAdmin, time.Now())
+	require.NotNil(t, err)
+}
+
+func TestAuth_Allows_ShouldAllowRolesRankedBelow(t *testing.T) {
+	editor := Principal{Name: "test", Role: models.RoleEditor}
+	require.True(t, editor.Allows(models.RoleReader))
+	require.True(t, editor.Allows(models.RoleEditor))
+	require.False(t, editor.Allows(models.RoleAdmin))
+	require.False(t, Principal{}.Allows(models.RoleReader))
+}
+
+func TestAuth_Credentials_ShouldOnlyReadQueryIfAllowed(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/jobs/1/events?access_token=query", nil)
+	require.Nil(t, err)
+	require.Equal(t, "", Credentials(req, false))
+	require.Equal(t, "query", Credentials(req, true))
+
+	req.Header.Set("X-API-Key", "header")
+	require.Equal(t, "header", Credentials(req, true))
+	req.Header.Set("Authorization", "bearer token")
+	require.Equal(t, "token", Credentials(req, true))
+}
+
+func TestAuth_Authenticate_ShouldAcceptValidJwt(t *testing.T) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":  "test",
+		"role": models.RoleReader,
+		"iss":  "issuer",
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	}).SignedString(testSecret)
+	require.Nil(t, err)
+
+	authenticator := &Authenticator{JwtSecret: testSecret, JwtIssuer: "issuer"}
+	principal, err := authenticator.Authenticate(context.Background(), token)
+	require.Nil(t, err)
+	require.Equal(t, Principal{Name: "test", Role: models.RoleReader}, *principal)
+}
+
+func TestAuth_Authenticate_ShouldRejectInvalidJwts(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Unix()
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		require.Nil(t, err)
+		return token
+	}
+
+	authenticator := &Authenticator{JwtSecret: testSecret, JwtIssuer: "issuer"}
+	for name, token := range map[string]string{
+		"no expiry":    sign(jwt.SigningMethodHS256, testSecret, jwt.MapClaims{"role": models.RoleAdmin, "iss": "issuer"}),
+		"no role":      sign(jwt.SigningMethodHS256, testSecret, jwt.MapClaims{"exp": expires, "iss": "issuer"}),
+		"wrong issuer": sign(jwt.SigningMethodHS256, testSecret, jwt.MapClaims{"role": models.RoleAdmin, "exp": expires, "iss": "other"}),
+		"wrong secret": sign(jwt.SigningMethodHS256, []byte("other"), jwt.MapClaims{"role": models.RoleAdmin, "exp": expires, "iss": "issuer"}),
+		"unsigned":     sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, jwt.MapClaims{"role": models.RoleAdmin, "exp": expires, "iss": "issuer"}),
+	} {
+		_, err := authenticator.Authenticate(context.Background(), token)
+		require.Equal(t, ErrUnauthenticated, err, name)
+	}
+}
+
+func TestAuth_Authenticate_ShouldAllowEverythingIfDisabled(t *testing.T) {
+	principal, err := (&Authenticator{Disabled: true}).Authenticate(context.Background(), "")
+	require.Nil(t, err)
+	require.True(t, principal.Allows(models.RoleAdmin))
+}
+
+func TestAuth_CollectionRole_ShouldLetSharedUsersOnlyRead(t *testing.T) {
+	collection := &models.Collection{Id: "binder", Owner: "owner", SharedWith: []string{"friend"}}
+	require.Equal(t, models.RoleEditor, Principal{Name: "owner", Role: models.RoleEditor}.CollectionRole(collection))
+	require.Equal(t, models.RoleReader, Principal{Name: "friend", Role: models.RoleEditor}.CollectionRole(collection))
+	require.Equal(t, models.RoleAdmin, Principal{Name: "admin", Role: models.RoleAdmin}.CollectionRole(collection))
+	require.Equal(t, "", Principal{Name: "other", Role: models.RoleEditor}.CollectionRole(collection))
+	require.Equal(t, models.RoleEditor, Principal{Name: "other", Role: models.RoleEditor}.CollectionRole(nil))
+}
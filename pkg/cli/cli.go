@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/processor"
+	"ygo-card-processor/pkg/progress"
+	"ygo-card-processor/pkg/reader"
+	"ygo-card-processor/pkg/tenant"
+)
+
+// Name is the name of the CLI binary, used in usage messages.
+const Name = "ygo-cli"
+
+// ErrUsage is returned for an unknown command or invalid arguments, once the usage has been printed.
+var ErrUsage = errors.New("invalid usage")
+
+// App runs administration commands directly against the card database, without the API. Jobs are run by Processor in
+// this process, and their progress is followed through Progress, which must be the broadcaster Processor reports to.
+type App struct {
+	Handler   dao.DbHandler
+	Processor processor.CardProcessor
+	Progress  *progress.Broadcaster
+	Reader    reader.FileReader
+	Out       io.Writer
+	Err       io.Writer
+	Clock     func() time.Time
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	// scoped commands work on a card collection, given with flag -collection.
+	scoped bool
+	run    func(a *App, ctx context.Context, in *invocation) error
+}
+
+// invocation is a command being run with its arguments. Its flag set has the -json flag every command has, and the
+// -collection flag of scoped commands, and the command adds its own flags before parsing the arguments.
+type invocation struct {
+	flags      *flag.FlagSet
+	asJson     *bool
+	collection *string
+	args       []string
+}
+
+var commands = []command{
+	{name: "import", scoped: true, args: "<file.xlsx>", summary: "add the cards whose serial numbers are listed in a spreadsheet", run: (*App).importFile},
+	{name: "refresh", scoped: true, summary: "refresh the catalog data and prices of all cards, or those selected by flags", run: (*App).refresh},
+	{name: "export", scoped: true, summary: "write the collection as CSV, or JSON with -json", run: (*App).export},
+	{name: "show", scoped: true, args: "<serial>", summary: "show a card", run: (*App).show},
+	{name: "value", scoped: true, summary: "compute the market value of the collection", run: (*App).value},
+	{name: "migrate", summary: "apply pending database migrations", run: (*App).migrate},
+	{name: "dedupe", scoped: true, summary: "remove cards stored more than once under the same serial number", run: (*App).dedupe},
+	{name: "create-key", summary: "create an API key, such as the first admin key", run: (*App).createKey},
+}
+
+// Run runs the command named by the first argument with the remaining arguments.
+func (a *App) Run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		a.usage()
+		return ErrUsage
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(a, ctx, a.newInvocation(c, args[1:]))
+		}
+	}
+
+	fmt.Fprintf(a.Err, "unknown command '%v'\n", args[0])
+	a.usage()
+	return ErrUsage
+}
+
+func (a *App) usage() {
+	fmt.Fprintf(a.Err, "Usage: %v [configuration flags] <command> [flags] [arguments]\n\nCommands:\n", Name)
+	for _, c := range commands {
+		fmt.Fprintf(a.Err, "  %-10v %v\n", c.name, c.summary)
+	}
+	fmt.Fprintf(a.Err, "\nRun '%v <command> -help' for the flags of a command, and '%v -help' for the configuration flags.\n", Name, Name)
+}
+
+func (a *App) newInvocation(c command, args []string) *invocation {
+	flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	flags.SetOutput(a.Err)
+	flags.Usage = func() {
+		fmt.Fprintf(a.Err, "Usage: %v %v [flags] %v\n\nTo %v.\n\nFlags:\n", Name, c.name, c.args, c.summary)
+		flags.PrintDefaults()
+	}
+	in := &invocation{
+		flags:  flags,
+		asJson: flags.Bool("json", false, "write machine-readable JSON to stdout"),
+		args:   args,
+	}
+	if c.scoped {
+		in.collection = flags.String("collection", models.DefaultCollectionId, "ID of the card collection to work on")
+	}
+	return in
+}
+
+// parse parses the arguments of a command, which must leave the given number of positional arguments. It returns ctx
+// scoped to the collection given with -collection, if the command has the flag.
+func (a *App) parse(ctx context.Context, in *invocation, positional int) (context.Context, error) {
+	if err := in.flags.Parse(in.args); err == flag.ErrHelp {
+		return ctx, err
+	} else if err != nil {
+		return ctx, ErrUsage
+	}
+
+	if in.flags.NArg() != positional {
+		in.flags.Usage()
+		return ctx, ErrUsage
+	}
+	if in.collection != nil {
+		ctx = tenant.WithCollection(ctx, *in.collection)
+	}
+	return ctx, nil
+}
+
+// write writes v to w as JSON if asJson is set, and as text otherwise.
+func write(w io.Writer, asJson bool, v interface{}, text func(w io.Writer) error) error {
+	if !asJson {
+		return text(w)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/progress"
+	"ygo-card-processor/pkg/tenant"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+var now = time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+
+func newTestApp(dbHandler *mocks.DbHandler, cardProcessor *mocks.CardProcessor) (*App, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return &App{
+		Handler:   dbHandler,
+		Processor: cardProcessor,
+		Progress:  progress.NewBroadcaster(),
+		Reader:    &mocks.FileReader{},
+		Out:       out,
+		Err:       &bytes.Buffer{},
+		Clock:     func() time.Time { return now },
+	}, out
+}
+
+func testCard() models.CardWithPriceInfo {
+	return models.CardWithPriceInfo{
+		CardInfo: models.Card{
+			Name:    "Dark Magician",
+			GroupId: 23,
+			ExtendedData: []models.ExtendedData{
+				{Name: "Number", Value: "SDY-006"},
+				{Name: "Rarity", Value: "Ultra Rare"},
+			},
+		},
+		PriceInfo: []models.PriceResults{{SubTypeName: "1st Edition", MarketPrice: 12.5}},
+	}
+}
+
+func TestCli_Run_ShouldReturnUsageErrorIfCommandUnknown(t *testing.T) {
+	app, _ := newTestApp(&mocks.DbHandler{}, &mocks.CardProcessor{})
+	require.Equal(t, ErrUsage, app.Run(context.Background(), nil))
+	require.Equal(t, ErrUsage, app.Run(context.Background(), []string{"test"}))
+	require.Equal(t, ErrUsage, app.Run(context.Background(), []string{"show"}))
+}
+
+func TestCli_Show_ShouldWriteCardAsJson(t *testing.T) {
+	card := testCard()
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCardByNumber", mock.Anything, "SDY-006").Return(&card, nil)
+
+	app, out := newTestApp(dbHandler, &mocks.CardProcessor{})
+	require.Nil(t, app.Run(context.Background(), []string{"show", "-json", "SDY-006"}))
+
+	var written models.CardWithPriceInfo
+	require.Nil(t, json.Unmarshal(out.Bytes(), &written))
+	require.Equal(t, "Dark Magician", written.CardInfo.Name)
+}
+
+func TestCli_Value_ShouldWriteTotalMarketValue(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCards", mock.Anything, map[string]interface{}{}).Return([]models.CardWithPriceInfo{testCard(), {}}, nil)
+
+	app, out := newTestApp(dbHandler, &mocks.CardProcessor{})
+	require.Nil(t, app.Run(context.Background(), []string{"value"}))
+	require.Contains(t, out.String(), "Total market value  12.50")
+	require.Contains(t, out.String(), "Unpriced            1")
+}
+
+func TestCli_Export_ShouldWriteCsvToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cards.csv")
+
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCards", mock.Anything, map[string]interface{}{}).Return([]models.CardWithPriceInfo{testCard()}, nil)
+
+	app, out := newTestApp(dbHandler, &mocks.CardProcessor{})
+	require.Nil(t, app.Run(context.Background(), []string{"export", "-output", path}))
+	require.Empty(t, out.String())
+
+	contents, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, strings.Join(exportColumns, ","), lines[0])
+	require.Equal(t, "SDY-006,Dark Magician,23,Ultra Rare,,,,12.50,product,,", lines[1])
+}
+
+func TestCli_Dedupe_ShouldOnlyListDuplicatesOnDryRun(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("DedupeCards", mock.Anything, true).Return([]models.DuplicateCards{
+		{Serial: "SDY-006", Kept: "a", Removed: []string{"b", "c"}},
+	}, nil)
+
+	app, out := newTestApp(dbHandler, &mocks.CardProcessor{})
+	require.Nil(t, app.Run(context.Background(), []string{"dedupe", "-dry-run"}))
+	require.Contains(t, out.String(), "Would remove 2 duplicate cards of 1 serial numbers")
+	dbHandler.AssertExpectations(t)
+}
+
+func TestCli_Migrate_ShouldWriteAppliedMigrationsAsJson(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("Migrate", mock.Anything).Return([]string{"001-card-indexes"}, nil)
+
+	app, out := newTestApp(dbHandler, &mocks.CardProcessor{})
+	require.Nil(t, app.Run(context.Background(), []string{"migrate", "-json"}))
+	require.JSONEq(t, `{"applied": ["001-card-indexes"]}`, out.String())
+}
+
+func TestCli_Migrate_ShouldReturnErrorIfMigrationFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("Migrate", mock.Anything).Return([]string{}, errors.New("test"))
+
+	app, _ := newTestApp(dbHandler, &mocks.CardProcessor{})
+	require.NotNil(t, app.Run(context.Background(), []string{"migrate"}))
+}
+
+func TestCli_Refresh_ShouldRefreshSelectedCardsAndFollowJobUntilFinished(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeRefresh, Status: models.JobStatusRunning, Total: 2}
+	finished := *job
+	finished.Status = models.JobStatusFinished
+	finished.Processed = 2
+
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Refresh", mock.Anything, models.CardFilter{
+		Serials:      []string{"SDY-006", "LOB-001"},
+		PricedBefore: now.Add(-72 * time.Hour),
+	}, 10).Return(job, nil)
+
+	dbHandler := &mocks.DbHandler{}
+	app, out := newTestApp(dbHandler, cardProcessor)
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(job, nil).Once().Run(func(mock.Arguments) {
+		go app.Progress.Publish(progress.FromJob(finished))
+	})
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(&finished, nil).Once()
+
+	require.Nil(t, app.Run(context.Background(), []string{"refresh", "-json", "-serials", "SDY-006, LOB-001", "-stale", "72h", "-budget", "10"}))
+
+	var written models.Job
+	require.Nil(t, json.Unmarshal(out.Bytes(), &written))
+	require.Equal(t, models.JobStatusFinished, written.Status)
+	require.Equal(t, 2, written.Processed)
+	cardProcessor.AssertExpectations(t)
+}
+
+func TestCli_Import_ShouldReturnErrorIfJobCancelled(t *testing.T) {
+	file, err := ioutil.TempFile("", "cards*.xlsx")
+	require.Nil(t, err)
+	defer os.Remove(file.Name())
+	require.Nil(t, file.Close())
+
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeImport, Status: models.JobStatusCancelled}
+
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("ImportSerials", mock.Anything, []string{"SDY-006"}).Return(job, nil)
+
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(job, nil)
+
+	app, out := newTestApp(dbHandler, cardProcessor)
+	app.Reader.(*mocks.FileReader).On("OpenAndReadFile", mock.Anything).Return([]string{"SDY-006"}, nil)
+
+	require.NotNil(t, app.Run(context.Background(), []string{"import", file.Name()}))
+	require.Contains(t, out.String(), "cancelled")
+}
+
+func TestCli_Follow_ShouldCancelJobIfInterrupted(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeRefresh, Status: models.JobStatusRunning}
+	cancelled := *job
+	cancelled.Status = models.JobStatusCancelled
+
+	dbHandler := &mocks.DbHandler{}
+	cardProcessor := &mocks.CardProcessor{}
+	app, _ := newTestApp(dbHandler, cardProcessor)
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(job, nil).Once()
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(&cancelled, nil).Once()
+	cardProcessor.On("Cancel", mock.Anything, job.Id).Return(nil).Run(func(mock.Arguments) {
+		go app.Progress.Publish(progress.FromJob(cancelled))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	followed, err := app.follow(ctx, job, true)
+	require.Nil(t, err)
+	require.Equal(t, models.JobStatusCancelled, followed.Status)
+	cardProcessor.AssertExpectations(t)
+}
+
+func TestCli_CreateKey_ShouldStoreKeyAndWriteItOnce(t *testing.T) {
+	id := primitive.NewObjectID()
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddApiKey", mock.Anything, mock.MatchedBy(func(key models.ApiKey) bool {
+		return key.Name == "bootstrap" && key.Role == models.RoleAdmin && key.CreatedAt.Equal(now)
+	})).Return(id, nil)
+
+	app, out := newTestApp(dbHandler, &mocks.CardProcessor{})
+	require.Nil(t, app.Run(context.Background(), []string{"create-key", "-json", "-name", "bootstrap"}))
+
+	var written models.NewApiKey
+	require.Nil(t, json.Unmarshal(out.Bytes(), &written))
+	require.Equal(t, id, written.Id)
+	require.True(t, strings.HasPrefix(written.Key, "ygo_"))
+	dbHandler.AssertExpectations(t)
+}
+
+func TestCli_CreateKey_ShouldReturnUsageErrorIfRoleInvalid(t *testing.T) {
+	app, _ := newTestApp(&mocks.DbHandler{}, &mocks.CardProcessor{})
+	require.Equal(t, ErrUsage, app.Run(context.Background(), []string{"create-key", "-name", "bootstrap", "-role", "owner"}))
+}
+
+func TestCli_Value_ShouldUseGivenCollection(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCards", mock.MatchedBy(func(ctx context.Context) bool {
+		return tenant.CollectionId(ctx) == "binder"
+	}), map[string]interface{}{}).Return([]models.CardWithPriceInfo{testCard()}, nil)
+
+	app, out := newTestApp(dbHandler, &mocks.CardProcessor{})
+	require.Nil(t, app.Run(context.Background(), []string{"value", "-collection", "binder"}))
+	require.Contains(t, out.String(), "Total market value  12.50")
+	dbHandler.AssertExpectations(t)
+}
@@ -0,0 +1,290 @@
+package cli
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/auth"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/valuation"
+)
+
+// exportColumns are the columns of a CSV export, one row per card.
+var exportColumns = []string{"serial", "name", "groupId", "rarity", "condition", "printing", "language", "marketPrice", "priceSource", "pricedAt", "addedAt"}
+
+func (a *App) importFile(ctx context.Context, in *invocation) error {
+	ctx, err := a.parse(ctx, in, 1)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(in.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	serials, err := a.Reader.OpenAndReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading spreadsheet: %w", err)
+	}
+
+	job, err := a.Processor.ImportSerials(ctx, serials)
+	if err != nil {
+		return err
+	}
+	return a.runJob(ctx, job, *in.asJson)
+}
+
+func (a *App) refresh(ctx context.Context, in *invocation) error {
+	serials := in.flags.String("serials", "", "comma separated serial numbers of the cards to refresh")
+	groupId := in.flags.Int("set", 0, "tcgplayer.com group ID of the set to refresh")
+	rarity := in.flags.String("rarity", "", "rarity of the cards to refresh")
+	minPrice := in.flags.Float64("min-price", 0, "refresh only cards with a market price of at least this much")
+	stale := in.flags.Duration("stale", 0, "refresh only cards last priced longer ago than this, such as 72h")
+	budget := in.flags.Int("budget", 0, "refresh at most this many cards, least recently priced first")
+	ctx, err := a.parse(ctx, in, 0)
+	if err != nil {
+		return err
+	}
+
+	filter := models.CardFilter{
+		GroupId:        *groupId,
+		Rarity:         *rarity,
+		MinMarketPrice: *minPrice,
+	}
+	for _, serial := range strings.Split(*serials, ",") {
+		if serial = strings.TrimSpace(serial); serial != "" {
+			filter.Serials = append(filter.Serials, serial)
+		}
+	}
+	if *stale > 0 {
+		filter.PricedBefore = a.Clock().Add(-*stale)
+	}
+
+	job, err := a.Processor.Refresh(ctx, filter, *budget)
+	if err != nil {
+		return err
+	}
+	return a.runJob(ctx, job, *in.asJson)
+}
+
+func (a *App) export(ctx context.Context, in *invocation) error {
+	output := in.flags.String("output", "", "file to write to instead of stdout")
+	ctx, err := a.parse(ctx, in, 0)
+	if err != nil {
+		return err
+	}
+
+	cards, err := a.Handler.GetCards(ctx, dao.CardQuery(models.CardFilter{}))
+	if err != nil {
+		return err
+	}
+
+	out := a.Out
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	return write(out, *in.asJson, cards, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		_ = writer.Write(exportColumns)
+		for _, card := range cards {
+			marketPrice, source := valuation.MarketValue(card)
+			_ = writer.Write([]string{
+				card.CardInfo.Serial(),
+				card.CardInfo.Name,
+				strconv.Itoa(card.CardInfo.GroupId),
+				cardRarity(card.CardInfo),
+				card.Condition,
+				card.Printing,
+				card.Language,
+				strconv.FormatFloat(marketPrice, 'f', 2, 64),
+				source,
+				formatTime(card.PricedAt),
+				formatTime(card.AddedAt),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+}
+
+func (a *App) show(ctx context.Context, in *invocation) error {
+	ctx, err := a.parse(ctx, in, 1)
+	if err != nil {
+		return err
+	}
+
+	card, err := a.Handler.GetCardByNumber(ctx, in.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return write(a.Out, *in.asJson, card, func(w io.Writer) error {
+		marketPrice, source := valuation.MarketValue(*card)
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(table, "Serial\t%v\n", card.CardInfo.Serial())
+		fmt.Fprintf(table, "Name\t%v\n", card.CardInfo.Name)
+		fmt.Fprintf(table, "Product ID\t%v\n", card.CardInfo.ProductId)
+		fmt.Fprintf(table, "Group ID\t%v\n", card.CardInfo.GroupId)
+		fmt.Fprintf(table, "Rarity\t%v\n", cardRarity(card.CardInfo))
+		fmt.Fprintf(table, "Condition\t%v\n", card.Condition)
+		fmt.Fprintf(table, "Printing\t%v\n", card.Printing)
+		fmt.Fprintf(table, "Language\t%v\n", card.Language)
+		fmt.Fprintf(table, "Market price\t%.2f (%v)\n", marketPrice, source)
+		fmt.Fprintf(table, "Priced at\t%v\n", formatTime(card.PricedAt))
+		fmt.Fprintf(table, "Added at\t%v\n", formatTime(card.AddedAt))
+		return table.Flush()
+	})
+}
+
+func (a *App) value(ctx context.Context, in *invocation) error {
+	ctx, err := a.parse(ctx, in, 0)
+	if err != nil {
+		return err
+	}
+
+	cards, err := a.Handler.GetCards(ctx, dao.CardQuery(models.CardFilter{}))
+	if err != nil {
+		return err
+	}
+
+	value := valuation.CollectionValue(cards)
+	return write(a.Out, *in.asJson, value, func(w io.Writer) error {
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(table, "Cards\t%v\n", value.CardCount)
+		fmt.Fprintf(table, "Unpriced\t%v\n", value.UnpricedCount)
+		fmt.Fprintf(table, "Total market value\t%.2f\n", value.TotalMarketValue)
+		return table.Flush()
+	})
+}
+
+func (a *App) migrate(ctx context.Context, in *invocation) error {
+	if _, err := a.parse(ctx, in, 0); err != nil {
+		return err
+	}
+
+	applied, err := a.Handler.Migrate(ctx)
+	if err != nil {
+		return err
+	}
+
+	return write(a.Out, *in.asJson, map[string][]string{"applied": applied}, func(w io.Writer) error {
+		if len(applied) == 0 {
+			fmt.Fprintln(w, "Database is up to date")
+		}
+		for _, name := range applied {
+			fmt.Fprintf(w, "Applied %v\n", name)
+		}
+		return nil
+	})
+}
+
+func (a *App) dedupe(ctx context.Context, in *invocation) error {
+	dryRun := in.flags.Bool("dry-run", false, "list the duplicates without removing them")
+	ctx, err := a.parse(ctx, in, 0)
+	if err != nil {
+		return err
+	}
+
+	duplicates, err := a.Handler.DedupeCards(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	result := map[string]interface{}{"dryRun": *dryRun, "duplicates": duplicates}
+	return write(a.Out, *in.asJson, result, func(w io.Writer) error {
+		verb := "Removed"
+		if *dryRun {
+			verb = "Would remove"
+		}
+		removed := 0
+		for _, duplicate := range duplicates {
+			fmt.Fprintf(w, "%v: kept %v, %v %v\n", duplicate.Serial, duplicate.Kept, strings.ToLower(verb), strings.Join(duplicate.Removed, ", "))
+			removed += len(duplicate.Removed)
+		}
+		_, err := fmt.Fprintf(w, "%v %v duplicate cards of %v serial numbers\n", verb, removed, len(duplicates))
+		return err
+	})
+}
+
+func (a *App) createKey(ctx context.Context, in *invocation) error {
+	name := in.flags.String("name", "", "name telling the key's owner or purpose")
+	role := in.flags.String("role", models.RoleAdmin, "role of the key: reader, editor or admin")
+	if _, err := a.parse(ctx, in, 0); err != nil {
+		return err
+	}
+
+	key, err := auth.GenerateKey(*name, *role, a.Clock())
+	if err != nil {
+		fmt.Fprintln(a.Err, err)
+		return ErrUsage
+	}
+	if key.Id, err = a.Handler.AddApiKey(ctx, key.ApiKey); err != nil {
+		return err
+	}
+
+	return write(a.Out, *in.asJson, key, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Created %v key %v (%v). It cannot be shown again:\n%v\n", key.Role, key.Name, key.Id.Hex(), key.Key)
+		return err
+	})
+}
+
+// runJob follows a job started by a command until it ends, and writes the job as it ended. A job that did not finish,
+// because it was cancelled, is an error.
+func (a *App) runJob(ctx context.Context, job *models.Job, asJson bool) error {
+	job, err := a.follow(ctx, job, !asJson)
+	if err != nil {
+		return err
+	}
+
+	err = write(a.Out, asJson, job, func(w io.Writer) error {
+		fmt.Fprintf(w, "Job %v %v: %v of %v cards processed, %v failed\n", job.Id.Hex(), job.Status, job.Processed, job.Total, job.Failed)
+		for _, notFound := range job.NotFound {
+			fmt.Fprintf(w, "Not found: %v (row %v)\n", notFound.Serial, notFound.Row)
+		}
+		for _, ambiguous := range job.Ambiguous {
+			fmt.Fprintf(w, "Ambiguous: %v (row %v) matches %v products\n", ambiguous.Serial, ambiguous.Row, len(ambiguous.Candidates))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if job.Status != models.JobStatusFinished {
+		return fmt.Errorf("job %v was %v", job.Id.Hex(), job.Status)
+	}
+	return nil
+}
+
+// cardRarity returns the rarity listed in a card's extended data.
+func cardRarity(card models.Card) string {
+	for _, data := range card.ExtendedData {
+		if data.Name == "Rarity" {
+			return data.Value
+		}
+	}
+	return ""
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
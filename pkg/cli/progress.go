@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/progress"
+	"ygo-card-processor/pkg/tenant"
+)
+
+// progressBarWidth is the number of characters the bar drawn while following a job fills.
+const progressBarWidth = 30
+
+// follow waits for a job to end and returns it as stored, drawing a progress bar on Err if bar is set. If ctx is done
+// first, for example because the CLI was interrupted, the job is cancelled rather than left running without it.
+func (a *App) follow(ctx context.Context, job *models.Job, bar bool) (*models.Job, error) {
+	updates, _, unsubscribe := a.Progress.Subscribe(job.Id.Hex())
+	defer unsubscribe()
+
+	// The job may have ended before it was subscribed to, in which case no update follows.
+	stored, err := a.Handler.GetJob(ctx, job.Id)
+	if err != nil {
+		return nil, err
+	} else if progress.Ended(stored.Status) {
+		return stored, nil
+	}
+
+	// Once ctx is done it can no longer be used, but the job is still looked up in its collection.
+	background := tenant.WithCollection(context.Background(), tenant.CollectionId(ctx))
+	done := ctx.Done()
+	for following := true; following; {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				following = false
+				continue
+			}
+			if bar {
+				drawProgressBar(a.Err, update)
+			}
+		case <-done:
+			done = nil
+			if err := a.Processor.Cancel(background, job.Id); err != nil {
+				logrus.WithError(err).Error(fmt.Sprintf("Error cancelling job %v", job.Id.Hex()))
+			}
+		}
+	}
+	if bar {
+		fmt.Fprintln(a.Err)
+	}
+
+	return a.Handler.GetJob(background, job.Id)
+}
+
+// drawProgressBar redraws the progress bar of a job on the current line.
+func drawProgressBar(w io.Writer, update models.JobProgress) {
+	done := update.Processed + update.Failed + update.Unresolved
+	if done > update.Total {
+		done = update.Total
+	}
+
+	filled := 0
+	if update.Total > 0 {
+		filled = progressBarWidth * done / update.Total
+	}
+	line := fmt.Sprintf("[%v%v] %v/%v cards", strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled), done, update.Total)
+	if update.Failed > 0 {
+		line += fmt.Sprintf(", %v failed", update.Failed)
+	}
+	if update.Status == models.JobStatusPaused {
+		line += ", paused"
+	} else if update.EtaSeconds > 0 {
+		line += fmt.Sprintf(", %v left", (time.Duration(update.EtaSeconds) * time.Second).String())
+	}
+
+	// Padding clears what is left of a longer previous line.
+	fmt.Fprintf(w, "\r%-80v", line)
+}
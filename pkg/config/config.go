@@ -0,0 +1,399 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the processor. It is loaded by Load from, in increasing order of precedence, defaults,
+// a YAML file, environment variables and command line flags.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Mongo     MongoConfig     `yaml:"mongo"`
+	Tcgplayer TcgplayerConfig `yaml:"tcgplayer"`
+	Events    EventsConfig    `yaml:"events"`
+	Commands  CommandsConfig  `yaml:"commands"`
+	Schedules SchedulesConfig `yaml:"schedules"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Limits    LimitsConfig    `yaml:"limits"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Logging   LoggingConfig   `yaml:"logging"`
+}
+
+type ServerConfig struct {
+	Addr         string        `yaml:"addr"`
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	CorsOrigins  []string      `yaml:"corsOrigins"`
+}
+
+// MongoConfig locates the database. CredentialsKey is the base64 encoded AES-256 key the tcgplayer.com private keys of
+// collections are encrypted with before they are stored. Collections cannot have credentials of their own without it.
+type MongoConfig struct {
+	Uri            string `yaml:"uri"`
+	Database       string `yaml:"database"`
+	Collection     string `yaml:"collection"`
+	CredentialsKey string `yaml:"credentialsKey"`
+}
+
+type TcgplayerConfig struct {
+	Url        string        `yaml:"url"`
+	ApiVersion string        `yaml:"apiVersion"`
+	Timeout    time.Duration `yaml:"timeout"`
+	PublicKey  string        `yaml:"publicKey"`
+	PrivateKey string        `yaml:"privateKey"`
+}
+
+// EventsConfig selects the event sinks. If no sinks are listed, events go to Kafka when a broker is configured and to
+// stdout otherwise.
+type EventsConfig struct {
+	Sinks  []string `yaml:"sinks"`
+	Broker string   `yaml:"broker"`
+	Topic  string   `yaml:"topic"`
+	File   string   `yaml:"file"`
+	Format string   `yaml:"format"`
+}
+
+// CommandsConfig enables consuming commands from Kafka if a topic is set. The broker is the one events are produced to.
+type CommandsConfig struct {
+	Topic string `yaml:"topic"`
+	Group string `yaml:"group"`
+}
+
+// SchedulesConfig lists refresh schedules to add on startup, as a JSON list given directly or in a file.
+type SchedulesConfig struct {
+	File string `yaml:"file"`
+	Json string `yaml:"json"`
+}
+
+// AuthConfig configures how API clients authenticate. Clients present an API key, or a JWT signed with JwtSecret if one
+// is set. Disabled lets every request through as an admin, for local development only.
+type AuthConfig struct {
+	Disabled  bool   `yaml:"disabled"`
+	JwtSecret string `yaml:"jwtSecret"`
+	JwtIssuer string `yaml:"jwtIssuer"`
+}
+
+// LimitsConfig limits how often each client may call the API and how large request bodies may be. Expensive requests,
+// which start jobs or call tcgplayer.com, have a stricter rate of their own. A rate of 0 disables that limit.
+type LimitsConfig struct {
+	RequestsPerMinute          int   `yaml:"requestsPerMinute"`
+	Burst                      int   `yaml:"burst"`
+	ExpensiveRequestsPerMinute int   `yaml:"expensiveRequestsPerMinute"`
+	ExpensiveBurst             int   `yaml:"expensiveBurst"`
+	MaxBodyBytes               int64 `yaml:"maxBodyBytes"`
+	MaxUploadBytes             int64 `yaml:"maxUploadBytes"`
+}
+
+// TracingConfig selects where OpenTelemetry spans are exported: to an OTLP/HTTP collector at Endpoint, to stdout, or
+// nowhere. SampleRatio is the share of traces recorded, unless the caller of a request already decided.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// LoggingConfig sets the format log entries are written in, text or JSON, and the least severe level logged.
+type LoggingConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+// Default returns the configuration used for every setting not given in a file, the environment or a flag.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:         ":8001",
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 5 * time.Second,
+		},
+		Mongo: MongoConfig{
+			Database:   "db",
+			Collection: "yugioh",
+		},
+		Tcgplayer: TcgplayerConfig{
+			Url:        "https://api.tcgplayer.com",
+			ApiVersion: "v1.37.0",
+			Timeout:    5 * time.Second,
+		},
+		Commands: CommandsConfig{
+			Group: "ygo-card-processor",
+		},
+		Limits: LimitsConfig{
+			RequestsPerMinute:          120,
+			Burst:                      60,
+			ExpensiveRequestsPerMinute: 6,
+			ExpensiveBurst:             3,
+			MaxBodyBytes:               1 << 20,
+			MaxUploadBytes:             10 << 20,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "localhost:4318",
+			SampleRatio: 1,
+		},
+		Logging: LoggingConfig{
+			Format: "text",
+			Level:  "info",
+		},
+	}
+}
+
+// minJwtSecretLength is the shortest JWT secret accepted, since HS256 is only as strong as its secret.
+const minJwtSecretLength = 32
+
+// CredentialsKeyLength is the length of the decoded credentials key, which selects AES-256.
+const CredentialsKeyLength = 32
+
+// setting binds a configuration value to its environment variable and command line flag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"SERVER_ADDR", "addr", "address the API listens on", setString(func(c *Config) *string { return &c.Server.Addr })},
+	{"SERVER_READ_TIMEOUT", "read-timeout", "timeout for reading requests", setDuration(func(c *Config) *time.Duration { return &c.Server.ReadTimeout })},
+	{"SERVER_WRITE_TIMEOUT", "write-timeout", "timeout for writing responses", setDuration(func(c *Config) *time.Duration { return &c.Server.WriteTimeout })},
+	{"CORS_ORIGINS", "cors-origins", "comma separated origins allowed to call the API from browsers, or * for any", setList(func(c *Config) *[]string { return &c.Server.CorsOrigins })},
+	{"MONGO_URI", "mongo-uri", "MongoDB connection string", setString(func(c *Config) *string { return &c.Mongo.Uri })},
+	{"MONGO_DATABASE", "mongo-database", "MongoDB database", setString(func(c *Config) *string { return &c.Mongo.Database })},
+	{"MONGO_COLLECTION", "mongo-collection", "MongoDB collection cards are stored in", setString(func(c *Config) *string { return &c.Mongo.Collection })},
+	{"CREDENTIALS_KEY", "credentials-key", "base64 encoded 32 byte key collection private keys are encrypted with", setString(func(c *Config) *string { return &c.Mongo.CredentialsKey })},
+	{"TCGPLAYER_URL", "tcgplayer-url", "tcgplayer.com API base URL", setString(func(c *Config) *string { return &c.Tcgplayer.Url })},
+	{"TCGPLAYER_API_VERSION", "tcgplayer-api-version", "tcgplayer.com API version", setString(func(c *Config) *string { return &c.Tcgplayer.ApiVersion })},
+	{"TCGPLAYER_TIMEOUT", "tcgplayer-timeout", "timeout for tcgplayer.com API calls", setDuration(func(c *Config) *time.Duration { return &c.Tcgplayer.Timeout })},
+	{"PUBLIC_KEY", "public-key", "tcgplayer.com API public key", setString(func(c *Config) *string { return &c.Tcgplayer.PublicKey })},
+	{"PRIVATE_KEY", "private-key", "tcgplayer.com API private key", setString(func(c *Config) *string { return &c.Tcgplayer.PrivateKey })},
+	{"EVENT_SINKS", "event-sinks", "comma separated event sinks: kafka, file, stdout, stderr or memory", setList(func(c *Config) *[]string { return &c.Events.Sinks })},
+	{"BROKER", "broker", "Kafka broker", setString(func(c *Config) *string { return &c.Events.Broker })},
+	{"TOPIC", "topic", "Kafka topic events are produced to", setString(func(c *Config) *string { return &c.Events.Topic })},
+	{"EVENT_FILE", "event-file", "file the file event sink appends to", setString(func(c *Config) *string { return &c.Events.File })},
+	{"EVENT_FORMAT", "event-format", "domain event format: json or cloudevents", setString(func(c *Config) *string { return &c.Events.Format })},
+	{"COMMAND_TOPIC", "command-topic", "Kafka topic commands are consumed from", setString(func(c *Config) *string { return &c.Commands.Topic })},
+	{"COMMAND_GROUP", "command-group", "Kafka consumer group for commands", setString(func(c *Config) *string { return &c.Commands.Group })},
+	{"SCHEDULES_FILE", "schedules-file", "file with a JSON list of refresh schedules", setString(func(c *Config) *string { return &c.Schedules.File })},
+	{"SCHEDULES", "schedules", "JSON list of refresh schedules", setString(func(c *Config) *string { return &c.Schedules.Json })},
+	{"AUTH_DISABLED", "auth-disabled", "let every request through without authentication, for local development only", setBool(func(c *Config) *bool { return &c.Auth.Disabled })},
+	{"JWT_SECRET", "jwt-secret", "secret JWTs presented by clients are signed with (HS256)", setString(func(c *Config) *string { return &c.Auth.JwtSecret })},
+	{"JWT_ISSUER", "jwt-issuer", "issuer JWTs presented by clients must have", setString(func(c *Config) *string { return &c.Auth.JwtIssuer })},
+	{"RATE_LIMIT", "rate-limit", "requests a minute each client may make, or 0 for no limit", setInt(func(c *Config) *int { return &c.Limits.RequestsPerMinute })},
+	{"RATE_LIMIT_BURST", "rate-limit-burst", "requests each client may make at once", setInt(func(c *Config) *int { return &c.Limits.Burst })},
+	{"EXPENSIVE_RATE_LIMIT", "expensive-rate-limit", "expensive requests a minute each client may make, or 0 for no limit", setInt(func(c *Config) *int { return &c.Limits.ExpensiveRequestsPerMinute })},
+	{"EXPENSIVE_RATE_LIMIT_BURST", "expensive-rate-limit-burst", "expensive requests each client may make at once", setInt(func(c *Config) *int { return &c.Limits.ExpensiveBurst })},
+	{"MAX_BODY_BYTES", "max-body-bytes", "largest request body accepted, in bytes", setInt64(func(c *Config) *int64 { return &c.Limits.MaxBodyBytes })},
+	{"MAX_UPLOAD_BYTES", "max-upload-bytes", "largest file upload accepted, in bytes", setInt64(func(c *Config) *int64 { return &c.Limits.MaxUploadBytes })},
+	{"TRACING_EXPORTER", "tracing-exporter", "where spans are exported: none, otlp or stdout", setString(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"OTLP_ENDPOINT", "otlp-endpoint", "host and port of the OTLP/HTTP collector spans are exported to", setString(func(c *Config) *string { return &c.Tracing.Endpoint })},
+	{"OTLP_INSECURE", "otlp-insecure", "export spans over plain HTTP instead of HTTPS", setBool(func(c *Config) *bool { return &c.Tracing.Insecure })},
+	{"TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "share of traces recorded, from 0 to 1", setFloat(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+	{"LOG_FORMAT", "log-format", "format log entries are written in: text or json", setString(func(c *Config) *string { return &c.Logging.Format })},
+	{"LOG_LEVEL", "log-level", "least severe level logged: debug, info, warn or error", setString(func(c *Config) *string { return &c.Logging.Level })},
+}
+
+// Load loads the configuration from the file named by flag -config or environment variable CONFIG_FILE, the
+// environment and the command line arguments, and validates it.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	config, _, err := Parse("ygo-card-processor", args, getenv)
+	return config, err
+}
+
+// Parse is Load for programs taking arguments after the configuration flags, such as a subcommand. It also returns the
+// arguments following the flags.
+func Parse(name string, args []string, getenv func(string) string) (*Config, []string, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := flags.String("config", getenv("CONFIG_FILE"), "YAML configuration file")
+	values := make(map[string]*string)
+	for _, s := range settings {
+		values[s.flag] = flags.String(s.flag, "", fmt.Sprintf("%v (%v)", s.usage, s.env))
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	config := Default()
+	if *path != "" {
+		if err := config.loadFile(*path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(&config, value); err != nil {
+				return nil, nil, fmt.Errorf("invalid %v: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if setErr := s.set(&config, *values[s.flag]); setErr != nil {
+					err = fmt.Errorf("invalid -%v: %w", s.flag, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return &config, flags.Args(), nil
+}
+
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading configuration file: %w", err)
+	}
+	defer file.Close()
+
+	// Unknown keys are rejected, so a misspelt setting is not silently left at its default.
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("error parsing configuration file %v: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once, naming the environment variable that sets it.
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	require := func(ok bool, env string, problem string) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%v %v", env, problem))
+		}
+	}
+
+	require(c.Server.Addr != "", "SERVER_ADDR", "is required")
+	require(c.Server.ReadTimeout > 0, "SERVER_READ_TIMEOUT", "must be positive")
+	require(c.Server.WriteTimeout > 0, "SERVER_WRITE_TIMEOUT", "must be positive")
+	require(c.Mongo.Uri != "", "MONGO_URI", "is required")
+	require(c.Mongo.Database != "", "MONGO_DATABASE", "is required")
+	require(c.Mongo.Collection != "", "MONGO_COLLECTION", "is required")
+	require(c.Mongo.CredentialsKey == "" || validCredentialsKey(c.Mongo.CredentialsKey), "CREDENTIALS_KEY", fmt.Sprintf("must be %v bytes encoded in base64", CredentialsKeyLength))
+	baseUrl, err := url.Parse(c.Tcgplayer.Url)
+	require(err == nil && baseUrl.Scheme != "" && baseUrl.Host != "", "TCGPLAYER_URL", "must be an absolute URL")
+	require(c.Tcgplayer.ApiVersion != "", "TCGPLAYER_API_VERSION", "is required")
+	require(c.Tcgplayer.Timeout > 0, "TCGPLAYER_TIMEOUT", "must be positive")
+	require(c.Tcgplayer.PublicKey != "", "PUBLIC_KEY", "is required")
+	require(c.Tcgplayer.PrivateKey != "", "PRIVATE_KEY", "is required")
+	require(c.Commands.Topic == "" || c.Events.Broker != "", "BROKER", "is required to consume commands")
+	require(c.Commands.Topic == "" || c.Commands.Group != "", "COMMAND_GROUP", "is required to consume commands")
+	require(c.Limits.RequestsPerMinute >= 0, "RATE_LIMIT", "must not be negative")
+	require(c.Limits.RequestsPerMinute == 0 || c.Limits.Burst > 0, "RATE_LIMIT_BURST", "must be positive")
+	require(c.Limits.ExpensiveRequestsPerMinute >= 0, "EXPENSIVE_RATE_LIMIT", "must not be negative")
+	require(c.Limits.ExpensiveRequestsPerMinute == 0 || c.Limits.ExpensiveBurst > 0, "EXPENSIVE_RATE_LIMIT_BURST", "must be positive")
+	require(c.Limits.MaxBodyBytes > 0, "MAX_BODY_BYTES", "must be positive")
+	require(c.Limits.MaxUploadBytes > 0, "MAX_UPLOAD_BYTES", "must be positive")
+	require(c.Tracing.Exporter == "none" || c.Tracing.Exporter == "otlp" || c.Tracing.Exporter == "stdout", "TRACING_EXPORTER", "must be none, otlp or stdout")
+	require(c.Tracing.Exporter != "otlp" || c.Tracing.Endpoint != "", "OTLP_ENDPOINT", "is required to export spans over OTLP")
+	require(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "TRACING_SAMPLE_RATIO", "must be between 0 and 1")
+	require(c.Logging.Format == "text" || c.Logging.Format == "json", "LOG_FORMAT", "must be text or json")
+	require(c.Logging.Level == "debug" || c.Logging.Level == "info" || c.Logging.Level == "warn" || c.Logging.Level == "error", "LOG_LEVEL", "must be debug, info, warn or error")
+	require(c.Auth.JwtSecret == "" || len(c.Auth.JwtSecret) >= minJwtSecretLength, "JWT_SECRET", fmt.Sprintf("must be at least %v characters", minJwtSecretLength))
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func validCredentialsKey(key string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(decoded) == CredentialsKeyLength
+}
+
+func setString(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func setBool(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func setInt(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = i
+		return nil
+	}
+}
+
+func setInt64(field func(c *Config) *int64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = i
+		return nil
+	}
+}
+
+func setFloat(field func(c *Config) *float64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = duration
+		return nil
+	}
+}
+
+func setList(field func(c *Config) *[]string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		list := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func env(values map[string]string) func(string) string {
+	return func(key string) string {
+		return values[key]
+	}
+}
+
+func requiredEnv() map[string]string {
+	return map[string]string{
+		"MONGO_URI":   "mongodb://localhost:27017",
+		"PUBLIC_KEY":  "public",
+		"PRIVATE_KEY": "private",
+	}
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestConfig_Load_ShouldUseDefaults(t *testing.T) {
+	cfg, err := Load(nil, env(requiredEnv()))
+	require.Nil(t, err)
+	require.Equal(t, ":8001", cfg.Server.Addr)
+	require.Equal(t, 5*time.Second, cfg.Server.WriteTimeout)
+	require.Empty(t, cfg.Server.CorsOrigins)
+	require.Equal(t, "yugioh", cfg.Mongo.Collection)
+	require.Equal(t, "v1.37.0", cfg.Tcgplayer.ApiVersion)
+	require.Equal(t, "ygo-card-processor", cfg.Commands.Group)
+}
+
+func TestConfig_Load_ShouldGiveFlagsPrecedenceOverEnvironmentOverFile(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  addr: ":9000"
+  readTimeout: 10s
+  corsOrigins: ["https://file.example"]
+mongo:
+  database: file
+  collection: file
+`)
+	values := requiredEnv()
+	values["CONFIG_FILE"] = path
+	values["MONGO_DATABASE"] = "env"
+	values["MONGO_COLLECTION"] = "env"
+	values["CORS_ORIGINS"] = "https://a.example, https://b.example"
+
+	cfg, err := Load([]string{"-mongo-collection", "flag"}, env(values))
+	require.Nil(t, err)
+	require.Equal(t, ":9000", cfg.Server.Addr)
+	require.Equal(t, 10*time.Second, cfg.Server.ReadTimeout)
+	require.Equal(t, 5*time.Second, cfg.Server.WriteTimeout)
+	require.Equal(t, []string{"https://a.example", "https://b.example"}, cfg.Server.CorsOrigins)
+	require.Equal(t, "env", cfg.Mongo.Database)
+	require.Equal(t, "flag", cfg.Mongo.Collection)
+}
+
+func TestConfig_Load_ShouldReadFileGivenByFlag(t *testing.T) {
+	path := writeConfigFile(t, "tcgplayer:\n  apiVersion: v1.39.0\n")
+
+	cfg, err := Load([]string{"-config", path}, env(requiredEnv()))
+	require.Nil(t, err)
+	require.Equal(t, "v1.39.0", cfg.Tcgplayer.ApiVersion)
+}
+
+func TestConfig_Load_ShouldRejectUnknownFileSettings(t *testing.T) {
+	path := writeConfigFile(t, "mongo:\n  url: mongodb://localhost\n")
+
+	_, err := Load([]string{"-config", path}, env(requiredEnv()))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "url")
+}
+
+func TestConfig_Load_ShouldRejectInvalidDuration(t *testing.T) {
+	values := requiredEnv()
+	values["TCGPLAYER_TIMEOUT"] = "five seconds"
+
+	_, err := Load(nil, env(values))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "TCGPLAYER_TIMEOUT")
+}
+
+func TestConfig_Load_ShouldReportEveryInvalidSetting(t *testing.T) {
+	_, err := Load([]string{"-tcgplayer-url", "api.tcgplayer.com", "-command-topic", "commands"}, env(map[string]string{}))
+	require.NotNil(t, err)
+	for _, problem := range []string{"MONGO_URI is required", "PUBLIC_KEY is required", "PRIVATE_KEY is required",
+		"TCGPLAYER_URL must be an absolute URL", "BROKER is required to consume commands"} {
+		require.Contains(t, err.Error(), problem)
+	}
+}
+
+func TestConfig_Parse_ShouldReturnArgumentsAfterFlags(t *testing.T) {
+	cfg, args, err := Parse("ygo-cli", []string{"-mongo-database", "flag", "export", "-json"}, env(requiredEnv()))
+	require.Nil(t, err)
+	require.Equal(t, "flag", cfg.Mongo.Database)
+	require.Equal(t, []string{"export", "-json"}, args)
+}
+
+func TestConfig_Load_ShouldRejectShortJwtSecret(t *testing.T) {
+	values := requiredEnv()
+	values["JWT_SECRET"] = "secret"
+
+	_, err := Load(nil, env(values))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "JWT_SECRET")
+}
+
+func TestConfig_Load_ShouldRejectCredentialsKeyOfWrongLength(t *testing.T) {
+	values := requiredEnv()
+	values["CREDENTIALS_KEY"] = "c2hvcnQ="
+
+	_, err := Load(nil, env(values))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "CREDENTIALS_KEY must be 32 bytes encoded in base64")
+}
+
+func TestConfig_Load_ShouldRejectNegativeRateLimit(t *testing.T) {
+	_, err := Load([]string{"-rate-limit", "-1"}, env(requiredEnv()))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "RATE_LIMIT must not be negative")
+}
+
+func TestConfig_Load_ShouldRejectUnknownTracingExporter(t *testing.T) {
+	values := requiredEnv()
+	values["TRACING_EXPORTER"] = "jaeger"
+
+	_, err := Load(nil, env(values))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "TRACING_EXPORTER must be none, otlp or stdout")
+}
+
+func TestConfig_Load_ShouldRejectUnknownLogFormat(t *testing.T) {
+	_, err := Load([]string{"-log-format", "xml"}, env(requiredEnv()))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "LOG_FORMAT must be text or json")
+}
@@ -0,0 +1,8 @@
+package consumer
+
+import "context"
+
+// CommandHandler handles a single command message read from the command topic.
+type CommandHandler interface {
+	Handle(ctx context.Context, message []byte) error
+}
@@ -0,0 +1,113 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+func TestConsumer_Dispatcher_ShouldRejectInvalidCommand(t *testing.T) {
+	sink := &producer.MemorySink{}
+	dispatcher := Dispatcher{Processor: &mocks.CardProcessor{}, Sink: sink}
+
+	require.Nil(t, dispatcher.Handle(context.Background(), []byte("refresh everything")))
+
+	published := sink.Events()
+	require.Len(t, published, 1)
+	require.Equal(t, models.EventCommandRejected, published[0].Type)
+	require.NotEmpty(t, published[0].Command.Error)
+}
+
+func TestConsumer_Dispatcher_ShouldRejectUnknownCommandType(t *testing.T) {
+	sink := &producer.MemorySink{}
+	dispatcher := Dispatcher{Processor: &mocks.CardProcessor{}, Sink: sink}
+
+	require.Nil(t, dispatcher.Handle(context.Background(), []byte(`{"id": "1", "type": "delete_all"}`)))
+
+	published := sink.Events()
+	require.Len(t, published, 1)
+	require.Equal(t, models.EventCommandRejected, published[0].Type)
+	require.Equal(t, "1", published[0].CorrelationId)
+}
+
+func TestConsumer_Dispatcher_ShouldRejectCommandWithoutRequiredField(t *testing.T) {
+	sink := &producer.MemorySink{}
+	cardProcessor := &mocks.CardProcessor{}
+	dispatcher := Dispatcher{Processor: cardProcessor, Sink: sink}
+
+	require.Nil(t, dispatcher.Handle(context.Background(), []byte(`{"id": "1", "type": "refresh_card"}`)))
+
+	require.Equal(t, models.EventCommandRejected, sink.Events()[0].Type)
+	cardProcessor.AssertNotCalled(t, "RefreshCard", mock.Anything, mock.Anything)
+}
+
+func TestConsumer_Dispatcher_ShouldRejectCommandIfJobCannotStart(t *testing.T) {
+	sink := &producer.MemorySink{}
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("RefreshAll", mock.Anything).Return(nil, errors.New("test"))
+	dispatcher := Dispatcher{Processor: cardProcessor, Sink: sink}
+
+	require.Nil(t, dispatcher.Handle(context.Background(), []byte(`{"id": "1", "type": "refresh_all"}`)))
+
+	published := sink.Events()
+	require.Equal(t, models.EventCommandRejected, published[0].Type)
+	require.Equal(t, "test", published[0].Command.Error)
+}
+
+func TestConsumer_Dispatcher_ShouldStartJobAndReplyWithIt(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeRefresh, Status: models.JobStatusRunning}
+	sink := &producer.MemorySink{}
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("RefreshSet", mock.Anything, 123).Return(job, nil)
+	cardProcessor.On("RefreshCard", mock.Anything, "LOB-001").Return(job, nil)
+	cardProcessor.On("ImportSerials", mock.Anything, []string{"LOB-001", "LOB-002"}).Return(job, nil)
+	dispatcher := Dispatcher{Processor: cardProcessor, Sink: sink}
+
+	require.Nil(t, dispatcher.Handle(context.Background(), []byte(`{"id": "1", "type": "refresh_set", "groupId": 123}`)))
+	require.Nil(t, dispatcher.Handle(context.Background(), []byte(`{"id": "2", "type": "refresh_card", "serial": "LOB-001"}`)))
+	require.Nil(t, dispatcher.Handle(context.Background(), []byte(`{"id": "3", "type": "import_serials", "serials": ["LOB-001", "LOB-002"]}`)))
+
+	published := sink.Events()
+	require.Len(t, published, 3)
+	for i, event := range published {
+		require.Equal(t, models.EventCommandAccepted, event.Type)
+		require.Equal(t, job.Id.Hex(), event.JobId)
+		require.Equal(t, job.Id.Hex(), event.Job.Id)
+		require.Equal(t, event.Command.Id, event.CorrelationId)
+		require.Equal(t, []string{"1", "2", "3"}[i], event.Command.Id)
+	}
+	cardProcessor.AssertExpectations(t)
+}
+
+func TestConsumer_Consumer_ShouldStopWhenContextIsCancelled(t *testing.T) {
+	c, err := createConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": "localhost:1",
+		"group.id":          "test",
+	}, "test", &Dispatcher{})
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Consumer did not stop")
+	}
+	require.Nil(t, c.Close())
+}
@@ -0,0 +1,64 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/events"
+	"ygo-card-processor/pkg/processor"
+	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/tenant"
+)
+
+// Dispatcher starts the job a command asks for through the same card processor the HTTP API uses, and replies with a
+// command_accepted event carrying the job or a command_rejected event carrying the reason. The command ID becomes the
+// correlation ID of the reply and of every event the job produces. Jobs run in the command's collection, or the default
+// collection if it names none.
+type Dispatcher struct {
+	Processor processor.CardProcessor
+	Sink      producer.EventSink
+}
+
+func (d *Dispatcher) Handle(ctx context.Context, message []byte) error {
+	var command models.Command
+	var job *models.Job
+	err := json.Unmarshal(message, &command)
+	if err != nil {
+		err = fmt.Errorf("invalid command: %w", err)
+	} else {
+		if command.Id != "" {
+			ctx = events.WithCorrelationId(ctx, command.Id)
+		}
+		ctx = tenant.WithCollection(ctx, command.Collection)
+		job, err = d.dispatch(ctx, command)
+	}
+
+	return d.Sink.Publish(ctx, events.NewCommandResultEvent(ctx, command, job, err))
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, command models.Command) (*models.Job, error) {
+	switch command.Type {
+	case models.CommandRefreshAll:
+		return d.Processor.RefreshAll(ctx)
+	case models.CommandRefreshSet:
+		if command.GroupId == 0 {
+			return nil, errors.New("refresh_set command requires a groupId")
+		}
+		return d.Processor.RefreshSet(ctx, command.GroupId)
+	case models.CommandRefreshCard:
+		if command.Serial == "" {
+			return nil, errors.New("refresh_card command requires a serial")
+		}
+		return d.Processor.RefreshCard(ctx, command.Serial)
+	case models.CommandImportSerials:
+		if len(command.Serials) == 0 {
+			return nil, errors.New("import_serials command requires serials")
+		}
+		return d.Processor.ImportSerials(ctx, command.Serials)
+	default:
+		return nil, fmt.Errorf("unknown command type '%v'", command.Type)
+	}
+}
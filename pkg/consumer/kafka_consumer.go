@@ -0,0 +1,83 @@
+package consumer
+
+import (
+	"context"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/tracing"
+)
+
+const pollTimeout = 100 * time.Millisecond
+
+// Consumer reads commands from a Kafka topic and passes them to a command handler one at a time. Offsets are committed
+// after a command has been handled, whether or not it succeeded, so that a bad command is not retried forever.
+type Consumer struct {
+	Consumer *kafka.Consumer
+	Handler  CommandHandler
+}
+
+func CreateConsumer(broker string, groupId string, topic string, handler CommandHandler) (*Consumer, error) {
+	return createConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  broker,
+		"group.id":           groupId,
+		"auto.offset.reset":  "earliest",
+		"enable.auto.commit": false,
+	}, topic, handler)
+}
+
+func createConsumer(config *kafka.ConfigMap, topic string, handler CommandHandler) (*Consumer, error) {
+	c, err := kafka.NewConsumer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.SubscribeTopics([]string{topic}, nil); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	return &Consumer{Consumer: c, Handler: handler}, nil
+}
+
+// Run handles commands until ctx is cancelled.
+func (c *Consumer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		message, err := c.Consumer.ReadMessage(pollTimeout)
+		if err != nil {
+			if kafkaErr, ok := err.(kafka.Error); !ok || kafkaErr.Code() != kafka.ErrTimedOut {
+				logging.From(ctx).WithError(err).Warn("Error reading command")
+			}
+			continue
+		}
+
+		// Commands are handled in a span continuing the trace of whoever sent them, if their headers carry one.
+		headers := producer.KafkaHeaders(message.Headers)
+		commandCtx, span := tracing.Start(otel.GetTextMapPropagator().Extract(ctx, &headers), "consume command",
+			trace.WithSpanKind(trace.SpanKindConsumer))
+		err = c.Handler.Handle(commandCtx, message.Value)
+		tracing.End(span, err)
+		if err != nil {
+			logging.From(commandCtx).WithError(err).Error("Error replying to command")
+		}
+
+		if _, err := c.Consumer.CommitMessage(message); err != nil {
+			logging.From(ctx).WithError(err).Error("Error committing command offset")
+		}
+	}
+}
+
+func (c *Consumer) Close() error {
+	return c.Consumer.Close()
+}
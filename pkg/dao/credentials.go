@@ -0,0 +1,81 @@
+package dao
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ygo-card-processor/models"
+)
+
+// encryptedPrefix marks the private keys stored encrypted, telling them apart from those stored before they were.
+const encryptedPrefix = "v1:"
+
+// ErrNoCredentialsKey is returned when storing or reading the private key of a collection without a credentials key.
+var ErrNoCredentialsKey = errors.New("no credentials key is configured to encrypt collection private keys with")
+
+// newCredentialsCipher returns the AES-256-GCM cipher private keys are encrypted with, or nil if no key is configured.
+func newCredentialsCipher(key string) (cipher.AEAD, error) {
+	if key == "" {
+		return nil, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding credentials key: %w", err)
+	}
+	block, err := aes.NewCipher(decoded)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealCredentials returns a copy of collection whose private key is encrypted for storing. The collection ID is
+// authenticated along with it, so a private key copied to another collection cannot be decrypted.
+func (db *MongoClient) sealCredentials(collection models.Collection) (models.Collection, error) {
+	if collection.Tcgplayer == nil || collection.Tcgplayer.PrivateKey == "" {
+		return collection, nil
+	}
+	if db.credentials == nil {
+		return collection, ErrNoCredentialsKey
+	}
+
+	nonce := make([]byte, db.credentials.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return collection, err
+	}
+	sealed := db.credentials.Seal(nonce, nonce, []byte(collection.Tcgplayer.PrivateKey), []byte(collection.Id))
+
+	credentials := *collection.Tcgplayer
+	credentials.PrivateKey = encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+	collection.Tcgplayer = &credentials
+	return collection, nil
+}
+
+// openCredentials decrypts the private key of a stored collection in place. Private keys stored before they were
+// encrypted are left as they are.
+func (db *MongoClient) openCredentials(collection *models.Collection) error {
+	if collection.Tcgplayer == nil || !strings.HasPrefix(collection.Tcgplayer.PrivateKey, encryptedPrefix) {
+		return nil
+	}
+	if db.credentials == nil {
+		return ErrNoCredentialsKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(collection.Tcgplayer.PrivateKey, encryptedPrefix))
+	if err != nil || len(sealed) < db.credentials.NonceSize() {
+		return fmt.Errorf("malformed private key stored for collection %v", collection.Id)
+	}
+	nonceSize := db.credentials.NonceSize()
+	privateKey, err := db.credentials.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(collection.Id))
+	if err != nil {
+		return fmt.Errorf("error decrypting private key of collection %v: %w", collection.Id, err)
+	}
+
+	collection.Tcgplayer.PrivateKey = string(privateKey)
+	return nil
+}
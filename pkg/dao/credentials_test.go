@@ -0,0 +1,63 @@
+package dao
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"ygo-card-processor/models"
+)
+
+func clientWithCredentialsKey(t *testing.T) *MongoClient {
+	credentials, err := newCredentialsCipher(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	require.Nil(t, err)
+	return &MongoClient{credentials: credentials}
+}
+
+func collectionWithPrivateKey(id string) models.Collection {
+	return models.Collection{Id: id, Tcgplayer: &models.TcgplayerCredentials{PublicKey: "public", PrivateKey: "private"}}
+}
+
+func TestMongoClient_SealCredentials_ShouldEncryptPrivateKeyOfCopy(t *testing.T) {
+	db := clientWithCredentialsKey(t)
+	collection := collectionWithPrivateKey("binder")
+
+	sealed, err := db.sealCredentials(collection)
+	require.Nil(t, err)
+	require.Equal(t, "private", collection.Tcgplayer.PrivateKey)
+	require.Equal(t, "public", sealed.Tcgplayer.PublicKey)
+	require.True(t, strings.HasPrefix(sealed.Tcgplayer.PrivateKey, encryptedPrefix))
+	require.NotContains(t, sealed.Tcgplayer.PrivateKey, "private")
+
+	require.Nil(t, db.openCredentials(&sealed))
+	require.Equal(t, "private", sealed.Tcgplayer.PrivateKey)
+}
+
+func TestMongoClient_SealCredentials_ShouldReturnErrorWithoutCredentialsKey(t *testing.T) {
+	db := &MongoClient{}
+
+	_, err := db.sealCredentials(collectionWithPrivateKey("binder"))
+	require.Equal(t, ErrNoCredentialsKey, err)
+
+	collection, err := db.sealCredentials(models.Collection{Id: "binder"})
+	require.Nil(t, err)
+	require.Nil(t, collection.Tcgplayer)
+}
+
+func TestMongoClient_OpenCredentials_ShouldRejectPrivateKeyOfOtherCollection(t *testing.T) {
+	db := clientWithCredentialsKey(t)
+	sealed, err := db.sealCredentials(collectionWithPrivateKey("binder"))
+	require.Nil(t, err)
+
+	sealed.Id = "other"
+	require.NotNil(t, db.openCredentials(&sealed))
+}
+
+func TestMongoClient_OpenCredentials_ShouldKeepPrivateKeyStoredUnencrypted(t *testing.T) {
+	collection := collectionWithPrivateKey("binder")
+
+	require.Nil(t, (&MongoClient{}).openCredentials(&collection))
+	require.Equal(t, "private", collection.Tcgplayer.PrivateKey)
+}
@@ -2,19 +2,52 @@ package dao
 
 import (
 	"context"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"ygo-card-processor/models"
 )
 
+// DbHandler stores cards and jobs in the collection the context is scoped to with tenant.WithCollection, or the default
+// collection if it is not scoped. Sets, schedules, API keys and collections themselves are shared by every collection.
 type DbHandler interface {
 	AddCards(ctx context.Context, cardList []interface{}) (int, error)
 	AddCard(ctx context.Context, card models.CardWithPriceInfo) (interface{}, error)
 	UpdateCardById(ctx context.Context, id primitive.ObjectID, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error)
 	UpdateCardByNumber(ctx context.Context, serial string, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error)
+	UpdateCardPrices(ctx context.Context, serial string, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error)
 	DeleteCard(ctx context.Context, serial string) error
 	GetCards(ctx context.Context, filters map[string]interface{}) ([]models.CardWithPriceInfo, error)
 	GetCardByNumber(ctx context.Context, serial string) (*models.CardWithPriceInfo, error)
+	AddJob(ctx context.Context, job models.Job) (primitive.ObjectID, error)
+	UpdateJob(ctx context.Context, job models.Job) error
+	GetJob(ctx context.Context, id primitive.ObjectID) (*models.Job, error)
+	GetJobsByStatus(ctx context.Context, status string) ([]models.Job, error)
+	UpsertSet(ctx context.Context, set models.Set) error
+	GetSets(ctx context.Context) ([]models.Set, error)
+	GetSet(ctx context.Context, groupId int) (*models.Set, error)
+	GetSchedules(ctx context.Context) ([]models.Schedule, error)
+	GetSchedule(ctx context.Context, name string) (*models.Schedule, error)
+	UpsertSchedule(ctx context.Context, schedule models.Schedule) error
+	AddScheduleIfMissing(ctx context.Context, schedule models.Schedule) error
+	MarkScheduleRun(ctx context.Context, name string, runAt time.Time, jobId string) error
+	ClaimScheduleRun(ctx context.Context, name string, due time.Time, runAt time.Time) (bool, error)
+	DeleteSchedule(ctx context.Context, name string) error
+	GetPendingOutboxEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error)
+	MarkOutboxEventSent(ctx context.Context, id primitive.ObjectID) error
+	AddApiKey(ctx context.Context, key models.ApiKey) (primitive.ObjectID, error)
+	GetApiKeys(ctx context.Context) ([]models.ApiKey, error)
+	GetApiKeyByHash(ctx context.Context, hash string) (*models.ApiKey, error)
+	DeleteApiKey(ctx context.Context, id primitive.ObjectID) error
+	AddCollection(ctx context.Context, collection models.Collection) error
+	GetCollections(ctx context.Context, user string) ([]models.Collection, error)
+	GetCollection(ctx context.Context, id string) (*models.Collection, error)
+	UpdateCollection(ctx context.Context, collection models.Collection) error
+	DeleteCollection(ctx context.Context, id string) error
+	AcquireLock(ctx context.Context, name string, owner string, duration time.Duration) (bool, error)
+	ReleaseLock(ctx context.Context, name string, owner string) error
+	Migrate(ctx context.Context) ([]string, error)
+	DedupeCards(ctx context.Context, dryRun bool) ([]models.DuplicateCards, error)
 	Ping(ctx context.Context) error
 }
@@ -2,9 +2,11 @@ package dao
 
 import (
 	"context"
+	"crypto/cipher"
 	"errors"
+	"fmt"
+	"time"
 
-	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,133 +14,795 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 
 	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/config"
+	"ygo-card-processor/pkg/events"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/tenant"
 )
 
 type MongoClient struct {
 	Client     *mongo.Client
 	Database   string
 	Collection string
+	// credentials encrypts the private keys of collections, if a credentials key is configured.
+	credentials cipher.AEAD
+}
+
+const (
+	jobCollection      = "jobs"
+	setCollection      = "sets"
+	outboxCollection   = "outbox"
+	scheduleCollection = "schedules"
+	apiKeyCollection   = "apiKeys"
+	lockCollection     = "locks"
+	// cardCollectionCollection stores the collections cards belong to. It is not to be confused with the Mongo collection
+	// the cards themselves are stored in.
+	cardCollectionCollection = "collections"
+)
+
+// duplicateKeyCode is the code of the error MongoDB returns when an insert would duplicate a unique key.
+const duplicateKeyCode = 11000
+
+// ErrCollectionExists is returned when adding a collection with the ID of an existing one.
+var ErrCollectionExists = errors.New("a collection with this ID already exists")
+
+// ErrCollectionBusy is returned when deleting a collection with running or paused jobs, which would carry on writing
+// cards to it.
+var ErrCollectionBusy = errors.New("the collection has running or paused jobs")
+
+// ErrNoTransactions is returned by Connect when MongoDB runs as a standalone server, which does not support the
+// transactions cards are written in together with their outbox events.
+var ErrNoTransactions = errors.New("transactions require MongoDB to run as a replica set or sharded cluster")
+
+// topology is the part of the reply to the isMaster command telling what kind of deployment MongoDB runs as.
+type topology struct {
+	SetName string `bson:"setName"`
+	Msg     string `bson:"msg"`
+}
+
+// supportsTransactions reports whether the deployment is a replica set or a sharded cluster, whose mongos routers reply
+// with msg "isdbgrid".
+func (t topology) supportsTransactions() bool {
+	return t.SetName != "" || t.Msg == "isdbgrid"
+}
+
+// Connect connects to the MongoDB deployment, database and collection given in the configuration.
+func Connect(ctx context.Context, config config.MongoConfig) (*MongoClient, error) {
+	credentials, err := newCredentialsCipher(config.CredentialsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(config.Uri))
+	if err != nil {
+		return nil, err
+	}
+
+	// A standalone server is refused here, rather than failing every card written later.
+	var deployment topology
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&deployment); err != nil {
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("error checking MongoDB deployment: %w", err)
+	}
+	if !deployment.supportsTransactions() {
+		client.Disconnect(ctx)
+		return nil, ErrNoTransactions
+	}
+
+	return &MongoClient{
+		Client:      client,
+		Database:    config.Database,
+		Collection:  config.Collection,
+		credentials: credentials,
+	}, nil
 }
 
 func (db *MongoClient) getCollection() *mongo.Collection {
 	return db.Client.Database(db.Database).Collection(db.Collection)
 }
 
+func (db *MongoClient) getJobCollection() *mongo.Collection {
+	return db.Client.Database(db.Database).Collection(jobCollection)
+}
+
+func (db *MongoClient) getSetCollection() *mongo.Collection {
+	return db.Client.Database(db.Database).Collection(setCollection)
+}
+
+func (db *MongoClient) getOutboxCollection() *mongo.Collection {
+	return db.Client.Database(db.Database).Collection(outboxCollection)
+}
+
+func (db *MongoClient) getScheduleCollection() *mongo.Collection {
+	return db.Client.Database(db.Database).Collection(scheduleCollection)
+}
+
+func (db *MongoClient) getLockCollection() *mongo.Collection {
+	return db.Client.Database(db.Database).Collection(lockCollection)
+}
+
+func (db *MongoClient) getApiKeyCollection() *mongo.Collection {
+	return db.Client.Database(db.Database).Collection(apiKeyCollection)
+}
+
+func (db *MongoClient) getCardCollectionCollection() *mongo.Collection {
+	return db.Client.Database(db.Database).Collection(cardCollectionCollection)
+}
+
+// serialFilter matches the card with the given serial number in the collection ctx is scoped to.
+func serialFilter(ctx context.Context, serial string) bson.D {
+	return bson.D{
+		{Key: "collectionId", Value: tenant.CollectionId(ctx)},
+		{Key: "card.extendedData", Value: bson.D{
+			{Key: "$elemMatch", Value: bson.D{
+				{Key: "value", Value: serial},
+			}},
+		}},
+	}
+}
+
+// CardQuery builds the query GetCards uses to select the cards matching a filter.
+func CardQuery(filter models.CardFilter) map[string]interface{} {
+	query := bson.M{}
+
+	// Serial number and rarity are both extended data entries, so each needs its own element match.
+	extendedData := make([]bson.M, 0)
+	if filter.Serial != "" {
+		extendedData = append(extendedData, bson.M{"value": filter.Serial})
+	}
+	if len(filter.Serials) > 0 {
+		extendedData = append(extendedData, bson.M{"value": bson.M{"$in": filter.Serials}})
+	}
+	if filter.Rarity != "" {
+		extendedData = append(extendedData, bson.M{"name": "Rarity", "value": filter.Rarity})
+	}
+	if len(extendedData) == 1 {
+		query["card.extendedData"] = bson.M{"$elemMatch": extendedData[0]}
+	} else if len(extendedData) > 1 {
+		matches := make([]bson.M, 0)
+		for _, match := range extendedData {
+			matches = append(matches, bson.M{"$elemMatch": match})
+		}
+		query["card.extendedData"] = bson.M{"$all": matches}
+	}
+
+	if filter.GroupId != 0 {
+		query["card.groupId"] = filter.GroupId
+	}
+	if filter.MinMarketPrice > 0 {
+		query["priceInfo"] = bson.M{"$elemMatch": bson.M{"marketPrice": bson.M{"$gte": filter.MinMarketPrice}}}
+	}
+	if !filter.AddedAfter.IsZero() {
+		query["addedAt"] = bson.M{"$gt": filter.AddedAfter}
+	}
+	if !filter.PricedBefore.IsZero() {
+		// Cards added before prices were timestamped have no pricedAt and count as never refreshed.
+		query["$or"] = bson.A{
+			bson.M{"pricedAt": bson.M{"$lt": filter.PricedBefore}},
+			bson.M{"pricedAt": bson.M{"$exists": false}},
+		}
+	}
+	return query
+}
+
 func (db *MongoClient) AddCards(ctx context.Context, cardList []interface{}) (int, error) {
-	result, err := db.getCollection().InsertMany(ctx, cardList)
+	collectionId := tenant.CollectionId(ctx)
+	for i, card := range cardList {
+		switch c := card.(type) {
+		case models.CardWithPriceInfo:
+			c.CollectionId = collectionId
+			cardList[i] = c
+		case *models.CardWithPriceInfo:
+			c.CollectionId = collectionId
+		}
+	}
+
+	result, err := db.withOutbox(ctx, func(sc mongo.SessionContext) (interface{}, []models.OutboxEvent, error) {
+		result, err := db.getCollection().InsertMany(sc, cardList)
+		if err != nil {
+			return 0, nil, err
+		} else if len(result.InsertedIDs) == 0 {
+			return 0, nil, errors.New("no cards inserted")
+		}
+
+		outboxEvents := make([]models.OutboxEvent, 0)
+		for _, card := range cardList {
+			switch c := card.(type) {
+			case models.CardWithPriceInfo:
+				outboxEvents = append(outboxEvents, newOutboxEvent(events.NewCardEvent(ctx, models.EventCardAdded, c)))
+			case *models.CardWithPriceInfo:
+				outboxEvents = append(outboxEvents, newOutboxEvent(events.NewCardEvent(ctx, models.EventCardAdded, *c)))
+			}
+		}
+		return len(result.InsertedIDs), outboxEvents, nil
+	})
 	if err != nil {
 		return 0, err
-	} else if len(result.InsertedIDs) == 0 {
-		return 0, errors.New("no cards inserted")
 	}
-	return len(result.InsertedIDs), nil
+	return result.(int), nil
 }
 
 func (db *MongoClient) AddCard(ctx context.Context, card models.CardWithPriceInfo) (interface{}, error) {
-	result, err := db.getCollection().InsertOne(ctx, card)
+	if card.AddedAt.IsZero() {
+		card.AddedAt = time.Now()
+	}
+	card.CollectionId = tenant.CollectionId(ctx)
+
+	result, err := db.withOutbox(ctx, func(sc mongo.SessionContext) (interface{}, []models.OutboxEvent, error) {
+		result, err := db.getCollection().InsertOne(sc, card)
+		if err != nil {
+			return nil, nil, err
+		}
+		return result.InsertedID, []models.OutboxEvent{newOutboxEvent(events.NewCardEvent(ctx, models.EventCardAdded, card))}, nil
+	})
 	if err != nil {
 		return 0, err
 	}
-	return result.InsertedID, nil
+	return result, nil
 }
 
 func (db *MongoClient) UpdateCardById(ctx context.Context, id primitive.ObjectID, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error) {
-	after := options.After
-	result := db.getCollection().FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": card}, &options.FindOneAndUpdateOptions{ReturnDocument: &after})
+	card.CollectionId = tenant.CollectionId(ctx)
+	return db.updateCard(ctx, bson.M{"_id": id, "collectionId": card.CollectionId}, card)
+}
+
+func (db *MongoClient) UpdateCardByNumber(ctx context.Context, serial string, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error) {
+	card.CollectionId = tenant.CollectionId(ctx)
+	return db.updateCard(ctx, serialFilter(ctx, serial), card)
+}
+
+// UpdateCardPrices updates only the price fields of the card with the given serial number, leaving its catalog data and
+// ownership details untouched.
+func (db *MongoClient) UpdateCardPrices(ctx context.Context, serial string, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error) {
+	return db.updateCard(ctx, serialFilter(ctx, serial), bson.M{
+		"priceInfo":    card.PriceInfo,
+		"skuPriceInfo": card.SkuPriceInfo,
+		"pricedAt":     card.PricedAt,
+		"priceSource":  card.PriceSource,
+		"noMarketData": card.NoMarketData,
+		"priceHistory": card.PriceHistory,
+	})
+}
+
+// updateCard sets the fields of update on the card matching filter, recording a card_updated event and, if its market
+// prices changed, a price_changed event.
+func (db *MongoClient) updateCard(ctx context.Context, filter interface{}, update interface{}) (*models.CardWithPriceInfo, error) {
+	result, err := db.withOutbox(ctx, func(sc mongo.SessionContext) (interface{}, []models.OutboxEvent, error) {
+		before := options.Before
+		result := db.getCollection().FindOneAndUpdate(sc, filter, bson.M{"$set": update}, &options.FindOneAndUpdateOptions{ReturnDocument: &before})
+		if result.Err() != nil {
+			return nil, nil, result.Err()
+		}
+
+		raw, err := result.DecodeBytes()
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error decoding response")
+			return nil, nil, err
+		}
+		var oldCard models.CardWithPriceInfo
+		if err := bson.Unmarshal(raw, &oldCard); err != nil {
+			logging.From(ctx).WithError(err).Error("Error decoding response")
+			return nil, nil, err
+		}
+
+		// The update may change what filter matches, so the updated card is read back by its ID.
+		var updatedCard models.CardWithPriceInfo
+		if err := db.getCollection().FindOne(sc, bson.M{"_id": raw.Lookup("_id")}).Decode(&updatedCard); err != nil {
+			logging.From(ctx).WithError(err).Error("Error decoding response")
+			return nil, nil, err
+		}
+
+		outboxEvents := []models.OutboxEvent{newOutboxEvent(events.NewCardEvent(ctx, models.EventCardUpdated, updatedCard))}
+		if marketPricesChanged(oldCard, updatedCard) {
+			outboxEvents = append(outboxEvents, newOutboxEvent(events.NewPriceChangedEvent(ctx, oldCard, updatedCard)))
+		}
+		return &updatedCard, outboxEvents, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*models.CardWithPriceInfo), nil
+}
+
+func (db *MongoClient) DeleteCard(ctx context.Context, serial string) error {
+	_, err := db.withOutbox(ctx, func(sc mongo.SessionContext) (interface{}, []models.OutboxEvent, error) {
+		result := db.getCollection().FindOneAndDelete(sc, serialFilter(ctx, serial))
+		if result.Err() == mongo.ErrNoDocuments {
+			return nil, nil, errors.New("no cards were deleted")
+		} else if result.Err() != nil {
+			return nil, nil, result.Err()
+		}
+
+		var deletedCard models.CardWithPriceInfo
+		if err := result.Decode(&deletedCard); err != nil {
+			return nil, nil, err
+		}
+		return nil, []models.OutboxEvent{newOutboxEvent(events.NewCardEvent(ctx, models.EventCardDeleted, deletedCard))}, nil
+	})
+	return err
+}
+
+// GetCards returns the cards of the collection ctx is scoped to that match filters.
+func (db *MongoClient) GetCards(ctx context.Context, filters map[string]interface{}) ([]models.CardWithPriceInfo, error) {
+	query := bson.M{}
+	for key, value := range filters {
+		query[key] = value
+	}
+	query["collectionId"] = tenant.CollectionId(ctx)
+
+	cursor, err := db.getCollection().Find(ctx, query)
+	if err != nil {
+		return []models.CardWithPriceInfo{}, err
+	}
+
+	var results []models.CardWithPriceInfo
+	if err := cursor.All(ctx, &results); err != nil {
+		return []models.CardWithPriceInfo{}, err
+	}
+	return results, nil
+}
+
+func (db *MongoClient) GetCardByNumber(ctx context.Context, serial string) (*models.CardWithPriceInfo, error) {
+	cursor, err := db.getCollection().Find(ctx, serialFilter(ctx, serial))
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []models.CardWithPriceInfo
+	if err := cursor.All(ctx, &cards); err != nil {
+		return nil, err
+	}
+
+	if len(cards) == 0 {
+		return nil, errors.New("no card found with given serial")
+	}
+
+	card := cards[0]
+	return &card, nil
+}
+
+func (db *MongoClient) AddJob(ctx context.Context, job models.Job) (primitive.ObjectID, error) {
+	job.CollectionId = tenant.CollectionId(ctx)
+	result, err := db.getJobCollection().InsertOne(ctx, job)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return primitive.NilObjectID, errors.New("inserted job ID is not an object ID")
+	}
+	return id, nil
+}
+
+func (db *MongoClient) UpdateJob(ctx context.Context, job models.Job) error {
+	result, err := db.getJobCollection().ReplaceOne(ctx, bson.M{"_id": job.Id}, job)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("no job found with given ID")
+	}
+	return nil
+}
+
+// GetJob returns the job with the given ID if it belongs to the collection ctx is scoped to.
+func (db *MongoClient) GetJob(ctx context.Context, id primitive.ObjectID) (*models.Job, error) {
+	result := db.getJobCollection().FindOne(ctx, bson.M{"_id": id, "collectionId": tenant.CollectionId(ctx)})
 	if result.Err() != nil {
 		return nil, result.Err()
 	}
 
-	var updatedCard models.CardWithPriceInfo
-	if err := result.Decode(&updatedCard); err != nil {
+	var job models.Job
+	if err := result.Decode(&job); err != nil {
 		return nil, err
 	}
 
-	return &updatedCard, nil
+	return &job, nil
 }
 
-func (db *MongoClient) UpdateCardByNumber(ctx context.Context, serial string, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error) {
-	after := options.After
-	result := db.getCollection().FindOneAndUpdate(
-		ctx,
-		bson.D{
-			{"card.extendedData", bson.D{
-				{"$elemMatch", bson.D{
-					{"value", serial},
-				}},
-			}},
-		},
-		bson.M{"$set": card},
-		&options.FindOneAndUpdateOptions{ReturnDocument: &after},
-	)
+// GetJobsByStatus returns the jobs with the given status in every collection.
+func (db *MongoClient) GetJobsByStatus(ctx context.Context, status string) ([]models.Job, error) {
+	cursor, err := db.getJobCollection().Find(ctx, bson.M{"status": status})
+	if err != nil {
+		return []models.Job{}, err
+	}
+
+	var results []models.Job
+	if err := cursor.All(ctx, &results); err != nil {
+		return []models.Job{}, err
+	}
+	return results, nil
+}
+
+func (db *MongoClient) UpsertSet(ctx context.Context, set models.Set) error {
+	upsert := true
+	_, err := db.getSetCollection().ReplaceOne(ctx, bson.M{"groupId": set.GroupId}, set, &options.ReplaceOptions{Upsert: &upsert})
+	return err
+}
+
+func (db *MongoClient) GetSets(ctx context.Context) ([]models.Set, error) {
+	cursor, err := db.getSetCollection().Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"products": 0}))
+	if err != nil {
+		return []models.Set{}, err
+	}
+
+	var results []models.Set
+	if err := cursor.All(ctx, &results); err != nil {
+		return []models.Set{}, err
+	}
+	return results, nil
+}
+
+func (db *MongoClient) GetSet(ctx context.Context, groupId int) (*models.Set, error) {
+	result := db.getSetCollection().FindOne(ctx, bson.M{"groupId": groupId})
 	if result.Err() != nil {
 		return nil, result.Err()
 	}
 
-	var updatedCard models.CardWithPriceInfo
-	if err := result.Decode(&updatedCard); err != nil {
-		logrus.WithError(err).Error("Error decoding response")
+	var set models.Set
+	if err := result.Decode(&set); err != nil {
 		return nil, err
 	}
 
-	return &updatedCard, nil
+	return &set, nil
 }
 
-func (db *MongoClient) DeleteCard(ctx context.Context, serial string) error {
-	result, err := db.getCollection().DeleteOne(
-		ctx,
-		bson.D{
-			{"card.extendedData", bson.D{
-				{"$elemMatch", bson.D{
-					{"value", serial},
-				}},
-			}},
-		})
+func (db *MongoClient) GetSchedules(ctx context.Context) ([]models.Schedule, error) {
+	cursor, err := db.getScheduleCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return []models.Schedule{}, err
+	}
+
+	var results []models.Schedule
+	if err := cursor.All(ctx, &results); err != nil {
+		return []models.Schedule{}, err
+	}
+	return results, nil
+}
+
+func (db *MongoClient) GetSchedule(ctx context.Context, name string) (*models.Schedule, error) {
+	result := db.getScheduleCollection().FindOne(ctx, bson.M{"name": name})
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var schedule models.Schedule
+	if err := result.Decode(&schedule); err != nil {
+		return nil, err
+	}
+
+	return &schedule, nil
+}
+
+func (db *MongoClient) UpsertSchedule(ctx context.Context, schedule models.Schedule) error {
+	upsert := true
+	_, err := db.getScheduleCollection().ReplaceOne(ctx, bson.M{"name": schedule.Name}, schedule, &options.ReplaceOptions{Upsert: &upsert})
+	return err
+}
+
+// AddScheduleIfMissing adds a schedule unless one with the same name exists, so that configured schedules do not
+// overwrite edits made through the API.
+func (db *MongoClient) AddScheduleIfMissing(ctx context.Context, schedule models.Schedule) error {
+	upsert := true
+	_, err := db.getScheduleCollection().UpdateOne(ctx, bson.M{"name": schedule.Name}, bson.M{"$setOnInsert": schedule}, &options.UpdateOptions{Upsert: &upsert})
+	return err
+}
+
+func (db *MongoClient) MarkScheduleRun(ctx context.Context, name string, runAt time.Time, jobId string) error {
+	result, err := db.getScheduleCollection().UpdateOne(ctx, bson.M{"name": name}, bson.M{"$set": bson.M{"lastRunAt": runAt, "lastJobId": jobId}})
 	if err != nil {
 		return err
 	}
 
-	if result.DeletedCount == 0 {
-		return errors.New("no cards were deleted")
+	if result.MatchedCount == 0 {
+		return errors.New("no schedule found with given name")
+	}
+	return nil
+}
+
+// ClaimScheduleRun records a run of the named schedule at runAt if it last ran before due, and reports whether it did.
+// Every replica checks the schedules, so the one whose claim succeeds is the only one running the schedule.
+func (db *MongoClient) ClaimScheduleRun(ctx context.Context, name string, due time.Time, runAt time.Time) (bool, error) {
+	result, err := db.getScheduleCollection().UpdateOne(ctx,
+		bson.M{"name": name, "$or": bson.A{bson.M{"lastRunAt": bson.M{"$lt": due}}, bson.M{"lastRunAt": nil}}},
+		bson.M{"$set": bson.M{"lastRunAt": runAt}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (db *MongoClient) DeleteSchedule(ctx context.Context, name string) error {
+	result, err := db.getScheduleCollection().DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
 	}
 
+	if result.DeletedCount == 0 {
+		return errors.New("no schedule found with given name")
+	}
 	return nil
 }
 
-func (db *MongoClient) GetCards(ctx context.Context, filters map[string]interface{}) ([]models.CardWithPriceInfo, error) {
-	cursor, err := db.getCollection().Find(ctx, filters)
+func (db *MongoClient) GetPendingOutboxEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	cursor, err := db.getOutboxCollection().Find(
+		ctx,
+		bson.M{"sent": false},
+		options.Find().SetSort(bson.M{"createdAt": 1}).SetLimit(int64(limit)),
+	)
 	if err != nil {
-		return []models.CardWithPriceInfo{}, err
+		return []models.OutboxEvent{}, err
 	}
 
-	var results []models.CardWithPriceInfo
+	var results []models.OutboxEvent
 	if err := cursor.All(ctx, &results); err != nil {
-		return []models.CardWithPriceInfo{}, err
+		return []models.OutboxEvent{}, err
 	}
 	return results, nil
 }
 
-func (db *MongoClient) GetCardByNumber(ctx context.Context, serial string) (*models.CardWithPriceInfo, error) {
-	cursor, err := db.getCollection().Find(
-		ctx,
-		bson.D{
-			{"card.extendedData", bson.D{
-				{"$elemMatch", bson.D{
-					{"value", serial},
-				}},
-			}},
-		})
+func (db *MongoClient) MarkOutboxEventSent(ctx context.Context, id primitive.ObjectID) error {
+	result, err := db.getOutboxCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"sent": true, "sentAt": time.Now()}})
 	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("no outbox event found with given ID")
+	}
+	return nil
+}
+
+func (db *MongoClient) AddApiKey(ctx context.Context, key models.ApiKey) (primitive.ObjectID, error) {
+	result, err := db.getApiKeyCollection().InsertOne(ctx, key)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return primitive.NilObjectID, errors.New("inserted API key ID is not an object ID")
+	}
+	return id, nil
+}
+
+func (db *MongoClient) GetApiKeys(ctx context.Context) ([]models.ApiKey, error) {
+	cursor, err := db.getApiKeyCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return []models.ApiKey{}, err
+	}
+
+	var results []models.ApiKey
+	if err := cursor.All(ctx, &results); err != nil {
+		return []models.ApiKey{}, err
+	}
+	return results, nil
+}
+
+func (db *MongoClient) GetApiKeyByHash(ctx context.Context, hash string) (*models.ApiKey, error) {
+	result := db.getApiKeyCollection().FindOne(ctx, bson.M{"hash": hash})
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var key models.ApiKey
+	if err := result.Decode(&key); err != nil {
 		return nil, err
 	}
 
-	var cards []models.CardWithPriceInfo
-	if err := cursor.All(ctx, &cards); err != nil {
+	return &key, nil
+}
+
+func (db *MongoClient) DeleteApiKey(ctx context.Context, id primitive.ObjectID) error {
+	result, err := db.getApiKeyCollection().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return errors.New("no API key found with given ID")
+	}
+	return nil
+}
+
+// AddCollection adds a collection, or returns ErrCollectionExists if one with the same ID exists. Its private key is
+// stored encrypted.
+func (db *MongoClient) AddCollection(ctx context.Context, collection models.Collection) error {
+	collection, err := db.sealCredentials(collection)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.getCardCollectionCollection().InsertOne(ctx, collection)
+
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if writeError.Code == duplicateKeyCode {
+				return ErrCollectionExists
+			}
+		}
+	}
+	return err
+}
+
+// GetCollections returns the collections owned by or shared with the given user, or every collection if user is empty.
+func (db *MongoClient) GetCollections(ctx context.Context, user string) ([]models.Collection, error) {
+	filter := bson.M{}
+	if user != "" {
+		filter["$or"] = bson.A{bson.M{"owner": user}, bson.M{"sharedWith": user}}
+	}
+
+	cursor, err := db.getCardCollectionCollection().Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return []models.Collection{}, err
+	}
+
+	var results []models.Collection
+	if err := cursor.All(ctx, &results); err != nil {
+		return []models.Collection{}, err
+	}
+	for i := range results {
+		if err := db.openCredentials(&results[i]); err != nil {
+			return []models.Collection{}, err
+		}
+	}
+	return results, nil
+}
+
+func (db *MongoClient) GetCollection(ctx context.Context, id string) (*models.Collection, error) {
+	result := db.getCardCollectionCollection().FindOne(ctx, bson.M{"_id": id})
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var collection models.Collection
+	if err := result.Decode(&collection); err != nil {
+		return nil, err
+	}
+	if err := db.openCredentials(&collection); err != nil {
 		return nil, err
 	}
 
-	card := cards[0]
-	return &card, nil
+	return &collection, nil
+}
+
+func (db *MongoClient) UpdateCollection(ctx context.Context, collection models.Collection) error {
+	collection, err := db.sealCredentials(collection)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.getCardCollectionCollection().ReplaceOne(ctx, bson.M{"_id": collection.Id}, collection)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("no collection found with given ID")
+	}
+	return nil
+}
+
+// DeleteCollection deletes a collection together with its cards, jobs and schedules, in one transaction so that none of
+// them is left behind. No card events are recorded for them. Collections with running or paused jobs are kept, and
+// ErrCollectionBusy returned.
+func (db *MongoClient) DeleteCollection(ctx context.Context, id string) error {
+	_, err := db.withOutbox(ctx, func(sc mongo.SessionContext) (interface{}, []models.OutboxEvent, error) {
+		active, err := db.getJobCollection().CountDocuments(sc, bson.M{
+			"collectionId": id,
+			"status":       bson.M{"$in": bson.A{models.JobStatusRunning, models.JobStatusPaused}},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if active > 0 {
+			return nil, nil, ErrCollectionBusy
+		}
+
+		result, err := db.getCardCollectionCollection().DeleteOne(sc, bson.M{"_id": id})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if result.DeletedCount == 0 {
+			return nil, nil, errors.New("no collection found with given ID")
+		}
+
+		if _, err := db.getCollection().DeleteMany(sc, bson.M{"collectionId": id}); err != nil {
+			return nil, nil, err
+		}
+		if _, err := db.getJobCollection().DeleteMany(sc, bson.M{"collectionId": id}); err != nil {
+			return nil, nil, err
+		}
+		_, err = db.getScheduleCollection().DeleteMany(sc, bson.M{"collection": id})
+		return nil, nil, err
+	})
+	return err
+}
+
+// AcquireLock takes the named lock for owner until duration has passed, or extends it if owner already holds it, and
+// reports whether it did. A lock that has expired is free, so the lock of a process that stopped is not held forever.
+func (db *MongoClient) AcquireLock(ctx context.Context, name string, owner string, duration time.Duration) (bool, error) {
+	now := time.Now()
+	upsert := true
+	_, err := db.getLockCollection().UpdateOne(ctx,
+		bson.M{"_id": name, "$or": bson.A{bson.M{"owner": owner}, bson.M{"expiresAt": bson.M{"$lt": now}}}},
+		bson.M{"$set": bson.M{"owner": owner, "expiresAt": now.Add(duration)}},
+		&options.UpdateOptions{Upsert: &upsert})
+
+	// A lock held by someone else does not match, so the upsert tries to insert a second lock with its name.
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if writeError.Code == duplicateKeyCode {
+				return false, nil
+			}
+		}
+	}
+	return err == nil, err
+}
+
+// ReleaseLock frees the named lock if owner holds it.
+func (db *MongoClient) ReleaseLock(ctx context.Context, name string, owner string) error {
+	_, err := db.getLockCollection().DeleteOne(ctx, bson.M{"_id": name, "owner": owner})
+	return err
+}
+
+// withOutbox runs fn in a transaction together with inserting the outbox events it returns, so that a card mutation
+// and its events are either both written or neither is. Transactions require MongoDB to run as a replica set, which
+// Connect checks.
+func (db *MongoClient) withOutbox(ctx context.Context, fn func(sc mongo.SessionContext) (interface{}, []models.OutboxEvent, error)) (interface{}, error) {
+	var result interface{}
+	err := db.Client.UseSession(ctx, func(sc mongo.SessionContext) error {
+		var err error
+		result, err = sc.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+			result, outboxEvents, err := fn(sc)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(outboxEvents) > 0 {
+				documents := make([]interface{}, len(outboxEvents))
+				for i := range outboxEvents {
+					documents[i] = outboxEvents[i]
+				}
+				if _, err := db.getOutboxCollection().InsertMany(sc, documents); err != nil {
+					return nil, err
+				}
+			}
+			return result, nil
+		})
+		return err
+	})
+	return result, err
+}
+
+func newOutboxEvent(event models.DomainEvent) models.OutboxEvent {
+	return models.OutboxEvent{
+		Event:     event,
+		CreatedAt: time.Now(),
+	}
+}
+
+// marketPricesChanged reports whether the market price of any printing differs between two versions of a card.
+func marketPricesChanged(oldCard models.CardWithPriceInfo, newCard models.CardWithPriceInfo) bool {
+	if len(oldCard.PriceInfo) != len(newCard.PriceInfo) {
+		return true
+	}
+
+	oldPrices := make(map[string]float64)
+	for _, price := range oldCard.PriceInfo {
+		oldPrices[price.SubTypeName] = price.MarketPrice
+	}
+	for _, price := range newCard.PriceInfo {
+		if oldPrice, ok := oldPrices[price.SubTypeName]; !ok || oldPrice != price.MarketPrice {
+			return true
+		}
+	}
+	return false
 }
 
 func (db *MongoClient) Ping(ctx context.Context) error {
@@ -0,0 +1,13 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopology_SupportsTransactions_ShouldOnlyRejectStandaloneServer(t *testing.T) {
+	require.False(t, topology{}.supportsTransactions())
+	require.True(t, topology{SetName: "rs0"}.supportsTransactions())
+	require.True(t, topology{Msg: "isdbgrid"}.supportsTransactions())
+}
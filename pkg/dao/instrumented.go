@@ -0,0 +1,312 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/metrics"
+	"ygo-card-processor/pkg/tracing"
+)
+
+// instrumentedHandler records each operation of the handler it wraps as a span and in the metrics.
+type instrumentedHandler struct {
+	handler DbHandler
+}
+
+// Instrument returns a DbHandler recording each operation of handler as a span, and its duration and outcome as
+// metrics.
+func Instrument(handler DbHandler) DbHandler {
+	return &instrumentedHandler{handler: handler}
+}
+
+// observe starts recording an operation, returning the context to perform it in and the function to call with its
+// outcome. Documents that are not found are an expected outcome, so they are told apart from errors.
+func observe(ctx context.Context, method string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "mongo "+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.operation", method),
+	))
+	return ctx, func(err error) {
+		outcome := metrics.Outcome(err)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			outcome = "not_found"
+			err = nil
+		}
+		metrics.MongoOperationDuration.WithLabelValues(method, outcome).Observe(metrics.Since(start))
+		tracing.End(span, err)
+	}
+}
+
+func (h *instrumentedHandler) AddCards(ctx context.Context, cardList []interface{}) (int, error) {
+	ctx, done := observe(ctx, "AddCards")
+	result, err := h.handler.AddCards(ctx, cardList)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) AddCard(ctx context.Context, card models.CardWithPriceInfo) (interface{}, error) {
+	ctx, done := observe(ctx, "AddCard")
+	result, err := h.handler.AddCard(ctx, card)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpdateCardById(ctx context.Context, id primitive.ObjectID, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error) {
+	ctx, done := observe(ctx, "UpdateCardById")
+	result, err := h.handler.UpdateCardById(ctx, id, card)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpdateCardByNumber(ctx context.Context, serial string, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error) {
+	ctx, done := observe(ctx, "UpdateCardByNumber")
+	result, err := h.handler.UpdateCardByNumber(ctx, serial, card)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpdateCardPrices(ctx context.Context, serial string, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error) {
+	ctx, done := observe(ctx, "UpdateCardPrices")
+	result, err := h.handler.UpdateCardPrices(ctx, serial, card)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) DeleteCard(ctx context.Context, serial string) error {
+	ctx, done := observe(ctx, "DeleteCard")
+	err := h.handler.DeleteCard(ctx, serial)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) GetCards(ctx context.Context, filters map[string]interface{}) ([]models.CardWithPriceInfo, error) {
+	ctx, done := observe(ctx, "GetCards")
+	result, err := h.handler.GetCards(ctx, filters)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) GetCardByNumber(ctx context.Context, serial string) (*models.CardWithPriceInfo, error) {
+	ctx, done := observe(ctx, "GetCardByNumber")
+	result, err := h.handler.GetCardByNumber(ctx, serial)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) AddJob(ctx context.Context, job models.Job) (primitive.ObjectID, error) {
+	ctx, done := observe(ctx, "AddJob")
+	result, err := h.handler.AddJob(ctx, job)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpdateJob(ctx context.Context, job models.Job) error {
+	ctx, done := observe(ctx, "UpdateJob")
+	err := h.handler.UpdateJob(ctx, job)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) GetJob(ctx context.Context, id primitive.ObjectID) (*models.Job, error) {
+	ctx, done := observe(ctx, "GetJob")
+	result, err := h.handler.GetJob(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) GetJobsByStatus(ctx context.Context, status string) ([]models.Job, error) {
+	ctx, done := observe(ctx, "GetJobsByStatus")
+	result, err := h.handler.GetJobsByStatus(ctx, status)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpsertSet(ctx context.Context, set models.Set) error {
+	ctx, done := observe(ctx, "UpsertSet")
+	err := h.handler.UpsertSet(ctx, set)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) GetSets(ctx context.Context) ([]models.Set, error) {
+	ctx, done := observe(ctx, "GetSets")
+	result, err := h.handler.GetSets(ctx)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) GetSet(ctx context.Context, groupId int) (*models.Set, error) {
+	ctx, done := observe(ctx, "GetSet")
+	result, err := h.handler.GetSet(ctx, groupId)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) GetSchedules(ctx context.Context) ([]models.Schedule, error) {
+	ctx, done := observe(ctx, "GetSchedules")
+	result, err := h.handler.GetSchedules(ctx)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) GetSchedule(ctx context.Context, name string) (*models.Schedule, error) {
+	ctx, done := observe(ctx, "GetSchedule")
+	result, err := h.handler.GetSchedule(ctx, name)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpsertSchedule(ctx context.Context, schedule models.Schedule) error {
+	ctx, done := observe(ctx, "UpsertSchedule")
+	err := h.handler.UpsertSchedule(ctx, schedule)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) AddScheduleIfMissing(ctx context.Context, schedule models.Schedule) error {
+	ctx, done := observe(ctx, "AddScheduleIfMissing")
+	err := h.handler.AddScheduleIfMissing(ctx, schedule)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) MarkScheduleRun(ctx context.Context, name string, runAt time.Time, jobId string) error {
+	ctx, done := observe(ctx, "MarkScheduleRun")
+	err := h.handler.MarkScheduleRun(ctx, name, runAt, jobId)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) DeleteSchedule(ctx context.Context, name string) error {
+	ctx, done := observe(ctx, "DeleteSchedule")
+	err := h.handler.DeleteSchedule(ctx, name)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) GetPendingOutboxEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	ctx, done := observe(ctx, "GetPendingOutboxEvents")
+	result, err := h.handler.GetPendingOutboxEvents(ctx, limit)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) MarkOutboxEventSent(ctx context.Context, id primitive.ObjectID) error {
+	ctx, done := observe(ctx, "MarkOutboxEventSent")
+	err := h.handler.MarkOutboxEventSent(ctx, id)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) AddApiKey(ctx context.Context, key models.ApiKey) (primitive.ObjectID, error) {
+	ctx, done := observe(ctx, "AddApiKey")
+	result, err := h.handler.AddApiKey(ctx, key)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) GetApiKeys(ctx context.Context) ([]models.ApiKey, error) {
+	ctx, done := observe(ctx, "GetApiKeys")
+	result, err := h.handler.GetApiKeys(ctx)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) GetApiKeyByHash(ctx context.Context, hash string) (*models.ApiKey, error) {
+	ctx, done := observe(ctx, "GetApiKeyByHash")
+	result, err := h.handler.GetApiKeyByHash(ctx, hash)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) DeleteApiKey(ctx context.Context, id primitive.ObjectID) error {
+	ctx, done := observe(ctx, "DeleteApiKey")
+	err := h.handler.DeleteApiKey(ctx, id)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) AddCollection(ctx context.Context, collection models.Collection) error {
+	ctx, done := observe(ctx, "AddCollection")
+	err := h.handler.AddCollection(ctx, collection)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) GetCollections(ctx context.Context, user string) ([]models.Collection, error) {
+	ctx, done := observe(ctx, "GetCollections")
+	result, err := h.handler.GetCollections(ctx, user)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) GetCollection(ctx context.Context, id string) (*models.Collection, error) {
+	ctx, done := observe(ctx, "GetCollection")
+	result, err := h.handler.GetCollection(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) UpdateCollection(ctx context.Context, collection models.Collection) error {
+	ctx, done := observe(ctx, "UpdateCollection")
+	err := h.handler.UpdateCollection(ctx, collection)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) DeleteCollection(ctx context.Context, id string) error {
+	ctx, done := observe(ctx, "DeleteCollection")
+	err := h.handler.DeleteCollection(ctx, id)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) ClaimScheduleRun(ctx context.Context, name string, due time.Time, runAt time.Time) (bool, error) {
+	ctx, done := observe(ctx, "ClaimScheduleRun")
+	result, err := h.handler.ClaimScheduleRun(ctx, name, due, runAt)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) AcquireLock(ctx context.Context, name string, owner string, duration time.Duration) (bool, error) {
+	ctx, done := observe(ctx, "AcquireLock")
+	result, err := h.handler.AcquireLock(ctx, name, owner, duration)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) ReleaseLock(ctx context.Context, name string, owner string) error {
+	ctx, done := observe(ctx, "ReleaseLock")
+	err := h.handler.ReleaseLock(ctx, name, owner)
+	done(err)
+	return err
+}
+
+func (h *instrumentedHandler) Migrate(ctx context.Context) ([]string, error) {
+	ctx, done := observe(ctx, "Migrate")
+	result, err := h.handler.Migrate(ctx)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) DedupeCards(ctx context.Context, dryRun bool) ([]models.DuplicateCards, error) {
+	ctx, done := observe(ctx, "DedupeCards")
+	result, err := h.handler.DedupeCards(ctx, dryRun)
+	done(err)
+	return result, err
+}
+
+func (h *instrumentedHandler) Ping(ctx context.Context) error {
+	ctx, done := observe(ctx, "Ping")
+	err := h.handler.Ping(ctx)
+	done(err)
+	return err
+}
@@ -0,0 +1,193 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/tenant"
+)
+
+const migrationCollection = "migrations"
+
+// migration changes the indexes or stored data of a database once. Migrations are applied in order, and each must be
+// safe to apply again, in case it is interrupted before being recorded.
+type migration struct {
+	name  string
+	apply func(ctx context.Context, db *MongoClient) error
+}
+
+var migrations = []migration{
+	{name: "001-card-indexes", apply: func(ctx context.Context, db *MongoClient) error {
+		_, err := db.getCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "card.extendedData.value", Value: 1}}},
+			{Keys: bson.D{{Key: "card.groupId", Value: 1}}},
+			{Keys: bson.D{{Key: "pricedAt", Value: 1}}},
+			{Keys: bson.D{{Key: "addedAt", Value: 1}}},
+		})
+		return err
+	}},
+	{name: "002-job-status-index", apply: func(ctx context.Context, db *MongoClient) error {
+		_, err := db.getJobCollection().Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}}})
+		return err
+	}},
+	{name: "003-outbox-pending-index", apply: func(ctx context.Context, db *MongoClient) error {
+		_, err := db.getOutboxCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "sent", Value: 1}, {Key: "createdAt", Value: 1}},
+		})
+		return err
+	}},
+	{name: "004-unique-set-and-schedule-keys", apply: func(ctx context.Context, db *MongoClient) error {
+		unique := options.Index().SetUnique(true)
+		if _, err := db.getSetCollection().Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "groupId", Value: 1}}, Options: unique}); err != nil {
+			return err
+		}
+		_, err := db.getScheduleCollection().Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "name", Value: 1}}, Options: unique})
+		return err
+	}},
+	{name: "005-backfill-added-at", apply: func(ctx context.Context, db *MongoClient) error {
+		// Cards stored before addedAt was recorded were added when their ObjectID was generated. Requires MongoDB 4.2.
+		_, err := db.getCollection().UpdateMany(ctx, bson.M{"addedAt": bson.M{"$exists": false}}, mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{"addedAt": bson.M{"$toDate": "$_id"}}}},
+		})
+		return err
+	}},
+	{name: "006-api-key-hash-index", apply: func(ctx context.Context, db *MongoClient) error {
+		_, err := db.getApiKeyCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		return err
+	}},
+	{name: "007-card-collections", apply: func(ctx context.Context, db *MongoClient) error {
+		// Cards and jobs stored before collections existed belong to the default collection.
+		missing := bson.M{"collectionId": bson.M{"$exists": false}}
+		assign := bson.M{"$set": bson.M{"collectionId": models.DefaultCollectionId}}
+		if _, err := db.getCollection().UpdateMany(ctx, missing, assign); err != nil {
+			return err
+		}
+		if _, err := db.getJobCollection().UpdateMany(ctx, missing, assign); err != nil {
+			return err
+		}
+		if _, err := db.getCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "collectionId", Value: 1}, {Key: "card.extendedData.value", Value: 1}},
+		}); err != nil {
+			return err
+		}
+		_, err := db.getCardCollectionCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "owner", Value: 1}}},
+			{Keys: bson.D{{Key: "sharedWith", Value: 1}}},
+		})
+		return err
+	}},
+	{name: "008-encrypt-collection-credentials", apply: func(ctx context.Context, db *MongoClient) error {
+		// Private keys stored before they were encrypted are encrypted now, which needs a credentials key if there are any.
+		cursor, err := db.getCardCollectionCollection().Find(ctx, bson.M{"tcgplayer.privateKey": bson.M{
+			"$nin": bson.A{nil, ""},
+			"$not": primitive.Regex{Pattern: "^" + encryptedPrefix},
+		}})
+		if err != nil {
+			return err
+		}
+
+		var collections []models.Collection
+		if err := cursor.All(ctx, &collections); err != nil {
+			return err
+		}
+		for _, collection := range collections {
+			sealed, err := db.sealCredentials(collection)
+			if err != nil {
+				return err
+			}
+			if _, err := db.getCardCollectionCollection().UpdateOne(ctx, bson.M{"_id": collection.Id}, bson.M{
+				"$set": bson.M{"tcgplayer.privateKey": sealed.Tcgplayer.PrivateKey},
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+}
+
+func (db *MongoClient) getMigrationCollection() *mongo.Collection {
+	return db.Client.Database(db.Database).Collection(migrationCollection)
+}
+
+// Migrate applies the migrations not yet applied to the database and returns their names.
+func (db *MongoClient) Migrate(ctx context.Context) ([]string, error) {
+	applied := make([]string, 0)
+	for _, m := range migrations {
+		count, err := db.getMigrationCollection().CountDocuments(ctx, bson.M{"_id": m.name})
+		if err != nil {
+			return applied, err
+		} else if count > 0 {
+			continue
+		}
+
+		if err := m.apply(ctx, db); err != nil {
+			return applied, fmt.Errorf("error applying migration %v: %w", m.name, err)
+		}
+		if _, err := db.getMigrationCollection().InsertOne(ctx, bson.M{"_id": m.name, "appliedAt": time.Now()}); err != nil {
+			return applied, err
+		}
+		applied = append(applied, m.name)
+	}
+	return applied, nil
+}
+
+// DedupeCards finds the serial numbers stored more than once in the collection ctx is scoped to, and removes all but
+// the most recently priced card of each, unless dryRun is set. No card events are recorded, since a card with the
+// serial number is still stored.
+func (db *MongoClient) DedupeCards(ctx context.Context, dryRun bool) ([]models.DuplicateCards, error) {
+	cursor, err := db.getCollection().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"collectionId":              tenant.CollectionId(ctx),
+			"card.extendedData.0.value": bson.M{"$nin": bson.A{nil, ""}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "pricedAt", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"$arrayElemAt": bson.A{"$card.extendedData.value", 0}},
+			"ids": bson.M{"$push": "$_id"},
+		}}},
+		{{Key: "$match", Value: bson.M{"ids.1": bson.M{"$exists": true}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Serial string               `bson:"_id"`
+		Ids    []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	duplicates := make([]models.DuplicateCards, 0)
+	for _, group := range groups {
+		// Grouping keeps the sort order, so the first card is the most recently priced one.
+		removed := make([]string, 0)
+		for _, id := range group.Ids[1:] {
+			removed = append(removed, id.Hex())
+		}
+
+		if !dryRun {
+			if _, err := db.getCollection().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": group.Ids[1:]}}); err != nil {
+				return duplicates, err
+			}
+		}
+		duplicates = append(duplicates, models.DuplicateCards{
+			Serial:  group.Serial,
+			Kept:    group.Ids[0].Hex(),
+			Removed: removed,
+		})
+	}
+	return duplicates, nil
+}
@@ -0,0 +1,71 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"ygo-card-processor/models"
+)
+
+const (
+	FormatJSON        = "json"
+	FormatCloudEvents = "cloudevents"
+
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsTypePrefix  = "com.ygo-card-processor."
+)
+
+// CloudEvent is the structured mode JSON representation of a domain event as described by the CloudEvents 1.0
+// specification. The domain event itself is carried in Data.
+type CloudEvent struct {
+	SpecVersion     string             `json:"specversion"`
+	Id              string             `json:"id"`
+	Source          string             `json:"source"`
+	Type            string             `json:"type"`
+	Subject         string             `json:"subject,omitempty"`
+	Time            time.Time          `json:"time"`
+	DataContentType string             `json:"datacontenttype"`
+	DataSchema      string             `json:"dataschema"`
+	Data            models.DomainEvent `json:"data"`
+}
+
+// ParseFormat validates an event format name, defaulting to plain JSON when none is given.
+func ParseFormat(value string) (string, error) {
+	switch format := strings.ToLower(strings.TrimSpace(value)); format {
+	case "":
+		return FormatJSON, nil
+	case FormatJSON, FormatCloudEvents:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown event format '%v'", value)
+	}
+}
+
+// Encode serialises a domain event in the given format.
+func Encode(format string, event models.DomainEvent) ([]byte, error) {
+	if format == FormatCloudEvents {
+		return json.Marshal(ToCloudEvent(event))
+	}
+	return json.Marshal(event)
+}
+
+func ToCloudEvent(event models.DomainEvent) CloudEvent {
+	cloudEvent := CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		Id:              event.Id,
+		Source:          event.Source,
+		Type:            cloudEventsTypePrefix + event.Type,
+		Time:            event.Time,
+		DataContentType: "application/json",
+		DataSchema:      fmt.Sprintf("urn:%v:event:v%v", Source, event.SchemaVersion),
+		Data:            event,
+	}
+	if event.Card != nil {
+		cloudEvent.Subject = event.Card.Serial
+	} else if event.Job != nil {
+		cloudEvent.Subject = event.Job.Id
+	}
+	return cloudEvent
+}
@@ -0,0 +1,137 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ygo-card-processor/models"
+)
+
+const (
+	// SchemaVersion is increased whenever a field of models.DomainEvent changes meaning or is removed, so that
+	// consumers can tell which layout they are reading.
+	SchemaVersion = 1
+	Source        = "ygo-card-processor"
+)
+
+type contextKey int
+
+const (
+	correlationIdKey contextKey = iota
+	jobIdKey
+)
+
+// WithCorrelationId returns a context whose events carry the given correlation ID.
+func WithCorrelationId(ctx context.Context, correlationId string) context.Context {
+	return context.WithValue(ctx, correlationIdKey, correlationId)
+}
+
+// WithJobId returns a context whose events carry the given job ID. The job ID also becomes the correlation ID unless
+// one is already set.
+func WithJobId(ctx context.Context, jobId string) context.Context {
+	if CorrelationId(ctx) == "" {
+		ctx = WithCorrelationId(ctx, jobId)
+	}
+	return context.WithValue(ctx, jobIdKey, jobId)
+}
+
+func CorrelationId(ctx context.Context) string {
+	correlationId, _ := ctx.Value(correlationIdKey).(string)
+	return correlationId
+}
+
+func JobId(ctx context.Context) string {
+	jobId, _ := ctx.Value(jobIdKey).(string)
+	return jobId
+}
+
+func NewCardEvent(ctx context.Context, eventType string, card models.CardWithPriceInfo) models.DomainEvent {
+	event := newEvent(ctx, eventType)
+	event.Card = cardRef(card)
+	return event
+}
+
+func NewPriceChangedEvent(ctx context.Context, oldCard models.CardWithPriceInfo, newCard models.CardWithPriceInfo) models.DomainEvent {
+	event := newEvent(ctx, models.EventPriceChanged)
+	event.Card = cardRef(newCard)
+	event.Prices = &models.PriceChange{
+		Old: printingPrices(oldCard),
+		New: printingPrices(newCard),
+	}
+	return event
+}
+
+func NewJobStartedEvent(ctx context.Context, job models.Job) models.DomainEvent {
+	return newJobEvent(ctx, models.EventJobStarted, job)
+}
+
+func NewJobFinishedEvent(ctx context.Context, job models.Job) models.DomainEvent {
+	return newJobEvent(ctx, models.EventJobFinished, job)
+}
+
+// NewCommandResultEvent replies to a command, with the job it started if it was accepted or the reason it was rejected.
+func NewCommandResultEvent(ctx context.Context, command models.Command, job *models.Job, err error) models.DomainEvent {
+	if err != nil {
+		event := newEvent(ctx, models.EventCommandRejected)
+		event.Command = &models.CommandRef{Id: command.Id, Type: command.Type, Error: err.Error()}
+		return event
+	}
+
+	event := newJobEvent(ctx, models.EventCommandAccepted, *job)
+	event.Command = &models.CommandRef{Id: command.Id, Type: command.Type}
+	return event
+}
+
+func newJobEvent(ctx context.Context, eventType string, job models.Job) models.DomainEvent {
+	event := newEvent(WithJobId(ctx, job.Id.Hex()), eventType)
+	event.Job = &models.JobSummary{
+		Id:             job.Id.Hex(),
+		Type:           job.Type,
+		Status:         job.Status,
+		Total:          job.Total,
+		Processed:      job.Processed,
+		Failed:         job.Failed,
+		CatalogChanged: job.CatalogChanged,
+		PriceOnly:      job.PriceOnly,
+		Ambiguous:      len(job.Ambiguous),
+		NotFound:       len(job.NotFound),
+		StartedAt:      job.StartedAt,
+		FinishedAt:     job.FinishedAt,
+	}
+	return event
+}
+
+func newEvent(ctx context.Context, eventType string) models.DomainEvent {
+	return models.DomainEvent{
+		Id:            primitive.NewObjectID().Hex(),
+		Type:          eventType,
+		SchemaVersion: SchemaVersion,
+		Source:        Source,
+		Time:          time.Now().UTC(),
+		CorrelationId: CorrelationId(ctx),
+		JobId:         JobId(ctx),
+	}
+}
+
+func cardRef(card models.CardWithPriceInfo) *models.CardRef {
+	return &models.CardRef{
+		Serial:    card.CardInfo.Serial(),
+		ProductId: card.CardInfo.ProductId,
+		GroupId:   card.CardInfo.GroupId,
+		Name:      card.CardInfo.Name,
+	}
+}
+
+func printingPrices(card models.CardWithPriceInfo) []models.PrintingPrice {
+	prices := make([]models.PrintingPrice, 0)
+	for _, price := range card.PriceInfo {
+		prices = append(prices, models.PrintingPrice{
+			Printing:    price.SubTypeName,
+			LowPrice:    price.LowPrice,
+			MarketPrice: price.MarketPrice,
+		})
+	}
+	return prices
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ygo-card-processor/models"
+)
+
+func TestEvents_NewCardEvent_ShouldCarryCardAndContextIds(t *testing.T) {
+	ctx := WithJobId(context.Background(), "job")
+	card := models.CardWithPriceInfo{CardInfo: models.Card{
+		ProductId:    123,
+		GroupId:      456,
+		Name:         "Dark Magician",
+		ExtendedData: []models.ExtendedData{{Value: "LOB-005"}},
+	}}
+
+	event := NewCardEvent(ctx, models.EventCardAdded, card)
+	require.NotEmpty(t, event.Id)
+	require.Equal(t, models.EventCardAdded, event.Type)
+	require.Equal(t, SchemaVersion, event.SchemaVersion)
+	require.Equal(t, "job", event.JobId)
+	require.Equal(t, "job", event.CorrelationId)
+	require.Equal(t, &models.CardRef{Serial: "LOB-005", ProductId: 123, GroupId: 456, Name: "Dark Magician"}, event.Card)
+}
+
+func TestEvents_WithJobId_ShouldKeepExistingCorrelationId(t *testing.T) {
+	ctx := WithJobId(WithCorrelationId(context.Background(), "request"), "job")
+	require.Equal(t, "request", CorrelationId(ctx))
+	require.Equal(t, "job", JobId(ctx))
+}
+
+func TestEvents_NewPriceChangedEvent_ShouldCarryOldAndNewPrices(t *testing.T) {
+	oldCard := models.CardWithPriceInfo{PriceInfo: []models.PriceResults{{SubTypeName: "1st Edition", MarketPrice: 1.5}}}
+	newCard := models.CardWithPriceInfo{PriceInfo: []models.PriceResults{{SubTypeName: "1st Edition", MarketPrice: 2.5}}}
+
+	event := NewPriceChangedEvent(context.Background(), oldCard, newCard)
+	require.Equal(t, models.EventPriceChanged, event.Type)
+	require.Equal(t, 1.5, event.Prices.Old[0].MarketPrice)
+	require.Equal(t, 2.5, event.Prices.New[0].MarketPrice)
+}
+
+func TestEvents_NewJobFinishedEvent_ShouldCarryJobCounts(t *testing.T) {
+	job := models.Job{
+		Id:        primitive.NewObjectID(),
+		Type:      models.JobTypeImport,
+		Status:    models.JobStatusFinished,
+		Total:     4,
+		Processed: 2,
+		Failed:    1,
+		NotFound:  []models.AmbiguousSerial{{Serial: "test"}},
+	}
+
+	event := NewJobFinishedEvent(context.Background(), job)
+	require.Equal(t, job.Id.Hex(), event.JobId)
+	require.Equal(t, 2, event.Job.Processed)
+	require.Equal(t, 1, event.Job.Failed)
+	require.Equal(t, 1, event.Job.NotFound)
+	require.Equal(t, 0, event.Job.Ambiguous)
+}
+
+func TestEvents_Encode_ShouldWrapEventInCloudEvent(t *testing.T) {
+	event := NewCardEvent(context.Background(), models.EventCardDeleted, models.CardWithPriceInfo{
+		CardInfo: models.Card{ExtendedData: []models.ExtendedData{{Value: "LOB-005"}}},
+	})
+
+	bytes, err := Encode(FormatCloudEvents, event)
+	require.Nil(t, err)
+
+	var cloudEvent map[string]interface{}
+	require.Nil(t, json.Unmarshal(bytes, &cloudEvent))
+	require.Equal(t, "1.0", cloudEvent["specversion"])
+	require.Equal(t, "com.ygo-card-processor.card_deleted", cloudEvent["type"])
+	require.Equal(t, "LOB-005", cloudEvent["subject"])
+	require.Equal(t, "urn:ygo-card-processor:event:v1", cloudEvent["dataschema"])
+}
+
+func TestEvents_ParseFormat_ShouldDefaultToJson(t *testing.T) {
+	format, err := ParseFormat("")
+	require.Nil(t, err)
+	require.Equal(t, FormatJSON, format)
+
+	_, err = ParseFormat("xml")
+	require.NotNil(t, err)
+}
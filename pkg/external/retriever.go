@@ -9,6 +9,14 @@ import (
 type ExtRetriever interface {
 	RefreshToken(ctx context.Context, publicKey string, privateKey string) error
 	BasicCardSearch(ctx context.Context, serial string) (*models.SearchResponse, error)
+	CatalogSearch(ctx context.Context, filters []models.CardSearchFilter, offset int, limit int) (*models.SearchResponse, error)
 	ExtendedCardSearch(ctx context.Context, productId int) (*models.ExtendedSearchResponse, error)
+	ExtendedCardSearchMultiple(ctx context.Context, productIds []int) (*models.ExtendedSearchResponse, error)
 	GetCardPricingInfo(ctx context.Context, productId int) (*models.PriceResponse, error)
+	GetCardPricingInfoMultiple(ctx context.Context, productIds []int) (*models.PriceResponse, error)
+	GetProductSkus(ctx context.Context, productId int) (*models.SkuResponse, error)
+	GetSkuPricingInfo(ctx context.Context, skuIds []int) (*models.SkuPriceResponse, error)
+	GetGroups(ctx context.Context, offset int, limit int) (*models.GroupResponse, error)
+	GetGroupProducts(ctx context.Context, groupId int, offset int, limit int) (*models.ExtendedSearchResponse, error)
+	GetGroupPricingInfo(ctx context.Context, groupId int) (*models.PriceResponse, error)
 }
@@ -6,20 +6,45 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
-
-	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
 
 	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/config"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/metrics"
+	"ygo-card-processor/pkg/tenant"
 )
 
 type Retriever struct {
-	Url    string
-	Client http.Client
-	Token  string
+	Url        string
+	ApiVersion string
+	Client     http.Client
+
+	// tokens holds the access token of each collection, since collections may use their own credentials.
+	tokensMutex sync.Mutex
+	tokens      map[string]string
+
+	attributesMutex sync.Mutex
+	conditions      map[int]string
+	printings       map[int]string
+	languages       map[int]string
+}
+
+// NewRetriever creates a retriever for the tcgplayer.com API at the URL and version given in the configuration.
+func NewRetriever(config config.TcgplayerConfig) *Retriever {
+	return &Retriever{
+		Url:        config.Url,
+		ApiVersion: config.ApiVersion,
+		Client:     http.Client{Timeout: config.Timeout},
+	}
 }
 
+// RefreshToken retrieves an access token with the given credentials, used for the requests of the collection ctx is
+// scoped to.
 func (r *Retriever) RefreshToken(ctx context.Context, publicKey string, privateKey string) error {
 	body := fmt.Sprintf(
 		"grant_type=client_credentials&client_id=%v&client_secret=%v",
@@ -27,24 +52,22 @@ func (r *Retriever) RefreshToken(ctx context.Context, publicKey string, privateK
 
 	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%v/token", r.Url), strings.NewReader(body))
 	if err != nil {
-		logrus.WithError(err).Error("Error creating request")
+		logging.From(ctx).WithError(err).Error("Error creating request")
 		return err
 	}
 	req = req.WithContext(ctx)
 
-	response, err := r.Client.Do(req)
-	if err != nil {
-		logrus.WithError(err).Error("Error performing request")
-		return err
-	}
-
 	var tokenResponse models.TokenResponse
-	if err := json.NewDecoder(response.Body).Decode(&tokenResponse); err != nil {
-		logrus.WithError(err).Error("Error decoding response body")
+	if err := r.send(req, "token", &tokenResponse); err != nil {
 		return err
 	}
 
-	r.Token = tokenResponse.AccessToken
+	r.tokensMutex.Lock()
+	defer r.tokensMutex.Unlock()
+	if r.tokens == nil {
+		r.tokens = make(map[string]string)
+	}
+	r.tokens[tenant.CollectionId(ctx)] = tokenResponse.AccessToken
 	return nil
 }
 
@@ -53,123 +76,296 @@ func (r *Retriever) BasicCardSearch(ctx context.Context, serial string) (*models
 		Name:   "Number",
 		Values: []string{serial},
 	}
+	return r.CatalogSearch(ctx, []models.CardSearchFilter{filter}, 0, 0)
+}
+
+func (r *Retriever) CatalogSearch(ctx context.Context, filters []models.CardSearchFilter, offset int, limit int) (*models.SearchResponse, error) {
 	body := models.CardSearchBody{
-		Filters: []models.CardSearchFilter{filter},
+		Filters: filters,
+		Offset:  offset,
+		Limit:   limit,
 	}
 
 	bodyJson, err := json.Marshal(body)
 	if err != nil {
-		logrus.WithError(err).Error("Error marshalling JSON")
+		logging.From(ctx).WithError(err).Error("Error marshalling JSON")
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%v/v1.37.0/catalog/categories/2/search", r.Url), bytes.NewBuffer(bodyJson))
-	if err != nil {
-		logrus.WithError(err).Error("Error creating request")
+	var searchResponse models.SearchResponse
+	url := fmt.Sprintf("%v/%v/catalog/categories/2/search", r.Url, r.ApiVersion)
+	if err := r.doRequest(ctx, "catalog_search", http.MethodPost, url, bytes.NewBuffer(bodyJson), &searchResponse); err != nil {
 		return nil, err
 	}
-	req = req.WithContext(ctx)
 
-	if err := r.addHeaders(req); err != nil {
-		logrus.WithError(err).Error("Error adding auth token to request")
+	if len(searchResponse.Errors) > 0 {
+		err := errors.New(searchResponse.Errors[0])
+		logging.From(ctx).WithError(err).Error("Error in response")
 		return nil, err
 	}
 
-	response, err := r.Client.Do(req)
-	if err != nil {
-		logrus.WithError(err).Error("Error performing request")
+	return &searchResponse, nil
+}
+
+func (r *Retriever) ExtendedCardSearch(ctx context.Context, productId int) (*models.ExtendedSearchResponse, error) {
+	return r.ExtendedCardSearchMultiple(ctx, []int{productId})
+}
+
+func (r *Retriever) ExtendedCardSearchMultiple(ctx context.Context, productIds []int) (*models.ExtendedSearchResponse, error) {
+	var searchResponse models.ExtendedSearchResponse
+	url := fmt.Sprintf("%v/%v/catalog/products/%v?getExtendedFields=true", r.Url, r.ApiVersion, joinIds(productIds))
+	if err := r.doRequest(ctx, "products", http.MethodGet, url, nil, &searchResponse); err != nil {
 		return nil, err
 	}
 
-	var searchResponse models.SearchResponse
-	if err := json.NewDecoder(response.Body).Decode(&searchResponse); err != nil {
-		logrus.WithError(err).Error("Error decoding response body")
+	if len(searchResponse.Errors) > 0 {
+		err := errors.New(searchResponse.Errors[0])
+		logging.From(ctx).WithError(err).Error("Error in response")
+		return nil, err
+	}
+
+	return &searchResponse, nil
+}
+
+func (r *Retriever) GetCardPricingInfo(ctx context.Context, productId int) (*models.PriceResponse, error) {
+	return r.GetCardPricingInfoMultiple(ctx, []int{productId})
+}
+
+func (r *Retriever) GetCardPricingInfoMultiple(ctx context.Context, productIds []int) (*models.PriceResponse, error) {
+	var searchResponse models.PriceResponse
+	url := fmt.Sprintf("%v/%v/pricing/product/%v", r.Url, r.ApiVersion, joinIds(productIds))
+	if err := r.doRequest(ctx, "product_pricing", http.MethodGet, url, nil, &searchResponse); err != nil {
 		return nil, err
 	}
 
 	if len(searchResponse.Errors) > 0 {
 		err := errors.New(searchResponse.Errors[0])
-		logrus.WithError(err).Error("Error in response")
+		logging.From(ctx).WithError(err).Error("Error in response")
 		return nil, err
 	}
 
 	return &searchResponse, nil
 }
 
-func (r *Retriever) ExtendedCardSearch(ctx context.Context, productId int) (*models.ExtendedSearchResponse, error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%v/v1.37.0/catalog/products/%v?getExtendedFields=true", r.Url, productId), nil)
-	if err != nil {
-		logrus.WithError(err).Error("Error creating request")
+// GetProductSkus returns the SKUs of a product, with the names of their condition, printing and language resolved.
+func (r *Retriever) GetProductSkus(ctx context.Context, productId int) (*models.SkuResponse, error) {
+	if err := r.loadSkuAttributes(ctx); err != nil {
 		return nil, err
 	}
-	req = req.WithContext(ctx)
 
-	if err := r.addHeaders(req); err != nil {
-		logrus.WithError(err).Error("Error adding auth token to request")
+	var skuResponse models.SkuResponse
+	url := fmt.Sprintf("%v/%v/catalog/products/%v/skus", r.Url, r.ApiVersion, productId)
+	if err := r.doRequest(ctx, "skus", http.MethodGet, url, nil, &skuResponse); err != nil {
 		return nil, err
 	}
 
-	response, err := r.Client.Do(req)
-	if err != nil {
-		logrus.WithError(err).Error("Error performing request")
+	if len(skuResponse.Errors) > 0 {
+		err := errors.New(skuResponse.Errors[0])
+		logging.From(ctx).WithError(err).Error("Error in response")
 		return nil, err
 	}
 
-	var searchResponse models.ExtendedSearchResponse
-	if err := json.NewDecoder(response.Body).Decode(&searchResponse); err != nil {
-		logrus.WithError(err).Error("Error decoding response body")
+	r.attributesMutex.Lock()
+	defer r.attributesMutex.Unlock()
+	for i := range skuResponse.Results {
+		skuResponse.Results[i].Condition = r.conditions[skuResponse.Results[i].ConditionId]
+		skuResponse.Results[i].Printing = r.printings[skuResponse.Results[i].PrintingId]
+		skuResponse.Results[i].Language = r.languages[skuResponse.Results[i].LanguageId]
+	}
+
+	return &skuResponse, nil
+}
+
+func (r *Retriever) GetSkuPricingInfo(ctx context.Context, skuIds []int) (*models.SkuPriceResponse, error) {
+	var priceResponse models.SkuPriceResponse
+	url := fmt.Sprintf("%v/%v/pricing/sku/%v", r.Url, r.ApiVersion, joinIds(skuIds))
+	if err := r.doRequest(ctx, "sku_pricing", http.MethodGet, url, nil, &priceResponse); err != nil {
 		return nil, err
 	}
 
-	if len(searchResponse.Errors) > 0 {
-		err := errors.New(searchResponse.Errors[0])
-		logrus.WithError(err).Error("Error in response")
+	if len(priceResponse.Errors) > 0 {
+		err := errors.New(priceResponse.Errors[0])
+		logging.From(ctx).WithError(err).Error("Error in response")
 		return nil, err
 	}
 
-	return &searchResponse, nil
+	return &priceResponse, nil
 }
 
-func (r *Retriever) GetCardPricingInfo(ctx context.Context, productId int) (*models.PriceResponse, error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%v/v1.37.0/pricing/product/%v", r.Url, productId), nil)
-	if err != nil {
-		logrus.WithError(err).Error("Error creating request")
+// loadSkuAttributes retrieves the condition, printing and language names SKUs refer to by ID. They rarely change, so
+// they are only retrieved once.
+func (r *Retriever) loadSkuAttributes(ctx context.Context) error {
+	r.attributesMutex.Lock()
+	defer r.attributesMutex.Unlock()
+
+	if r.conditions != nil && r.printings != nil && r.languages != nil {
+		return nil
+	}
+
+	var conditionResponse models.ConditionResponse
+	if err := r.doRequest(ctx, "conditions", http.MethodGet, fmt.Sprintf("%v/%v/catalog/categories/2/conditions", r.Url, r.ApiVersion), nil, &conditionResponse); err != nil {
+		return err
+	}
+	var printingResponse models.PrintingResponse
+	if err := r.doRequest(ctx, "printings", http.MethodGet, fmt.Sprintf("%v/%v/catalog/categories/2/printings", r.Url, r.ApiVersion), nil, &printingResponse); err != nil {
+		return err
+	}
+	var languageResponse models.LanguageResponse
+	if err := r.doRequest(ctx, "languages", http.MethodGet, fmt.Sprintf("%v/%v/catalog/categories/2/languages", r.Url, r.ApiVersion), nil, &languageResponse); err != nil {
+		return err
+	}
+
+	for _, errs := range [][]string{conditionResponse.Errors, printingResponse.Errors, languageResponse.Errors} {
+		if len(errs) > 0 {
+			err := errors.New(errs[0])
+			logging.From(ctx).WithError(err).Error("Error in response")
+			return err
+		}
+	}
+
+	r.conditions = make(map[int]string)
+	for _, condition := range conditionResponse.Results {
+		r.conditions[condition.ConditionId] = condition.Name
+	}
+	r.printings = make(map[int]string)
+	for _, printing := range printingResponse.Results {
+		r.printings[printing.PrintingId] = printing.Name
+	}
+	r.languages = make(map[int]string)
+	for _, language := range languageResponse.Results {
+		r.languages[language.LanguageId] = language.Name
+	}
+
+	return nil
+}
+
+func (r *Retriever) GetGroups(ctx context.Context, offset int, limit int) (*models.GroupResponse, error) {
+	var groupResponse models.GroupResponse
+	url := fmt.Sprintf("%v/%v/catalog/categories/2/groups?offset=%v&limit=%v", r.Url, r.ApiVersion, offset, limit)
+	if err := r.doRequest(ctx, "groups", http.MethodGet, url, nil, &groupResponse); err != nil {
 		return nil, err
 	}
-	req = req.WithContext(ctx)
 
-	if err := r.addHeaders(req); err != nil {
-		logrus.WithError(err).Error("Error adding auth token to request")
+	if len(groupResponse.Errors) > 0 {
+		err := errors.New(groupResponse.Errors[0])
+		logging.From(ctx).WithError(err).Error("Error in response")
 		return nil, err
 	}
 
-	response, err := r.Client.Do(req)
-	if err != nil {
-		logrus.WithError(err).Error("Error performing request")
+	return &groupResponse, nil
+}
+
+func (r *Retriever) GetGroupProducts(ctx context.Context, groupId int, offset int, limit int) (*models.ExtendedSearchResponse, error) {
+	var searchResponse models.ExtendedSearchResponse
+	url := fmt.Sprintf(
+		"%v/%v/catalog/products?categoryId=2&groupId=%v&productTypes=Cards&getExtendedFields=true&offset=%v&limit=%v",
+		r.Url, r.ApiVersion, groupId, offset, limit)
+	if err := r.doRequest(ctx, "group_products", http.MethodGet, url, nil, &searchResponse); err != nil {
+		return nil, err
+	}
+
+	if len(searchResponse.Errors) > 0 {
+		err := errors.New(searchResponse.Errors[0])
+		logging.From(ctx).WithError(err).Error("Error in response")
 		return nil, err
 	}
 
+	return &searchResponse, nil
+}
+
+func (r *Retriever) GetGroupPricingInfo(ctx context.Context, groupId int) (*models.PriceResponse, error) {
 	var searchResponse models.PriceResponse
-	if err := json.NewDecoder(response.Body).Decode(&searchResponse); err != nil {
-		logrus.WithError(err).Error("Error decoding response body")
+	url := fmt.Sprintf("%v/%v/pricing/group/%v", r.Url, r.ApiVersion, groupId)
+	if err := r.doRequest(ctx, "group_pricing", http.MethodGet, url, nil, &searchResponse); err != nil {
 		return nil, err
 	}
 
 	if len(searchResponse.Errors) > 0 {
 		err := errors.New(searchResponse.Errors[0])
-		logrus.WithError(err).Error("Error in response")
+		logging.From(ctx).WithError(err).Error("Error in response")
 		return nil, err
 	}
 
 	return &searchResponse, nil
 }
 
+// doRequest performs an authorized request against the TCGplayer API and decodes the JSON response into response. The
+// request is recorded in the metrics of the given endpoint.
+func (r *Retriever) doRequest(ctx context.Context, endpoint string, method string, url string, body io.Reader, response interface{}) error {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		logging.From(ctx).WithError(err).Error("Error creating request")
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	if err := r.addHeaders(req); err != nil {
+		logging.From(ctx).WithError(err).Error("Error adding auth token to request")
+		return err
+	}
+
+	return r.send(req, endpoint, response)
+}
+
+// send performs a request and decodes the JSON response into response. Requests that fail, and responses with an error
+// status, are counted as errors of the endpoint and returned as errors, whatever body the response has.
+func (r *Retriever) send(req *http.Request, endpoint string, response interface{}) error {
+	start := time.Now()
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		observeRequest(endpoint, start, err)
+		logging.From(req.Context()).WithError(err).Error("Error performing request")
+		return err
+	}
+	defer closeResponseBody(resp)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		err := fmt.Errorf("tcgplayer.com responded with status %v", resp.StatusCode)
+		observeRequest(endpoint, start, err)
+		logging.From(req.Context()).WithError(err).Error("Error response from tcgplayer.com")
+		return err
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(response)
+	observeRequest(endpoint, start, err)
+	if err != nil {
+		logging.From(req.Context()).WithError(err).Error("Error decoding response body")
+		return err
+	}
+
+	return nil
+}
+
+func observeRequest(endpoint string, start time.Time, err error) {
+	metrics.TcgplayerRequestDuration.WithLabelValues(endpoint).Observe(metrics.Since(start))
+	metrics.TcgplayerRequests.WithLabelValues(endpoint, metrics.Outcome(err)).Inc()
+}
+
 func (r *Retriever) addHeaders(req *http.Request) error {
-	if r.Token == "" {
+	r.tokensMutex.Lock()
+	token := r.tokens[tenant.CollectionId(req.Context())]
+	r.tokensMutex.Unlock()
+
+	if token == "" {
 		return errors.New("retriever token is empty")
 	}
-	authToken := fmt.Sprintf("bearer %v", r.Token)
+	authToken := fmt.Sprintf("bearer %v", token)
 	req.Header.Add("Authorization", authToken)
 	req.Header.Add("Content-Type", "application/json")
 	return nil
 }
+
+// joinIds formats IDs as the comma separated list the TCGplayer API accepts for fetching several entities at once.
+func joinIds(ids []int) string {
+	idStrings := make([]string, len(ids))
+	for i, id := range ids {
+		idStrings[i] = fmt.Sprint(id)
+	}
+	return strings.Join(idStrings, ",")
+}
+
+func closeResponseBody(resp *http.Response) {
+	if err := resp.Body.Close(); err != nil {
+		logging.From(resp.Request.Context()).WithError(err).Error("Error closing response body")
+	}
+}
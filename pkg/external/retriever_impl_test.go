@@ -0,0 +1,48 @@
+package external
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"ygo-card-processor/pkg/metrics"
+)
+
+func TestRetriever_RefreshToken_ShouldReturnErrorIfCredentialsRefused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "invalid_client"}`))
+	}))
+	defer server.Close()
+	retriever := &Retriever{Url: server.URL}
+
+	err := retriever.RefreshToken(context.Background(), "public", "wrong")
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "401")
+}
+
+func TestRetriever_CatalogSearch_ShouldReturnErrorIfRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Write([]byte(`{"access_token": "token"}`))
+			return
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"results": []}`))
+	}))
+	defer server.Close()
+	retriever := &Retriever{Url: server.URL, ApiVersion: "v1.37.0"}
+	require.Nil(t, retriever.RefreshToken(context.Background(), "public", "private"))
+	errors := metrics.TcgplayerRequests.WithLabelValues("catalog_search", metrics.OutcomeError)
+	before := testutil.ToFloat64(errors)
+
+	response, err := retriever.BasicCardSearch(context.Background(), "LOB-001")
+	require.NotNil(t, err)
+	require.Nil(t, response)
+	require.Contains(t, err.Error(), "429")
+	require.Equal(t, before+1, testutil.ToFloat64(errors))
+}
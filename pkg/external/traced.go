@@ -0,0 +1,109 @@
+package external
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/tracing"
+)
+
+// tracedRetriever records each call of the retriever it wraps as a span.
+type tracedRetriever struct {
+	retriever ExtRetriever
+}
+
+// Trace returns an ExtRetriever recording each call of retriever as a span.
+func Trace(retriever ExtRetriever) ExtRetriever {
+	return &tracedRetriever{retriever: retriever}
+}
+
+func startSpan(ctx context.Context, method string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "tcgplayer "+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+}
+
+func (t *tracedRetriever) RefreshToken(ctx context.Context, publicKey string, privateKey string) error {
+	ctx, span := startSpan(ctx, "RefreshToken")
+	err := t.retriever.RefreshToken(ctx, publicKey, privateKey)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *tracedRetriever) BasicCardSearch(ctx context.Context, serial string) (*models.SearchResponse, error) {
+	ctx, span := startSpan(ctx, "BasicCardSearch", attribute.String("card.serial", serial))
+	result, err := t.retriever.BasicCardSearch(ctx, serial)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (t *tracedRetriever) CatalogSearch(ctx context.Context, filters []models.CardSearchFilter, offset int, limit int) (*models.SearchResponse, error) {
+	ctx, span := startSpan(ctx, "CatalogSearch")
+	result, err := t.retriever.CatalogSearch(ctx, filters, offset, limit)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (t *tracedRetriever) ExtendedCardSearch(ctx context.Context, productId int) (*models.ExtendedSearchResponse, error) {
+	ctx, span := startSpan(ctx, "ExtendedCardSearch", attribute.Int("tcgplayer.product_id", productId))
+	result, err := t.retriever.ExtendedCardSearch(ctx, productId)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (t *tracedRetriever) ExtendedCardSearchMultiple(ctx context.Context, productIds []int) (*models.ExtendedSearchResponse, error) {
+	ctx, span := startSpan(ctx, "ExtendedCardSearchMultiple", attribute.IntSlice("tcgplayer.product_ids", productIds))
+	result, err := t.retriever.ExtendedCardSearchMultiple(ctx, productIds)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (t *tracedRetriever) GetCardPricingInfo(ctx context.Context, productId int) (*models.PriceResponse, error) {
+	ctx, span := startSpan(ctx, "GetCardPricingInfo", attribute.Int("tcgplayer.product_id", productId))
+	result, err := t.retriever.GetCardPricingInfo(ctx, productId)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (t *tracedRetriever) GetCardPricingInfoMultiple(ctx context.Context, productIds []int) (*models.PriceResponse, error) {
+	ctx, span := startSpan(ctx, "GetCardPricingInfoMultiple", attribute.IntSlice("tcgplayer.product_ids", productIds))
+	result, err := t.retriever.GetCardPricingInfoMultiple(ctx, productIds)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (t *tracedRetriever) GetProductSkus(ctx context.Context, productId int) (*models.SkuResponse, error) {
+	ctx, span := startSpan(ctx, "GetProductSkus", attribute.Int("tcgplayer.product_id", productId))
+	result, err := t.retriever.GetProductSkus(ctx, productId)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (t *tracedRetriever) GetSkuPricingInfo(ctx context.Context, skuIds []int) (*models.SkuPriceResponse, error) {
+	ctx, span := startSpan(ctx, "GetSkuPricingInfo", attribute.IntSlice("tcgplayer.sku_ids", skuIds))
+	result, err := t.retriever.GetSkuPricingInfo(ctx, skuIds)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (t *tracedRetriever) GetGroups(ctx context.Context, offset int, limit int) (*models.GroupResponse, error) {
+	ctx, span := startSpan(ctx, "GetGroups")
+	result, err := t.retriever.GetGroups(ctx, offset, limit)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (t *tracedRetriever) GetGroupProducts(ctx context.Context, groupId int, offset int, limit int) (*models.ExtendedSearchResponse, error) {
+	ctx, span := startSpan(ctx, "GetGroupProducts", attribute.Int("tcgplayer.group_id", groupId))
+	result, err := t.retriever.GetGroupProducts(ctx, groupId, offset, limit)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (t *tracedRetriever) GetGroupPricingInfo(ctx context.Context, groupId int) (*models.PriceResponse, error) {
+	ctx, span := startSpan(ctx, "GetGroupPricingInfo", attribute.Int("tcgplayer.group_id", groupId))
+	result, err := t.retriever.GetGroupPricingInfo(ctx, groupId)
+	tracing.End(span, err)
+	return result, err
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"ygo-card-processor/pkg/config"
+)
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+// Fields identifying what a log entry is about. They are carried by contexts, so that entries logged deep inside the
+// database, tcgplayer.com or event code still tell which request, job and card they belong to.
+const (
+	FieldRequestId = "requestId"
+	FieldJobId     = "jobId"
+	FieldSerial    = "serial"
+	FieldProductId = "productId"
+)
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIdKey
+)
+
+// Setup sets the format and level of the standard logger, which every context-scoped logger logs with.
+func Setup(cfg config.LoggingConfig) error {
+	level, err := logrus.ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	logrus.SetLevel(level)
+
+	switch cfg.Format {
+	case FormatText, "":
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case FormatJson:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format '%v'", cfg.Format)
+	}
+	return nil
+}
+
+// From returns the logger of ctx, which adds the fields ctx carries to every entry. Contexts without fields get the
+// standard logger.
+func From(ctx context.Context) *logrus.Entry {
+	if logger, ok := ctx.Value(loggerKey).(*logrus.Entry); ok {
+		return logger
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// WithLogger returns a copy of ctx carrying the given logger, such as the logger of another context.
+func WithLogger(ctx context.Context, logger *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// WithField returns a copy of ctx whose logger adds the given field, on top of the fields ctx already carries.
+func WithField(ctx context.Context, key string, value interface{}) context.Context {
+	return WithLogger(ctx, From(ctx).WithField(key, value))
+}
+
+// WithRequestId returns a copy of ctx for serving the request with the given ID, whose logger adds the ID to every
+// entry.
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return WithField(context.WithValue(ctx, requestIdKey, requestId), FieldRequestId, requestId)
+}
+
+// RequestId returns the ID of the request ctx serves, or "" outside of requests.
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey).(string)
+	return requestId
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ygo"
+
+// Outcomes of TCGplayer requests and database operations.
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// Outcomes of the cards of a job.
+const (
+	CardPriceOnly      = "price_only"
+	CardCatalogChanged = "catalog_changed"
+	CardAdded          = "added"
+	CardFailed         = "failed"
+	CardNotFound       = "not_found"
+	CardAmbiguous      = "ambiguous"
+)
+
+// Registry holds every metric of the service, along with the Go runtime and process metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HttpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "API requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+	HttpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve API requests by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	HttpRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_rate_limited_total",
+		Help:      "API requests refused for exceeding a rate limit, by limit.",
+	}, []string{"limit"})
+
+	TcgplayerRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tcgplayer_requests_total",
+		Help:      "Requests to the tcgplayer.com API by endpoint and outcome.",
+	}, []string{"endpoint", "outcome"})
+	TcgplayerRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tcgplayer_request_duration_seconds",
+		Help:      "Time taken by requests to the tcgplayer.com API by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+	TcgplayerWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tcgplayer_rate_limit_wait_seconds",
+		Help:      "Time jobs waited between cards to stay within the tcgplayer.com API limit.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 1.5, 2.5, 5},
+	})
+
+	JobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Time from starting to ending jobs by type and final status.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"type", "status"})
+	JobCards = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_cards_total",
+		Help:      "Cards worked through by jobs by job type and outcome.",
+	}, []string{"type", "outcome"})
+
+	MongoOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_operation_duration_seconds",
+		Help:      "Time taken by database operations by DbHandler method and outcome.",
+		Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	}, []string{"method", "outcome"})
+
+	KafkaMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_messages_total",
+		Help:      "Messages produced to Kafka by outcome: delivered, failed or retried.",
+	}, []string{"outcome"})
+
+	CollectionCards = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "collection_cards",
+		Help:      "Cards stored in each collection.",
+	}, []string{"collection"})
+	CollectionMarketValue = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "collection_market_value_dollars",
+		Help:      "Total market value of the cards in each collection.",
+	}, []string{"collection"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		HttpRequests, HttpRequestDuration, HttpRateLimited,
+		TcgplayerRequests, TcgplayerRequestDuration, TcgplayerWait,
+		JobDuration, JobCards,
+		MongoOperationDuration,
+		KafkaMessages,
+		CollectionCards, CollectionMarketValue,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Outcome returns OutcomeError if err is set, and OutcomeSuccess otherwise.
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
+
+// Since returns the seconds passed since start, as histograms observe them.
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
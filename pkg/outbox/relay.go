@@ -0,0 +1,75 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/producer"
+)
+
+const (
+	defaultInterval  = 5 * time.Second
+	defaultBatchSize = 100
+)
+
+// Relay publishes pending outbox events through an event sink and marks them sent once delivery is confirmed. Events
+// are published in the order they were written; a failed delivery stops the batch so later events are not published
+// ahead of it. An event may be published more than once if marking it sent fails, so consumers should de-duplicate.
+type Relay struct {
+	Handler   dao.DbHandler
+	Sink      producer.EventSink
+	Interval  time.Duration
+	BatchSize int
+}
+
+// Run relays pending events every Interval until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RelayPending(ctx); err != nil {
+			logging.From(ctx).WithError(err).Error("Error relaying outbox events")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayPending publishes one batch of pending events and returns how many were published and marked sent.
+func (r *Relay) RelayPending(ctx context.Context) (int, error) {
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	events, err := r.Handler.GetPendingOutboxEvents(ctx, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	relayed := 0
+	for _, event := range events {
+		if err := r.Sink.Publish(ctx, event.Event); err != nil {
+			return relayed, err
+		}
+
+		if err := r.Handler.MarkOutboxEventSent(ctx, event.Id); err != nil {
+			return relayed, err
+		}
+		relayed++
+	}
+
+	return relayed, nil
+}
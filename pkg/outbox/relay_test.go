@@ -0,0 +1,85 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+func TestRelay_RelayPending_ShouldReturnError_IfGetPendingEventsFails(t *testing.T) {
+	handler := &mocks.DbHandler{}
+	handler.On("GetPendingOutboxEvents", mock.Anything, defaultBatchSize).Return(nil, errors.New("test"))
+
+	relay := Relay{Handler: handler, Sink: &mocks.EventSink{}}
+	relayed, err := relay.RelayPending(context.Background())
+	require.NotNil(t, err)
+	require.Equal(t, 0, relayed)
+}
+
+func TestRelay_RelayPending_ShouldPublishAndMarkEventsSent_InOrder(t *testing.T) {
+	first := models.OutboxEvent{Id: primitive.NewObjectID(), Event: models.DomainEvent{Type: models.EventCardAdded}}
+	second := models.OutboxEvent{Id: primitive.NewObjectID(), Event: models.DomainEvent{Type: models.EventPriceChanged}}
+
+	handler := &mocks.DbHandler{}
+	handler.On("GetPendingOutboxEvents", mock.Anything, 10).Return([]models.OutboxEvent{first, second}, nil)
+	handler.On("MarkOutboxEventSent", mock.Anything, first.Id).Return(nil).Once()
+	handler.On("MarkOutboxEventSent", mock.Anything, second.Id).Return(nil).Once()
+
+	sink := &mocks.EventSink{}
+	sink.On("Publish", mock.Anything, mock.MatchedBy(isEventType(models.EventCardAdded))).Return(nil).Once()
+	sink.On("Publish", mock.Anything, mock.MatchedBy(isEventType(models.EventPriceChanged))).Return(nil).Once()
+
+	relay := Relay{Handler: handler, Sink: sink, BatchSize: 10}
+	relayed, err := relay.RelayPending(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 2, relayed)
+	handler.AssertExpectations(t)
+	sink.AssertExpectations(t)
+}
+
+func TestRelay_RelayPending_ShouldStopAtFirstFailedDelivery(t *testing.T) {
+	first := models.OutboxEvent{Id: primitive.NewObjectID(), Event: models.DomainEvent{Type: models.EventCardAdded}}
+	second := models.OutboxEvent{Id: primitive.NewObjectID(), Event: models.DomainEvent{Type: models.EventCardDeleted}}
+
+	handler := &mocks.DbHandler{}
+	handler.On("GetPendingOutboxEvents", mock.Anything, defaultBatchSize).Return([]models.OutboxEvent{first, second}, nil)
+
+	sink := &mocks.EventSink{}
+	sink.On("Publish", mock.Anything, mock.MatchedBy(isEventType(models.EventCardAdded))).Return(errors.New("test"))
+
+	relay := Relay{Handler: handler, Sink: sink}
+	relayed, err := relay.RelayPending(context.Background())
+	require.NotNil(t, err)
+	require.Equal(t, 0, relayed)
+	handler.AssertNotCalled(t, "MarkOutboxEventSent", mock.Anything, mock.Anything)
+	sink.AssertNumberOfCalls(t, "Publish", 1)
+}
+
+func TestRelay_RelayPending_ShouldReturnError_IfMarkSentFails(t *testing.T) {
+	event := models.OutboxEvent{Id: primitive.NewObjectID(), Event: models.DomainEvent{Type: models.EventCardUpdated}}
+
+	handler := &mocks.DbHandler{}
+	handler.On("GetPendingOutboxEvents", mock.Anything, defaultBatchSize).Return([]models.OutboxEvent{event}, nil)
+	handler.On("MarkOutboxEventSent", mock.Anything, event.Id).Return(errors.New("test"))
+
+	sink := &mocks.EventSink{}
+	sink.On("Publish", mock.Anything, mock.MatchedBy(isEventType(models.EventCardUpdated))).Return(nil)
+
+	relay := Relay{Handler: handler, Sink: sink}
+	relayed, err := relay.RelayPending(context.Background())
+	require.NotNil(t, err)
+	require.Equal(t, 0, relayed)
+}
+
+func isEventType(eventType string) func(models.DomainEvent) bool {
+	return func(event models.DomainEvent) bool {
+		return event.Type == eventType
+	}
+}
@@ -0,0 +1,376 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/events"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/progress"
+	"ygo-card-processor/pkg/tenant"
+)
+
+var (
+	// ErrJobNotRunning is returned when pausing or cancelling a job that has already ended.
+	ErrJobNotRunning = errors.New("job is not running")
+	// ErrJobNotPaused is returned when resuming a job that is not paused.
+	ErrJobNotPaused = errors.New("job is not paused")
+	// ErrJobNotControllable is returned for jobs other than refreshes and imports, which always run to completion.
+	ErrJobNotControllable = errors.New("only refresh and import jobs can be paused, resumed or cancelled")
+	// ErrJobClaimed is returned when controlling or resuming a job that another replica is running.
+	ErrJobClaimed = errors.New("job is run by another replica")
+)
+
+// resumeInterval is how often replicas check for interrupted jobs to resume, such as those of a replica that stopped.
+const resumeInterval = time.Minute
+
+// jobControl passes pause, resume and cancel requests to the goroutine running a job. The goroutine checks for them
+// between cards, so the card being processed is always finished first.
+type jobControl struct {
+	mu        sync.Mutex
+	paused    bool
+	resumed   chan struct{}
+	cancelled chan struct{}
+	cancel    sync.Once
+	// lost is set once the job's lease has passed to another replica, which carries on with the job.
+	lost bool
+
+	// startedAt and startCheckpoint mark where this run of the job began, to estimate when it will finish.
+	startedAt       time.Time
+	startCheckpoint int
+}
+
+func newJobControl() *jobControl {
+	return &jobControl{cancelled: make(chan struct{}), startedAt: time.Now()}
+}
+
+func (c *jobControl) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		c.paused = true
+		c.resumed = make(chan struct{})
+	}
+}
+
+func (c *jobControl) resume() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		return false
+	}
+	c.paused = false
+	close(c.resumed)
+	return true
+}
+
+func (c *jobControl) stop() {
+	c.cancel.Do(func() { close(c.cancelled) })
+}
+
+// lose stops a job whose lease has passed to another replica, without ending it.
+func (c *jobControl) lose() {
+	c.mu.Lock()
+	c.lost = true
+	c.mu.Unlock()
+	c.stop()
+}
+
+func (c *jobControl) isLost() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lost
+}
+
+func (c *jobControl) isPaused() (bool, chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused, c.resumed
+}
+
+// proceed is called by a job before each card. It blocks while the job is paused, recording the pause on the job, and
+// returns false once the job has been cancelled.
+func (p *Processor) proceed(ctx context.Context, job *models.Job, control *jobControl) bool {
+	for {
+		select {
+		case <-control.cancelled:
+			return false
+		default:
+		}
+
+		paused, resumed := control.isPaused()
+		if !paused {
+			if job.Status != models.JobStatusRunning {
+				job.Status = models.JobStatusRunning
+				SaveJob(ctx, p.Handler, job)
+				p.reportProgress(job, control, "", 0)
+			}
+			return true
+		}
+
+		job.Status = models.JobStatusPaused
+		SaveJob(ctx, p.Handler, job)
+		p.reportProgress(job, control, "", 0)
+		select {
+		case <-resumed:
+		case <-control.cancelled:
+			return false
+		}
+	}
+}
+
+func (p *Processor) register(job *models.Job) *jobControl {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.controls == nil {
+		p.controls = make(map[primitive.ObjectID]*jobControl)
+	}
+	control := newJobControl()
+	control.startCheckpoint = job.Checkpoint
+	p.controls[job.Id] = control
+	return control
+}
+
+func (p *Processor) unregister(job *models.Job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.controls, job.Id)
+}
+
+func (p *Processor) control(id primitive.ObjectID) *jobControl {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.controls[id]
+}
+
+func (p *Processor) Cancel(ctx context.Context, id primitive.ObjectID) error {
+	if control := p.control(id); control != nil {
+		control.stop()
+		return nil
+	}
+
+	// A job paused before a restart has no goroutine left to stop, so it is ended here instead, once no other replica
+	// holds it.
+	job, err := p.Handler.GetJob(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error retrieving job: %w", err)
+	}
+	if job.Status != models.JobStatusPaused {
+		return runningElsewhereError(job, ErrJobNotRunning)
+	}
+	release, err := p.leaseJob(ctx, job.Id)
+	if err != nil {
+		return err
+	}
+	defer release()
+	EndJob(events.WithJobId(ctx, job.Id.Hex()), p.Handler, p.Sink, job, models.JobStatusCancelled)
+	p.reportProgress(job, nil, "", 0)
+	return nil
+}
+
+func (p *Processor) Pause(ctx context.Context, id primitive.ObjectID) error {
+	if control := p.control(id); control != nil {
+		control.pause()
+		return nil
+	}
+
+	job, err := p.Handler.GetJob(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error retrieving job: %w", err)
+	}
+	return runningElsewhereError(job, ErrJobNotRunning)
+}
+
+func (p *Processor) Resume(ctx context.Context, id primitive.ObjectID) error {
+	if control := p.control(id); control != nil {
+		if !control.resume() {
+			return ErrJobNotPaused
+		}
+		return nil
+	}
+
+	job, err := p.Handler.GetJob(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error retrieving job: %w", err)
+	}
+	if job.Status != models.JobStatusPaused {
+		return jobStateError(job, ErrJobNotPaused)
+	}
+	return p.resumeJob(ctx, job)
+}
+
+// ResumeInterrupted resumes the refresh and import jobs of every collection that are running but no longer leased, from
+// their checkpoints. Those are the jobs of a processor that stopped, this one before it restarted or another replica.
+// Jobs leased to other replicas are left to them, and paused jobs stay paused until resumed.
+func (p *Processor) ResumeInterrupted(ctx context.Context) error {
+	jobs, err := p.Handler.GetJobsByStatus(ctx, models.JobStatusRunning)
+	if err != nil {
+		return fmt.Errorf("error retrieving running jobs: %w", err)
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+		if job.Type != models.JobTypeRefresh && job.Type != models.JobTypeImport {
+			continue
+		}
+		if p.control(job.Id) != nil {
+			continue
+		}
+		err := p.resumeJob(tenant.WithCollection(ctx, job.CollectionId), job)
+		if errors.Is(err, ErrJobClaimed) {
+			continue
+		}
+		if err != nil {
+			logging.From(ctx).WithError(err).Error(fmt.Sprintf("Error resuming job %v", job.Id.Hex()))
+		} else {
+			logging.From(ctx).Info(fmt.Sprintf("Resumed interrupted job %v", job.Id.Hex()))
+		}
+	}
+	return nil
+}
+
+// WatchInterrupted resumes interrupted jobs every resumeInterval until ctx is cancelled, so that the jobs of a replica
+// that stopped are carried on by the others once their leases have expired.
+func (p *Processor) WatchInterrupted(ctx context.Context) {
+	ticker := time.NewTicker(resumeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := p.ResumeInterrupted(ctx); err != nil {
+			logging.From(ctx).WithError(err).Error("Error resuming interrupted jobs")
+		}
+	}
+}
+
+// resumeJob leases a job and starts a new goroutine for it from its checkpoint.
+func (p *Processor) resumeJob(ctx context.Context, job *models.Job) error {
+	if job.Type != models.JobTypeRefresh && job.Type != models.JobTypeImport {
+		return ErrJobNotControllable
+	}
+
+	release, err := p.leaseJob(ctx, job.Id)
+	if err != nil {
+		return err
+	}
+
+	switch job.Type {
+	case models.JobTypeRefresh:
+		unlock, err := p.lockRefresh(ctx)
+		if err != nil {
+			release()
+			return err
+		}
+
+		cardList, err := p.loadCards(ctx, job.Serials)
+		if err == nil {
+			err = p.refreshToken(ctx)
+		}
+		if err != nil {
+			unlock()
+			release()
+			return err
+		}
+
+		job.Status = models.JobStatusRunning
+		SaveJob(ctx, p.Handler, job)
+		control := p.register(job)
+		go func() {
+			defer unlock()
+			defer release()
+			defer p.unregister(job)
+			RunJob(ctx, job, func(ctx context.Context) { p.runRefresh(ctx, job, control, cardList) })
+		}()
+	default:
+		if err := p.refreshToken(ctx); err != nil {
+			release()
+			return fmt.Errorf("error refreshing token: %w", err)
+		}
+
+		job.Status = models.JobStatusRunning
+		SaveJob(ctx, p.Handler, job)
+		control := p.register(job)
+		go func() {
+			defer release()
+			defer p.unregister(job)
+			RunJob(ctx, job, func(ctx context.Context) { p.runImport(ctx, job, control, job.Serials) })
+		}()
+	}
+	return nil
+}
+
+// loadCards retrieves the stored cards a refresh works through, in the job's order. A card deleted since the job
+// started is left with only its serial number, so it fails to update and is counted as failed.
+func (p *Processor) loadCards(ctx context.Context, serials []string) ([]models.CardWithPriceInfo, error) {
+	stored, err := p.Handler.GetCards(ctx, dao.CardQuery(models.CardFilter{Serials: serials}))
+	if err != nil {
+		return nil, fmt.Errorf("error getting cards from database: %w", err)
+	}
+
+	bySerial := make(map[string]models.CardWithPriceInfo)
+	for _, card := range stored {
+		bySerial[card.CardInfo.Serial()] = card
+	}
+
+	cardList := make([]models.CardWithPriceInfo, 0)
+	for _, serial := range serials {
+		card, ok := bySerial[serial]
+		if !ok {
+			card = models.CardWithPriceInfo{CardInfo: models.Card{ExtendedData: []models.ExtendedData{{Value: serial}}}}
+		}
+		cardList = append(cardList, card)
+	}
+	return cardList, nil
+}
+
+// reportProgress publishes a job's progress to the clients following it, given the card being processed or the time
+// the job is waiting before the next card.
+func (p *Processor) reportProgress(job *models.Job, control *jobControl, currentCard string, wait time.Duration) {
+	if p.Progress == nil {
+		return
+	}
+
+	update := progress.FromJob(*job)
+	update.CurrentCard = currentCard
+	update.RateLimitWaitMs = wait.Milliseconds()
+	if control != nil && !progress.Ended(job.Status) {
+		// Checkpoint counts the card being processed, which has not been done yet.
+		done := job.Checkpoint
+		if currentCard != "" {
+			done--
+		}
+		if done -= control.startCheckpoint; done > 0 {
+			perCard := time.Since(control.startedAt).Seconds() / float64(done)
+			update.EtaSeconds = perCard * float64(job.Total-job.Checkpoint)
+		}
+	}
+	p.Progress.Publish(update)
+}
+
+func jobStateError(job *models.Job, err error) error {
+	if job.Type != models.JobTypeRefresh && job.Type != models.JobTypeImport {
+		return ErrJobNotControllable
+	}
+	return err
+}
+
+// runningElsewhereError is jobStateError for a job this processor runs no goroutine for, which another replica is
+// running if it is still running.
+func runningElsewhereError(job *models.Job, err error) error {
+	if job.Status == models.JobStatusRunning {
+		err = ErrJobClaimed
+	}
+	return jobStateError(job, err)
+}
@@ -0,0 +1,243 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/progress"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+func mockImportedSerial(retriever *mocks.ExtRetriever) {
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{Results: []int{123}}, nil)
+	mockPricedProduct(retriever)
+}
+
+func TestProcessor_RunImport_ShouldResumeFromCheckpoint(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+	dbHandler.On("AddCard", mock.Anything, mock.Anything).Return(nil, nil)
+
+	retriever := &mocks.ExtRetriever{}
+	mockImportedSerial(retriever)
+
+	sink := &producer.MemorySink{}
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: sink}
+	job := newJob()
+	job.Checkpoint = 1
+	job.Processed = 1
+	p.runImport(context.Background(), job, newJobControl(), []string{"DONE", "NEXT"})
+	require.Equal(t, 2, job.Processed)
+	require.Equal(t, 2, job.Checkpoint)
+	retriever.AssertCalled(t, "BasicCardSearch", mock.Anything, "NEXT")
+	retriever.AssertNotCalled(t, "BasicCardSearch", mock.Anything, "DONE")
+
+	published := sink.Events()
+	require.Len(t, published, 1)
+	require.Equal(t, models.EventJobFinished, published[0].Type)
+}
+
+func TestProcessor_RunImport_ShouldStopIfCancelled(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+
+	p := Processor{Handler: dbHandler, Retriever: &mocks.ExtRetriever{}, Sink: &producer.MemorySink{}}
+	job := newJob()
+	control := newJobControl()
+	control.stop()
+	p.runImport(context.Background(), job, control, []string{"TEST"})
+	require.Equal(t, models.JobStatusCancelled, job.Status)
+	require.Equal(t, 0, job.Checkpoint)
+	dbHandler.AssertNotCalled(t, "AddCard", mock.Anything, mock.Anything)
+}
+
+func TestProcessor_RunImport_ShouldWaitWhilePaused(t *testing.T) {
+	paused := make(chan struct{})
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.MatchedBy(func(job models.Job) bool {
+		return job.Status == models.JobStatusPaused
+	})).Run(func(mock.Arguments) { close(paused) }).Return(nil).Once()
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+	dbHandler.On("AddCard", mock.Anything, mock.Anything).Return(nil, nil)
+
+	retriever := &mocks.ExtRetriever{}
+	mockImportedSerial(retriever)
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	job := newJob()
+	control := newJobControl()
+	control.pause()
+
+	done := make(chan struct{})
+	go func() {
+		p.runImport(context.Background(), job, control, []string{"TEST"})
+		close(done)
+	}()
+
+	<-paused
+	dbHandler.AssertNotCalled(t, "AddCard", mock.Anything, mock.Anything)
+	require.True(t, control.resume())
+	<-done
+	require.Equal(t, models.JobStatusFinished, job.Status)
+	require.Equal(t, 1, job.Processed)
+}
+
+func TestProcessor_Cancel_ShouldEndPausedJobWithoutGoroutine(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeImport, Status: models.JobStatusPaused}
+	dbHandler := &mocks.DbHandler{}
+	mockLocks(dbHandler)
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(job, nil)
+	dbHandler.On("UpdateJob", mock.Anything, mock.MatchedBy(func(updated models.Job) bool {
+		return updated.Status == models.JobStatusCancelled
+	})).Return(nil)
+
+	sink := &producer.MemorySink{}
+	p := Processor{Handler: dbHandler, Retriever: &mocks.ExtRetriever{}, Sink: sink}
+	require.Nil(t, p.Cancel(context.Background(), job.Id))
+	dbHandler.AssertExpectations(t)
+	require.Equal(t, models.EventJobFinished, sink.Events()[0].Type)
+}
+
+func TestProcessor_Cancel_ShouldStopRunningJob(t *testing.T) {
+	p := Processor{}
+	job := newJob()
+	control := p.register(job)
+	require.Nil(t, p.Cancel(context.Background(), job.Id))
+
+	select {
+	case <-control.cancelled:
+	default:
+		t.Fatal("job was not cancelled")
+	}
+}
+
+func TestProcessor_Resume_ShouldReturnErrorIfJobNotPaused(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeRefresh, Status: models.JobStatusFinished}
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(job, nil)
+
+	p := Processor{Handler: dbHandler}
+	require.Equal(t, ErrJobNotPaused, p.Resume(context.Background(), job.Id))
+}
+
+func TestProcessor_Pause_ShouldReturnErrorIfJobNotControllable(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeSetSync, Status: models.JobStatusRunning}
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(job, nil)
+
+	p := Processor{Handler: dbHandler}
+	require.Equal(t, ErrJobNotControllable, p.Pause(context.Background(), job.Id))
+}
+
+func TestProcessor_ResumeInterrupted_ShouldResumeRunningJobsFromCheckpoint(t *testing.T) {
+	added := make(chan struct{})
+	job := models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeImport, Status: models.JobStatusRunning, Total: 2, Checkpoint: 1, Serials: []string{"DONE", "NEXT"}}
+	dbHandler := &mocks.DbHandler{}
+	mockLocks(dbHandler)
+	dbHandler.On("GetJobsByStatus", mock.Anything, models.JobStatusRunning).Return([]models.Job{job}, nil)
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+	dbHandler.On("AddCard", mock.Anything, mock.Anything).Run(func(mock.Arguments) { close(added) }).Return(nil, nil)
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockImportedSerial(retriever)
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	require.Nil(t, p.ResumeInterrupted(context.Background()))
+	<-added
+	retriever.AssertCalled(t, "BasicCardSearch", mock.Anything, "NEXT")
+	retriever.AssertNotCalled(t, "BasicCardSearch", mock.Anything, "DONE")
+}
+
+func TestProcessor_ResumeInterrupted_ShouldLeaveJobsLeasedToOtherReplicas(t *testing.T) {
+	job := models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeImport, Status: models.JobStatusRunning, Total: 1, Serials: []string{"NEXT"}}
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJobsByStatus", mock.Anything, models.JobStatusRunning).Return([]models.Job{job}, nil)
+	dbHandler.On("AcquireLock", mock.Anything, "job:"+job.Id.Hex(), mock.Anything, leaseDuration).Return(false, nil)
+
+	retriever := &mocks.ExtRetriever{}
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	require.Nil(t, p.ResumeInterrupted(context.Background()))
+	require.Nil(t, p.control(job.Id))
+	dbHandler.AssertNotCalled(t, "UpdateJob", mock.Anything, mock.Anything)
+	retriever.AssertNotCalled(t, "RefreshToken", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessor_RunImport_ShouldStopWithoutEndingJobIfLeaseLost(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+
+	sink := &producer.MemorySink{}
+	p := Processor{Handler: dbHandler, Retriever: &mocks.ExtRetriever{}, Sink: sink}
+	job := newJob()
+	job.Checkpoint = 1
+	control := newJobControl()
+	control.lose()
+	p.runImport(context.Background(), job, control, []string{"DONE", "NEXT"})
+	require.Equal(t, models.JobStatusRunning, job.Status)
+	require.Equal(t, 1, job.Checkpoint)
+	dbHandler.AssertNotCalled(t, "UpdateJob", mock.Anything, mock.Anything)
+	require.Empty(t, sink.Events())
+}
+
+func TestProcessor_Pause_ShouldReturnErrorIfJobRunOnOtherReplica(t *testing.T) {
+	job := &models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeRefresh, Status: models.JobStatusRunning}
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetJob", mock.Anything, job.Id).Return(job, nil)
+
+	p := Processor{Handler: dbHandler}
+	require.Equal(t, ErrJobClaimed, p.Pause(context.Background(), job.Id))
+	require.Equal(t, ErrJobClaimed, p.Cancel(context.Background(), job.Id))
+}
+
+func TestProcessor_LoadCards_ShouldKeepJobOrder(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCards", mock.Anything, dao.CardQuery(models.CardFilter{Serials: []string{"B", "DELETED", "A"}})).
+		Return([]models.CardWithPriceInfo{storedCard("A"), storedCard("B")}, nil)
+
+	p := Processor{Handler: dbHandler}
+	cardList, err := p.loadCards(context.Background(), []string{"B", "DELETED", "A"})
+	require.Nil(t, err)
+	require.Len(t, cardList, 3)
+	require.Equal(t, "B", cardList[0].CardInfo.Serial())
+	require.Equal(t, "DELETED", cardList[1].CardInfo.Serial())
+	require.Equal(t, "A", cardList[2].CardInfo.Serial())
+}
+
+func TestProcessor_RunImport_ShouldReportProgress(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+	dbHandler.On("AddCard", mock.Anything, mock.Anything).Return(nil, nil)
+
+	retriever := &mocks.ExtRetriever{}
+	mockImportedSerial(retriever)
+
+	broadcaster := progress.NewBroadcaster()
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}, Progress: broadcaster}
+	job := newJob()
+	job.Type = models.JobTypeImport
+	job.Total = 2
+	updates, _, unsubscribe := broadcaster.Subscribe(job.Id.Hex())
+	defer unsubscribe()
+
+	p.runImport(context.Background(), job, newJobControl(), []string{"FIRST", "SECOND"})
+
+	reported := make([]models.JobProgress, 0)
+	for update := range updates {
+		reported = append(reported, update)
+	}
+	require.Equal(t, "FIRST", reported[0].CurrentCard)
+	require.Equal(t, 0, reported[0].Processed)
+	require.Equal(t, 1, reported[1].Processed)
+	require.True(t, reported[1].EtaSeconds >= 0)
+	require.Equal(t, "SECOND", reported[2].CurrentCard)
+	require.Equal(t, models.JobStatusFinished, reported[len(reported)-1].Status)
+	require.Equal(t, 2, reported[len(reported)-1].Processed)
+}
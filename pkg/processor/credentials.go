@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/external"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/tenant"
+)
+
+// RefreshToken refreshes the retriever's token for the collection ctx is scoped to, using the collection's own
+// tcgplayer.com credentials if it has them, and the given ones otherwise.
+func RefreshToken(ctx context.Context, handler dao.DbHandler, retriever external.ExtRetriever, publicKey string, privateKey string) error {
+	publicKey, privateKey, err := credentials(ctx, handler, publicKey, privateKey)
+	if err != nil {
+		return err
+	}
+	return retriever.RefreshToken(ctx, publicKey, privateKey)
+}
+
+// credentials returns the tcgplayer.com credentials used for the collection ctx is scoped to: its own if it has them,
+// and the given ones otherwise.
+func credentials(ctx context.Context, handler dao.DbHandler, publicKey string, privateKey string) (string, string, error) {
+	if collectionId := tenant.CollectionId(ctx); collectionId != models.DefaultCollectionId {
+		collection, err := handler.GetCollection(ctx, collectionId)
+		if err != nil {
+			return "", "", fmt.Errorf("error retrieving collection: %w", err)
+		}
+		if collection.Tcgplayer != nil {
+			return collection.Tcgplayer.PublicKey, collection.Tcgplayer.PrivateKey, nil
+		}
+	}
+	return publicKey, privateKey, nil
+}
+
+func (p *Processor) refreshToken(ctx context.Context) error {
+	return RefreshToken(ctx, p.Handler, p.Retriever, p.PublicKey, p.PrivateKey)
+}
+
+// lockRefresh claims the tcgplayer.com credentials used for the collection ctx is scoped to for a refresh, and returns
+// the function releasing them. Collections with credentials of their own can be refreshed alongside others, while
+// those sharing credentials, and so their API limit, are refreshed one at a time. The lock is kept in the database, so
+// that it holds across every replica, and is renewed until released.
+func (p *Processor) lockRefresh(ctx context.Context) (func(), error) {
+	publicKey, _, err := credentials(ctx, p.Handler, p.PublicKey, p.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(publicKey))
+	name := "refresh:" + hex.EncodeToString(hash[:])
+	owner := leaseOwner()
+	acquired, err := p.Handler.AcquireLock(ctx, name, owner, leaseDuration)
+	if err != nil {
+		return nil, fmt.Errorf("error locking refresh: %w", err)
+	} else if !acquired {
+		return nil, ErrRefreshInProgress
+	}
+
+	// The lock outlives the request that took it, so it is renewed and released with a context of its own.
+	lockCtx := logging.WithLogger(context.Background(), logging.From(ctx))
+	stop := keepRenewing(func() {
+		if renewed, err := p.Handler.AcquireLock(lockCtx, name, owner, leaseDuration); err != nil {
+			logging.From(lockCtx).WithError(err).Error("Error renewing refresh lock")
+		} else if !renewed {
+			logging.From(lockCtx).Warn("Refresh lock expired before it was renewed")
+		}
+	})
+	return func() {
+		stop()
+		if err := p.Handler.ReleaseLock(lockCtx, name, owner); err != nil {
+			logging.From(lockCtx).WithError(err).Error("Error releasing refresh lock")
+		}
+	}, nil
+}
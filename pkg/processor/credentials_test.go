@@ -0,0 +1,63 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/tenant"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+func TestProcessor_RefreshToken_ShouldUseCollectionCredentials(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCollection", mock.Anything, "binder").Return(&models.Collection{
+		Id:        "binder",
+		Tcgplayer: &models.TcgplayerCredentials{PublicKey: "binder-public", PrivateKey: "binder-private"},
+	}, nil)
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, "binder-public", "binder-private").Return(nil)
+
+	ctx := tenant.WithCollection(context.Background(), "binder")
+	require.Nil(t, RefreshToken(ctx, dbHandler, retriever, "public", "private"))
+	retriever.AssertExpectations(t)
+}
+
+func TestProcessor_RefreshToken_ShouldFallBackToGivenCredentials(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetCollection", mock.Anything, "binder").Return(&models.Collection{Id: "binder"}, nil)
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, "public", "private").Return(nil)
+
+	require.Nil(t, RefreshToken(tenant.WithCollection(context.Background(), "binder"), dbHandler, retriever, "public", "private"))
+	require.Nil(t, RefreshToken(context.Background(), dbHandler, retriever, "public", "private"))
+	dbHandler.AssertNumberOfCalls(t, "GetCollection", 1)
+}
+
+func TestProcessor_LockRefresh_ShouldRejectRefreshLockedByOtherReplica(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AcquireLock", mock.Anything, mock.Anything, mock.Anything, leaseDuration).Return(false, nil)
+
+	p := Processor{Handler: dbHandler, PublicKey: "public", PrivateKey: "private"}
+	_, err := p.lockRefresh(context.Background())
+	require.Equal(t, ErrRefreshInProgress, err)
+	dbHandler.AssertNotCalled(t, "ReleaseLock", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessor_LockRefresh_ShouldReleaseLockItAcquired(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AcquireLock", mock.Anything, mock.Anything, mock.Anything, leaseDuration).Return(true, nil)
+	dbHandler.On("ReleaseLock", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	p := Processor{Handler: dbHandler, PublicKey: "public", PrivateKey: "private"}
+	unlock, err := p.lockRefresh(context.Background())
+	require.Nil(t, err)
+	unlock()
+
+	acquired, released := dbHandler.Calls[0].Arguments, dbHandler.Calls[1].Arguments
+	require.Equal(t, acquired.String(1), released.String(1))
+	require.Equal(t, acquired.String(2), released.String(2))
+}
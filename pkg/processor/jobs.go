@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/events"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/metrics"
+	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/tenant"
+	"ygo-card-processor/pkg/tracing"
+)
+
+func StartJob(ctx context.Context, handler dao.DbHandler, jobType string, total int) (*models.Job, error) {
+	return addJob(ctx, handler, models.Job{Type: jobType, Total: total})
+}
+
+// startResumableJob creates a job working through the given serial numbers, and returns it with the function releasing
+// its lease. The serial numbers are stored with the job, so together with its checkpoint the job can be resumed after a
+// restart. The job is leased to this processor before it is stored, so no other replica resumes it in the meantime.
+func (p *Processor) startResumableJob(ctx context.Context, jobType string, serials []string) (*models.Job, func(), error) {
+	id := primitive.NewObjectID()
+	release, err := p.leaseJob(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	job, err := addJob(ctx, p.Handler, models.Job{Id: id, Type: jobType, Total: len(serials), Serials: serials})
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return job, release, nil
+}
+
+func addJob(ctx context.Context, handler dao.DbHandler, job models.Job) (*models.Job, error) {
+	job.Status = models.JobStatusRunning
+	job.Ambiguous = make([]models.AmbiguousSerial, 0)
+	job.NotFound = make([]models.AmbiguousSerial, 0)
+	job.StartedAt = time.Now()
+	job.CollectionId = tenant.CollectionId(ctx)
+
+	id, err := handler.AddJob(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	job.Id = id
+
+	return &job, nil
+}
+
+func SaveJob(ctx context.Context, handler dao.DbHandler, job *models.Job) {
+	if err := handler.UpdateJob(ctx, *job); err != nil {
+		logging.From(ctx).WithError(err).Error("Error updating job")
+	}
+}
+
+func FinishJob(ctx context.Context, handler dao.DbHandler, p producer.EventSink, job *models.Job) {
+	EndJob(ctx, handler, p, job, models.JobStatusFinished)
+}
+
+// EndJob ends a job with the given status, which is either finished or cancelled.
+func EndJob(ctx context.Context, handler dao.DbHandler, p producer.EventSink, job *models.Job, status string) {
+	job.Status = status
+	job.FinishedAt = time.Now()
+	SaveJob(ctx, handler, job)
+	metrics.JobDuration.WithLabelValues(job.Type, status).Observe(job.FinishedAt.Sub(job.StartedAt).Seconds())
+	PublishEvent(ctx, p, events.NewJobFinishedEvent(ctx, *job))
+}
+
+func PublishEvent(ctx context.Context, p producer.EventSink, event models.DomainEvent) {
+	if err := p.Publish(ctx, event); err != nil {
+		logging.From(ctx).WithError(err).Error(fmt.Sprintf("Error publishing %v event", event.Type))
+	}
+}
+
+// recordUnresolvedSerial records a serial that matched no products or several products on the job, rather than
+// guessing which product was meant.
+func recordUnresolvedSerial(ctx context.Context, job *models.Job, row int, serial string, candidates []models.ProductCandidate) {
+	unresolved := models.AmbiguousSerial{
+		Row:        row,
+		Serial:     serial,
+		Candidates: candidates,
+	}
+	if len(candidates) == 0 {
+		job.NotFound = append(job.NotFound, unresolved)
+		countCard(ctx, job, metrics.CardNotFound)
+	} else {
+		job.Ambiguous = append(job.Ambiguous, unresolved)
+		countCard(ctx, job, metrics.CardAmbiguous)
+	}
+}
+
+// countCard records the outcome of a card of a job in the metrics, and on the card's span.
+func countCard(ctx context.Context, job *models.Job, outcome string) {
+	metrics.JobCards.WithLabelValues(job.Type, outcome).Inc()
+	tracing.Annotate(ctx, attribute.String("card.outcome", outcome))
+}
@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ygo-card-processor/pkg/logging"
+)
+
+// leaseDuration is how long a refresh lock or job lease lasts unless it is renewed. Its holder renews it every
+// leaseRenewal, so it only expires once the holder has stopped, or has not reached the database for longer than that.
+const (
+	leaseDuration = time.Minute
+	leaseRenewal  = leaseDuration / 3
+)
+
+// leaseOwner returns a new owner to take a lock or lease as. It starts with the host name, which is the pod name when
+// running in Kubernetes, to show which replica holds a lock, and is unique to the lock taken with it.
+func leaseOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + "/" + primitive.NewObjectID().Hex()
+}
+
+// leaseJob claims the job with the given ID for this processor, and returns the function releasing it. A job is leased
+// for as long as a goroutine of this processor runs it, and its lease is renewed meanwhile, so other replicas only
+// resume it once it has expired. Should the lease pass to another replica anyway, the job is stopped here.
+func (p *Processor) leaseJob(ctx context.Context, id primitive.ObjectID) (func(), error) {
+	name := "job:" + id.Hex()
+	owner := leaseOwner()
+	acquired, err := p.Handler.AcquireLock(ctx, name, owner, leaseDuration)
+	if err != nil {
+		return nil, fmt.Errorf("error leasing job: %w", err)
+	} else if !acquired {
+		return nil, ErrJobClaimed
+	}
+
+	// The lease outlives the request that took it, so it is renewed and released with a context of its own.
+	leaseCtx := logging.WithLogger(context.Background(), logging.From(ctx))
+	stop := keepRenewing(func() {
+		renewed, err := p.Handler.AcquireLock(leaseCtx, name, owner, leaseDuration)
+		if err != nil {
+			logging.From(leaseCtx).WithError(err).Error(fmt.Sprintf("Error renewing lease on job %v", id.Hex()))
+		} else if !renewed {
+			if control := p.control(id); control != nil {
+				control.lose()
+			}
+		}
+	})
+	return func() {
+		stop()
+		if err := p.Handler.ReleaseLock(leaseCtx, name, owner); err != nil {
+			logging.From(leaseCtx).WithError(err).Error(fmt.Sprintf("Error releasing lease on job %v", id.Hex()))
+		}
+	}, nil
+}
+
+// keepRenewing calls renew every leaseRenewal until the returned function is called.
+func keepRenewing(renew func()) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(leaseRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				renew()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(stop) }) }
+}
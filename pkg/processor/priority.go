@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"ygo-card-processor/models"
+)
+
+const (
+	// priceHistoryLength is how many past prices are kept per card to judge how volatile its price is.
+	priceHistoryLength = 10
+
+	// volatilityWeight is how strongly price volatility shortens a card's refresh interval. A card whose price varies
+	// by 20% around its mean is refreshed twice as often as a card of the same price whose price is stable.
+	volatilityWeight = 5.0
+)
+
+// refreshIntervals are how often cards are worth refreshing by market price, most valuable first.
+var refreshIntervals = []struct {
+	minPrice float64
+	interval time.Duration
+}{
+	{minPrice: 100, interval: 6 * time.Hour},
+	{minPrice: 20, interval: 24 * time.Hour},
+	{minPrice: 5, interval: 72 * time.Hour},
+	{minPrice: 0, interval: 7 * 24 * time.Hour},
+}
+
+// RefreshPriority returns how overdue a card is for a refresh, as the number of refresh intervals that have passed
+// since it was last priced. Valuable cards have shorter intervals, and the interval shrinks further the more volatile
+// the card's price has been. Cards that were never priced are the most overdue.
+func RefreshPriority(card models.CardWithPriceInfo, now time.Time) float64 {
+	if card.PricedAt.IsZero() {
+		return math.Inf(1)
+	}
+
+	interval := refreshInterval(highestMarketPrice(card)).Hours() / (1 + volatilityWeight*priceVolatility(card.PriceHistory))
+	return now.Sub(card.PricedAt).Hours() / interval
+}
+
+// MostOverdue returns up to budget cards, most overdue first.
+func MostOverdue(cards []models.CardWithPriceInfo, budget int, now time.Time) []models.CardWithPriceInfo {
+	priorities := make([]float64, len(cards))
+	indexes := make([]int, len(cards))
+	for i, card := range cards {
+		priorities[i] = RefreshPriority(card, now)
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return priorities[indexes[a]] > priorities[indexes[b]]
+	})
+
+	if budget < len(indexes) {
+		indexes = indexes[:budget]
+	}
+
+	overdue := make([]models.CardWithPriceInfo, 0)
+	for _, i := range indexes {
+		overdue = append(overdue, cards[i])
+	}
+	return overdue
+}
+
+func refreshInterval(marketPrice float64) time.Duration {
+	for _, tier := range refreshIntervals {
+		if marketPrice >= tier.minPrice {
+			return tier.interval
+		}
+	}
+	return refreshIntervals[len(refreshIntervals)-1].interval
+}
+
+// priceVolatility returns the coefficient of variation of a card's past market prices, i.e. their standard deviation
+// relative to their mean.
+func priceVolatility(history []models.PricePoint) float64 {
+	prices := make([]float64, 0)
+	for _, point := range history {
+		if point.MarketPrice > 0 {
+			prices = append(prices, point.MarketPrice)
+		}
+	}
+	if len(prices) < 2 {
+		return 0
+	}
+
+	var sum float64
+	for _, price := range prices {
+		sum += price
+	}
+	mean := sum / float64(len(prices))
+
+	var squaredDeviations float64
+	for _, price := range prices {
+		squaredDeviations += (price - mean) * (price - mean)
+	}
+	return math.Sqrt(squaredDeviations/float64(len(prices))) / mean
+}
+
+func highestMarketPrice(card models.CardWithPriceInfo) float64 {
+	var highest float64
+	for _, price := range card.PriceInfo {
+		highest = math.Max(highest, price.MarketPrice)
+	}
+	return highest
+}
+
+func recentPriceHistory(history []models.PricePoint) []models.PricePoint {
+	if len(history) > priceHistoryLength {
+		return history[len(history)-priceHistoryLength:]
+	}
+	return history
+}
@@ -0,0 +1,71 @@
+package processor
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"ygo-card-processor/models"
+)
+
+var now = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func pricedCard(serial string, marketPrice float64, pricedAgo time.Duration, history ...float64) models.CardWithPriceInfo {
+	card := storedCard(serial)
+	card.PriceInfo = []models.PriceResults{{SubTypeName: "1st Edition", MarketPrice: marketPrice}}
+	card.PricedAt = now.Add(-pricedAgo)
+	for _, price := range history {
+		card.PriceHistory = append(card.PriceHistory, models.PricePoint{MarketPrice: price})
+	}
+	return card
+}
+
+func TestProcessor_RefreshPriority_ShouldRankNeverPricedCardsFirst(t *testing.T) {
+	require.True(t, math.IsInf(RefreshPriority(storedCard("test"), now), 1))
+}
+
+func TestProcessor_RefreshPriority_ShouldRefreshValuableCardsMoreOften(t *testing.T) {
+	common := pricedCard("common", 0.10, 24*time.Hour)
+	starlight := pricedCard("starlight", 300, 24*time.Hour)
+
+	require.Equal(t, 4.0, RefreshPriority(starlight, now))
+	require.InDelta(t, 1.0/7, RefreshPriority(common, now), 0.0001)
+}
+
+func TestProcessor_RefreshPriority_ShouldRefreshVolatileCardsMoreOften(t *testing.T) {
+	stable := pricedCard("stable", 10, 72*time.Hour, 10, 10, 10, 10)
+	volatile := pricedCard("volatile", 10, 72*time.Hour, 8, 12, 8, 12)
+
+	require.Equal(t, 1.0, RefreshPriority(stable, now))
+	require.InDelta(t, 2.0, RefreshPriority(volatile, now), 0.0001)
+}
+
+func TestProcessor_MostOverdue_ShouldReturnBudgetMostOverdueCards(t *testing.T) {
+	cards := []models.CardWithPriceInfo{
+		pricedCard("fresh", 300, time.Hour),
+		pricedCard("common", 0.10, 20*24*time.Hour),
+		storedCard("new"),
+		pricedCard("stale", 300, 24*time.Hour),
+	}
+
+	overdue := MostOverdue(cards, 3, now)
+	require.Len(t, overdue, 3)
+	require.Equal(t, "new", overdue[0].CardInfo.Serial())
+	require.Equal(t, "stale", overdue[1].CardInfo.Serial())
+	require.Equal(t, "common", overdue[2].CardInfo.Serial())
+
+	require.Len(t, MostOverdue(cards, 10, now), 4)
+}
+
+func TestProcessor_RecentPriceHistory_ShouldKeepLatestPrices(t *testing.T) {
+	history := make([]models.PricePoint, 0)
+	for i := 0; i < 15; i++ {
+		history = append(history, models.PricePoint{MarketPrice: float64(i)})
+	}
+
+	recent := recentPriceHistory(history)
+	require.Len(t, recent, priceHistoryLength)
+	require.Equal(t, 14.0, recent[len(recent)-1].MarketPrice)
+}
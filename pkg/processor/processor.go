@@ -0,0 +1,23 @@
+package processor
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ygo-card-processor/models"
+)
+
+// CardProcessor runs the jobs that refresh stored cards and import new ones. Each method returns a copy of the job as
+// soon as it has been created; the job itself runs in the background. A refresh budget above zero limits a refresh to
+// that many cards, most overdue first. Running refresh and import jobs can be paused, resumed and cancelled.
+type CardProcessor interface {
+	Refresh(ctx context.Context, filter models.CardFilter, budget int) (*models.Job, error)
+	RefreshAll(ctx context.Context) (*models.Job, error)
+	RefreshSet(ctx context.Context, groupId int) (*models.Job, error)
+	RefreshCard(ctx context.Context, serial string) (*models.Job, error)
+	ImportSerials(ctx context.Context, serials []string) (*models.Job, error)
+	Cancel(ctx context.Context, id primitive.ObjectID) error
+	Pause(ctx context.Context, id primitive.ObjectID) error
+	Resume(ctx context.Context, id primitive.ObjectID) error
+}
@@ -0,0 +1,351 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/events"
+	"ygo-card-processor/pkg/external"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/metrics"
+	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/progress"
+	"ygo-card-processor/pkg/tenant"
+	"ygo-card-processor/pkg/tracing"
+)
+
+// CardDelay is the delay after each card because TCG Player API limits users to 300 API calls per minute. With up to
+// five calls occurring per card, this ensures a maximum of 240 calls per minute.
+const CardDelay = 1250 * time.Millisecond
+
+// ErrRefreshInProgress is returned when a refresh is requested while another one using the same tcgplayer.com
+// credentials is still running. Overlapping refreshes would together exceed the TCG Player API limit of the credentials.
+var ErrRefreshInProgress = errors.New("a refresh is already in progress")
+
+type Processor struct {
+	Handler    dao.DbHandler
+	Retriever  external.ExtRetriever
+	Sink       producer.EventSink
+	PublicKey  string
+	PrivateKey string
+	Delay      time.Duration
+	Progress   *progress.Broadcaster
+
+	mu       sync.Mutex
+	controls map[primitive.ObjectID]*jobControl
+}
+
+func (p *Processor) Refresh(ctx context.Context, filter models.CardFilter, budget int) (*models.Job, error) {
+	return p.refresh(ctx, filter, budget)
+}
+
+func (p *Processor) RefreshAll(ctx context.Context) (*models.Job, error) {
+	return p.refresh(ctx, models.CardFilter{}, 0)
+}
+
+func (p *Processor) RefreshSet(ctx context.Context, groupId int) (*models.Job, error) {
+	return p.refresh(ctx, models.CardFilter{GroupId: groupId}, 0)
+}
+
+func (p *Processor) RefreshCard(ctx context.Context, serial string) (*models.Job, error) {
+	return p.refresh(ctx, models.CardFilter{Serial: serial}, 0)
+}
+
+func (p *Processor) ImportSerials(ctx context.Context, serials []string) (*models.Job, error) {
+	if err := p.refreshToken(ctx); err != nil {
+		return nil, fmt.Errorf("error refreshing token: %w", err)
+	}
+
+	job, release, err := p.startResumableJob(ctx, models.JobTypeImport, serials)
+	if err != nil {
+		return nil, fmt.Errorf("error creating job: %w", err)
+	}
+
+	// The job keeps changing while the import runs, so callers get a copy of it as it started.
+	snapshot := *job
+	control := p.register(job)
+	go func() {
+		defer release()
+		defer p.unregister(job)
+		RunJob(ctx, job, func(ctx context.Context) { p.runImport(ctx, job, control, serials) })
+	}()
+	return &snapshot, nil
+}
+
+func (p *Processor) refresh(ctx context.Context, filter models.CardFilter, budget int) (*models.Job, error) {
+	unlock, err := p.lockRefresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	job, cardList, release, err := p.startRefresh(ctx, filter, budget)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	// The job keeps changing while the refresh runs, so callers get a copy of it as it started.
+	snapshot := *job
+	control := p.register(job)
+	go func() {
+		defer unlock()
+		defer release()
+		defer p.unregister(job)
+		RunJob(ctx, job, func(ctx context.Context) { p.runRefresh(ctx, job, control, cardList) })
+	}()
+	return &snapshot, nil
+}
+
+func (p *Processor) startRefresh(ctx context.Context, filter models.CardFilter, budget int) (*models.Job, []models.CardWithPriceInfo, func(), error) {
+	cardList, err := p.Handler.GetCards(ctx, dao.CardQuery(filter))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error getting cards from database: %w", err)
+	}
+
+	if budget > 0 {
+		cardList = MostOverdue(cardList, budget, time.Now())
+	}
+
+	if err := p.refreshToken(ctx); err != nil {
+		return nil, nil, nil, fmt.Errorf("error refreshing token: %w", err)
+	}
+
+	serials := make([]string, 0)
+	for _, card := range cardList {
+		serials = append(serials, card.CardInfo.Serial())
+	}
+
+	job, release, err := p.startResumableJob(ctx, models.JobTypeRefresh, serials)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error creating job: %w", err)
+	}
+	return job, cardList, release, nil
+}
+
+// runRefresh refreshes the cards of a job, starting from its checkpoint. The checkpoint is saved with every update of
+// the job's counts, so a resumed job neither skips nor recounts a card.
+func (p *Processor) runRefresh(ctx context.Context, job *models.Job, control *jobControl, cardList []models.CardWithPriceInfo) {
+	if job.Checkpoint == 0 {
+		PublishEvent(ctx, p.Sink, events.NewJobStartedEvent(ctx, *job))
+	}
+	for i := job.Checkpoint; i < len(cardList); i++ {
+		if !p.proceed(ctx, job, control) {
+			if control.isLost() {
+				logging.From(ctx).Warn("Lease on job was lost, leaving the job to the replica that claimed it")
+				return
+			}
+			EndJob(ctx, p.Handler, p.Sink, job, models.JobStatusCancelled)
+			p.reportProgress(job, control, "", 0)
+			return
+		}
+
+		card := cardList[i]
+		serial := card.CardInfo.Serial()
+		p.reportProgress(job, control, serial, 0)
+		job.Checkpoint = i + 1
+
+		cardCtx, span := startCard(ctx, serial)
+		processed := p.refreshCard(cardCtx, job, i+1, card)
+		span.End()
+		if processed {
+			logging.From(ctx).Info(fmt.Sprintf("%v out of %v cards processed", job.Processed, len(cardList)))
+			p.wait(job, control)
+		}
+	}
+	FinishJob(ctx, p.Handler, p.Sink, job)
+	p.reportProgress(job, control, "", 0)
+}
+
+// refreshCard refreshes the card in the given row of a job, and reports whether it was processed. Failures are counted
+// on the job instead of stopping it.
+func (p *Processor) refreshCard(ctx context.Context, job *models.Job, row int, card models.CardWithPriceInfo) bool {
+	serial := card.CardInfo.Serial()
+
+	/* Cards added before disambiguation existed have no product ID stored, so those still have to be looked up by
+	serial number. */
+	productId := card.CardInfo.ProductId
+	if productId == 0 {
+		var candidates []models.ProductCandidate
+		var err error
+		productId, candidates, err = FindProduct(ctx, p.Retriever, serial)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error("Error performing basic card search")
+			p.failCard(ctx, job, err)
+			return false
+		}
+		if productId == 0 {
+			recordUnresolvedSerial(ctx, job, row, serial, candidates)
+			SaveJob(ctx, p.Handler, job)
+			return false
+		}
+	}
+	tracing.Annotate(ctx, attribute.Int("card.product_id", productId))
+	ctx = logging.WithField(ctx, logging.FieldProductId, productId)
+
+	cardInfoWithPrice, err := GetCardWithPriceInfo(ctx, p.Retriever, productId)
+	if err != nil {
+		logging.From(ctx).WithError(err).Error("Error retrieving card information")
+		p.failCard(ctx, job, err)
+		return false
+	}
+
+	cardInfoWithPrice.Condition = card.Condition
+	cardInfoWithPrice.Printing = card.Printing
+	cardInfoWithPrice.Language = card.Language
+	cardInfoWithPrice.PriceHistory = recentPriceHistory(append(card.PriceHistory, cardInfoWithPrice.PriceHistory...))
+
+	// Catalog data only changes when tcgplayer.com bumps the product's modifiedOn, so usually only prices are written.
+	catalogChanged := CatalogChanged(card.CardInfo, cardInfoWithPrice.CardInfo)
+	if catalogChanged {
+		_, err = p.Handler.UpdateCardByNumber(ctx, serial, *cardInfoWithPrice)
+	} else {
+		_, err = p.Handler.UpdateCardPrices(ctx, serial, *cardInfoWithPrice)
+	}
+	if err != nil {
+		logging.From(ctx).WithError(err).Error("Error updating card")
+		p.failCard(ctx, job, err)
+		return false
+	}
+
+	if catalogChanged {
+		job.CatalogChanged++
+		countCard(ctx, job, metrics.CardCatalogChanged)
+	} else {
+		job.PriceOnly++
+		countCard(ctx, job, metrics.CardPriceOnly)
+	}
+	job.Processed++
+	SaveJob(ctx, p.Handler, job)
+	return true
+}
+
+// runImport adds the cards of a job, starting from its checkpoint.
+func (p *Processor) runImport(ctx context.Context, job *models.Job, control *jobControl, serials []string) {
+	if job.Checkpoint == 0 {
+		PublishEvent(ctx, p.Sink, events.NewJobStartedEvent(ctx, *job))
+	}
+	for i := job.Checkpoint; i < len(serials); i++ {
+		if !p.proceed(ctx, job, control) {
+			if control.isLost() {
+				logging.From(ctx).Warn("Lease on job was lost, leaving the job to the replica that claimed it")
+				return
+			}
+			EndJob(ctx, p.Handler, p.Sink, job, models.JobStatusCancelled)
+			p.reportProgress(job, control, "", 0)
+			return
+		}
+
+		serial := serials[i]
+		p.reportProgress(job, control, serial, 0)
+		job.Checkpoint = i + 1
+
+		cardCtx, span := startCard(ctx, serial)
+		added := p.importCard(cardCtx, job, i+1, serial)
+		span.End()
+		if added {
+			logging.From(ctx).Info(fmt.Sprintf("%v out of %v cards added", job.Processed, len(serials)))
+			p.wait(job, control)
+		}
+	}
+	FinishJob(ctx, p.Handler, p.Sink, job)
+	p.reportProgress(job, control, "", 0)
+}
+
+// importCard adds the card with the serial number in the given row of a job, and reports whether it was added.
+func (p *Processor) importCard(ctx context.Context, job *models.Job, row int, serial string) bool {
+	productId, candidates, err := FindProduct(ctx, p.Retriever, serial)
+	if err != nil {
+		logging.From(ctx).WithError(err).Error("Error performing basic card search")
+		p.failCard(ctx, job, err)
+		return false
+	}
+	if productId == 0 {
+		recordUnresolvedSerial(ctx, job, row, serial, candidates)
+		SaveJob(ctx, p.Handler, job)
+		return false
+	}
+	tracing.Annotate(ctx, attribute.Int("card.product_id", productId))
+	ctx = logging.WithField(ctx, logging.FieldProductId, productId)
+
+	cardInfoWithPrice, err := GetCardWithPriceInfo(ctx, p.Retriever, productId)
+	if err != nil {
+		logging.From(ctx).WithError(err).Error("Error retrieving card information")
+		p.failCard(ctx, job, err)
+		return false
+	}
+
+	if _, err := p.Handler.AddCard(ctx, *cardInfoWithPrice); err != nil {
+		logging.From(ctx).WithError(err).Error("Error adding card to database")
+		p.failCard(ctx, job, err)
+		return false
+	}
+	job.Processed++
+	countCard(ctx, job, metrics.CardAdded)
+	SaveJob(ctx, p.Handler, job)
+	return true
+}
+
+// failCard counts a card of a job that could not be processed because of err.
+func (p *Processor) failCard(ctx context.Context, job *models.Job, err error) {
+	job.Failed++
+	countCard(ctx, job, metrics.CardFailed)
+	tracing.RecordError(trace.SpanFromContext(ctx), err)
+	SaveJob(ctx, p.Handler, job)
+}
+
+// wait delays the next card to stay within the TCG Player API limit, ending early if the job is cancelled.
+func (p *Processor) wait(job *models.Job, control *jobControl) {
+	p.reportProgress(job, control, "", p.Delay)
+	start := time.Now()
+	defer func() { metrics.TcgplayerWait.Observe(metrics.Since(start)) }()
+	select {
+	case <-time.After(p.Delay):
+	case <-control.cancelled:
+	}
+}
+
+// CatalogChanged reports whether a card's catalog data has to be rewritten, which is the case unless the stored card
+// came from the same product and tcgplayer.com has not modified that product since.
+func CatalogChanged(stored models.Card, retrieved models.Card) bool {
+	return stored.ModifiedOn == "" || stored.ProductId != retrieved.ProductId || stored.ModifiedOn != retrieved.ModifiedOn
+}
+
+// RunJob runs a job in the background under a root span of its own, linked to the span of the request or command that
+// started or resumed it.
+func RunJob(ctx context.Context, job *models.Job, run func(ctx context.Context)) {
+	jobCtx, span := tracing.Start(detach(ctx, job), "job "+job.Type, trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(
+			attribute.String("job.id", job.Id.Hex()),
+			attribute.String("job.type", job.Type),
+			attribute.String("collection", job.CollectionId),
+		))
+	defer span.End()
+	run(jobCtx)
+}
+
+// startCard starts the span of a card worked through by the job running in ctx, and returns a context whose logger
+// adds the card's serial number.
+func startCard(ctx context.Context, serial string) (context.Context, trace.Span) {
+	return tracing.Start(logging.WithField(ctx, logging.FieldSerial, serial), "card", trace.WithAttributes(attribute.String("card.serial", serial)))
+}
+
+// detach returns a context for running a job in the background. Jobs outlive the request or command that started
+// them, so only the job's collection, the correlation ID and the fields of the caller's logger are carried over rather
+// than the caller's cancellation. The job's logger adds the job ID.
+func detach(ctx context.Context, job *models.Job) context.Context {
+	jobCtx := tenant.WithCollection(context.Background(), tenant.CollectionId(ctx))
+	jobCtx = logging.WithLogger(jobCtx, logging.From(ctx).WithField(logging.FieldJobId, job.Id.Hex()))
+	if correlationId := events.CorrelationId(ctx); correlationId != "" {
+		jobCtx = events.WithCorrelationId(jobCtx, correlationId)
+	}
+	return events.WithJobId(jobCtx, job.Id.Hex())
+}
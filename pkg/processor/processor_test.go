@@ -0,0 +1,430 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/events"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/producer"
+	"ygo-card-processor/pkg/tenant"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+func storedCard(serial string) models.CardWithPriceInfo {
+	return models.CardWithPriceInfo{
+		CardInfo:  models.Card{ExtendedData: []models.ExtendedData{{Value: serial}}},
+		PriceInfo: []models.PriceResults{},
+	}
+}
+
+func mockPricedProduct(retriever *mocks.ExtRetriever) {
+	retriever.On("ExtendedCardSearch", mock.Anything, mock.Anything).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{{ExtendedData: []models.ExtendedData{{Value: "test"}}}},
+	}, nil)
+	retriever.On("GetCardPricingInfo", mock.Anything, mock.Anything).Return(&models.PriceResponse{
+		Results: []models.PriceResults{{MarketPrice: 3.00}},
+	}, nil)
+	retriever.On("GetProductSkus", mock.Anything, mock.Anything).Return(&models.SkuResponse{
+		Results: []models.Sku{},
+	}, nil)
+}
+
+func newJob() *models.Job {
+	return &models.Job{Id: primitive.NewObjectID(), Status: models.JobStatusRunning}
+}
+
+// mockLocks has dbHandler hold locks in memory, the way the database would for a single processor.
+func mockLocks(dbHandler *mocks.DbHandler) {
+	var mu sync.Mutex
+	owners := map[string]string{}
+	dbHandler.On("AcquireLock", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, name, owner string, _ time.Duration) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			if current, ok := owners[name]; ok && current != owner {
+				return false
+			}
+			owners[name] = owner
+			return true
+		}, nil).Maybe()
+	dbHandler.On("ReleaseLock", mock.Anything, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, name, owner string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if owners[name] == owner {
+				delete(owners, name)
+			}
+			return nil
+		}).Maybe()
+}
+
+func TestProcessor_RefreshAll_ShouldReturnErrorIfUnableToRetrieveCardsFromDatabase(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	mockLocks(dbHandler)
+	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	p := Processor{Handler: dbHandler, Retriever: &mocks.ExtRetriever{}, Sink: &producer.MemorySink{}}
+	_, err := p.RefreshAll(context.Background())
+	require.NotNil(t, err)
+}
+
+func TestProcessor_RefreshAll_ShouldReturnErrorIfUnableToRefreshToken(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	mockLocks(dbHandler)
+	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{}, nil)
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("test"))
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	_, err := p.RefreshAll(context.Background())
+	require.NotNil(t, err)
+	dbHandler.AssertNotCalled(t, "AddJob", mock.Anything, mock.Anything)
+}
+
+func TestProcessor_RefreshSet_ShouldOnlyRefreshCardsOfSet(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	mockLocks(dbHandler)
+	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{}, nil)
+	dbHandler.On("AddJob", mock.Anything, mock.Anything).Return(primitive.NewObjectID(), nil)
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	sink := &producer.MemorySink{}
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: sink}
+	job, err := p.RefreshSet(context.Background(), 123)
+	require.Nil(t, err)
+	require.Equal(t, models.JobTypeRefresh, job.Type)
+	dbHandler.AssertCalled(t, "GetCards", mock.Anything, map[string]interface{}(bson.M{"card.groupId": 123}))
+}
+
+func TestProcessor_RunRefresh_ShouldCountFailureIfBasicCardSearchFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	sink := &producer.MemorySink{}
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: sink}
+	job := newJob()
+	p.runRefresh(context.Background(), job, newJobControl(), []models.CardWithPriceInfo{storedCard("test")})
+	require.Equal(t, 1, job.Failed)
+	require.Equal(t, models.JobStatusFinished, job.Status)
+	dbHandler.AssertNotCalled(t, "UpdateCardByNumber", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessor_RunRefresh_ShouldCountFailureIfPricingSearchFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{Results: []int{123}}, nil)
+	retriever.On("ExtendedCardSearch", mock.Anything, mock.Anything).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{{}},
+	}, nil)
+	retriever.On("GetCardPricingInfo", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	job := newJob()
+	p.runRefresh(context.Background(), job, newJobControl(), []models.CardWithPriceInfo{storedCard("test")})
+	require.Equal(t, 1, job.Failed)
+	dbHandler.AssertNotCalled(t, "UpdateCardByNumber", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessor_RunRefresh_ShouldCountFailureIfUpdateFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+	dbHandler.On("UpdateCardByNumber", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{Results: []int{123}}, nil)
+	mockPricedProduct(retriever)
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	job := newJob()
+	p.runRefresh(context.Background(), job, newJobControl(), []models.CardWithPriceInfo{storedCard("test")})
+	require.Equal(t, 1, job.Failed)
+	require.Equal(t, 0, job.Processed)
+}
+
+func TestProcessor_RunRefresh_ShouldUpdateCardKeepingOwnershipAndPublishJobEvents(t *testing.T) {
+	card := storedCard("test")
+	card.CardInfo.ProductId = 123
+	card.Condition = "Near Mint"
+	card.PriceHistory = []models.PricePoint{{MarketPrice: 2.00}}
+
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+	dbHandler.On("UpdateCardByNumber", mock.Anything, "test", mock.MatchedBy(func(updated models.CardWithPriceInfo) bool {
+		return updated.Condition == "Near Mint" && len(updated.PriceHistory) == 2 && updated.PriceHistory[1].MarketPrice == 3.00
+	})).Return(nil, nil)
+
+	retriever := &mocks.ExtRetriever{}
+	mockPricedProduct(retriever)
+
+	sink := &producer.MemorySink{}
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: sink}
+	job := newJob()
+	p.runRefresh(events.WithCorrelationId(context.Background(), "command"), job, newJobControl(), []models.CardWithPriceInfo{card})
+	require.Equal(t, 1, job.Processed)
+	require.Equal(t, 1, job.CatalogChanged)
+	retriever.AssertNotCalled(t, "BasicCardSearch", mock.Anything, mock.Anything)
+
+	published := sink.Events()
+	require.Len(t, published, 2)
+	require.Equal(t, models.EventJobStarted, published[0].Type)
+	require.Equal(t, models.EventJobFinished, published[1].Type)
+	require.Equal(t, "command", published[1].CorrelationId)
+	require.Equal(t, 1, published[1].Job.Processed)
+	require.Empty(t, sink.Logs())
+}
+
+func TestProcessor_RunRefresh_ShouldOnlyUpdatePricesIfCatalogUnmodified(t *testing.T) {
+	card := storedCard("test")
+	card.CardInfo.ProductId = 123
+	card.CardInfo.ModifiedOn = "2021-01-01T00:00:00"
+
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+	dbHandler.On("UpdateCardPrices", mock.Anything, "test", mock.Anything).Return(nil, nil)
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("ExtendedCardSearch", mock.Anything, mock.Anything).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{{ProductId: 123, ExtendedData: []models.ExtendedData{{Value: "test"}}, ModifiedOn: "2021-01-01T00:00:00"}},
+	}, nil)
+	mockPricedProduct(retriever)
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	job := newJob()
+	p.runRefresh(context.Background(), job, newJobControl(), []models.CardWithPriceInfo{card})
+	require.Equal(t, 1, job.Processed)
+	require.Equal(t, 1, job.PriceOnly)
+	require.Equal(t, 0, job.CatalogChanged)
+	dbHandler.AssertNotCalled(t, "UpdateCardByNumber", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessor_CatalogChanged_ShouldCompareProductAndModifiedOn(t *testing.T) {
+	stored := models.Card{ProductId: 123, ModifiedOn: "2021-01-01T00:00:00"}
+	require.False(t, CatalogChanged(stored, models.Card{ProductId: 123, ModifiedOn: "2021-01-01T00:00:00"}))
+	require.True(t, CatalogChanged(stored, models.Card{ProductId: 123, ModifiedOn: "2021-02-01T00:00:00"}))
+	require.True(t, CatalogChanged(stored, models.Card{ProductId: 456, ModifiedOn: "2021-01-01T00:00:00"}))
+	require.True(t, CatalogChanged(models.Card{ProductId: 123}, models.Card{ProductId: 123}))
+}
+
+func TestProcessor_ImportSerials_ShouldReturnCopyOfRunningJob(t *testing.T) {
+	finished := make(chan struct{})
+	dbHandler := &mocks.DbHandler{}
+	mockLocks(dbHandler)
+	dbHandler.On("AddJob", mock.Anything, mock.Anything).Return(primitive.NewObjectID(), nil)
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		if args.Get(1).(models.Job).Status == models.JobStatusFinished {
+			close(finished)
+		}
+	})
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	job, err := p.ImportSerials(context.Background(), []string{"LOB-001", "LOB-002", "LOB-003", "LOB-004", "LOB-005"})
+	require.Nil(t, err)
+
+	// Reading the returned job while the import updates its own is only safe if it is a copy.
+	require.Equal(t, 0, job.Failed)
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("import did not finish")
+	}
+	require.Equal(t, 0, job.Failed)
+	require.Equal(t, models.JobStatusRunning, job.Status)
+}
+
+func TestProcessor_RunImport_ShouldAddCard(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+	dbHandler.On("AddCard", mock.Anything, mock.Anything).Return(nil, nil)
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{Results: []int{123}}, nil)
+	mockPricedProduct(retriever)
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	job := newJob()
+	p.runImport(context.Background(), job, newJobControl(), []string{"TEST"})
+	require.Equal(t, 1, job.Processed)
+	require.Equal(t, 0, job.Failed)
+}
+
+func TestProcessor_RunImport_ShouldCountFailureIfAddFails(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+	dbHandler.On("AddCard", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{Results: []int{123}}, nil)
+	mockPricedProduct(retriever)
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	job := newJob()
+	p.runImport(context.Background(), job, newJobControl(), []string{"TEST"})
+	require.Equal(t, 0, job.Processed)
+	require.Equal(t, 1, job.Failed)
+}
+
+func TestProcessor_RunImport_ShouldLogWithRequestJobAndCard(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+	dbHandler.On("AddCard", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(&models.SearchResponse{Results: []int{123}}, nil)
+	mockPricedProduct(retriever)
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	job := newJob()
+	ctx := detach(logging.WithRequestId(context.Background(), "request"), job)
+	p.runImport(ctx, job, newJobControl(), []string{"TEST"})
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	require.Equal(t, "Error adding card to database", entry.Message)
+	require.Equal(t, "request", entry.Data[logging.FieldRequestId])
+	require.Equal(t, job.Id.Hex(), entry.Data[logging.FieldJobId])
+	require.Equal(t, "TEST", entry.Data[logging.FieldSerial])
+	require.Equal(t, 123, entry.Data[logging.FieldProductId])
+}
+
+func TestProcessor_RunImport_ShouldRecordAmbiguousSerialsOnJobInsteadOfAddingCard(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("BasicCardSearch", mock.Anything, "AMBIGUOUS").Return(&models.SearchResponse{
+		Results: []int{123, 456},
+	}, nil)
+	retriever.On("BasicCardSearch", mock.Anything, "MISSING").Return(&models.SearchResponse{
+		Results: []int{},
+	}, nil)
+	retriever.On("ExtendedCardSearchMultiple", mock.Anything, []int{123, 456}).Return(&models.ExtendedSearchResponse{
+		Results: []models.Card{{ProductId: 123}, {ProductId: 456}},
+	}, nil)
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	job := newJob()
+	p.runImport(context.Background(), job, newJobControl(), []string{"AMBIGUOUS", "MISSING"})
+	require.Len(t, job.Ambiguous, 1)
+	require.Equal(t, 1, job.Ambiguous[0].Row)
+	require.Len(t, job.Ambiguous[0].Candidates, 2)
+	require.Len(t, job.NotFound, 1)
+	require.Equal(t, "MISSING", job.NotFound[0].Serial)
+	require.Equal(t, 0, job.Processed)
+	dbHandler.AssertNotCalled(t, "AddCard", mock.Anything, mock.Anything)
+}
+
+func TestProcessor_Refresh_ShouldRejectOverlappingRefreshes(t *testing.T) {
+	release := make(chan struct{})
+	dbHandler := &mocks.DbHandler{}
+	mockLocks(dbHandler)
+	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{storedCard("test")}, nil)
+	dbHandler.On("AddJob", mock.Anything, mock.Anything).Return(primitive.NewObjectID(), nil)
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(nil, errors.New("test")).Run(func(mock.Arguments) {
+		<-release
+	})
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	_, err := p.Refresh(context.Background(), models.CardFilter{}, 0)
+	require.Nil(t, err)
+
+	_, err = p.RefreshCard(context.Background(), "test")
+	require.Equal(t, ErrRefreshInProgress, err)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		_, err := p.Refresh(context.Background(), models.CardFilter{}, 0)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestProcessor_Refresh_ShouldOnlyRejectOverlappingRefreshesWithSameCredentials(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	dbHandler := &mocks.DbHandler{}
+	mockLocks(dbHandler)
+	dbHandler.On("GetCollection", mock.Anything, "binder").Return(&models.Collection{
+		Id:        "binder",
+		Tcgplayer: &models.TcgplayerCredentials{PublicKey: "binder-public", PrivateKey: "binder-private"},
+	}, nil)
+	dbHandler.On("GetCollection", mock.Anything, "shared").Return(&models.Collection{Id: "shared"}, nil)
+	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{storedCard("test")}, nil)
+	dbHandler.On("AddJob", mock.Anything, mock.Anything).Return(primitive.NewObjectID(), nil)
+	dbHandler.On("UpdateJob", mock.Anything, mock.Anything).Return(nil)
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	retriever.On("BasicCardSearch", mock.Anything, mock.Anything).Return(nil, errors.New("test")).Run(func(mock.Arguments) {
+		<-release
+	})
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}, PublicKey: "public", PrivateKey: "private"}
+	_, err := p.RefreshAll(context.Background())
+	require.Nil(t, err)
+
+	_, err = p.RefreshAll(tenant.WithCollection(context.Background(), "binder"))
+	require.Nil(t, err)
+	_, err = p.RefreshAll(tenant.WithCollection(context.Background(), "shared"))
+	require.Equal(t, ErrRefreshInProgress, err)
+}
+
+func TestProcessor_Refresh_ShouldOnlyRefreshMostOverdueCardsWithinBudget(t *testing.T) {
+	fresh := storedCard("fresh")
+	fresh.PricedAt = time.Now()
+	dbHandler := &mocks.DbHandler{}
+	mockLocks(dbHandler)
+	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return([]models.CardWithPriceInfo{fresh, storedCard("new")}, nil)
+	dbHandler.On("AddJob", mock.Anything, mock.Anything).Return(primitive.NewObjectID(), nil)
+
+	retriever := &mocks.ExtRetriever{}
+	retriever.On("RefreshToken", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	p := Processor{Handler: dbHandler, Retriever: retriever, Sink: &producer.MemorySink{}}
+	job, cardList, release, err := p.startRefresh(context.Background(), models.CardFilter{}, 1)
+	require.Nil(t, err)
+	defer release()
+	require.Equal(t, 1, job.Total)
+	require.Len(t, cardList, 1)
+	require.Equal(t, "new", cardList[0].CardInfo.Serial())
+}
+
+func TestProcessor_Refresh_ShouldAllowRefreshAfterFailedStart(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	mockLocks(dbHandler)
+	dbHandler.On("GetCards", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	p := Processor{Handler: dbHandler, Retriever: &mocks.ExtRetriever{}, Sink: &producer.MemorySink{}}
+	_, err := p.RefreshAll(context.Background())
+	require.NotEqual(t, ErrRefreshInProgress, err)
+	_, err = p.RefreshAll(context.Background())
+	require.NotEqual(t, ErrRefreshInProgress, err)
+}
@@ -0,0 +1,147 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/external"
+)
+
+// FindProduct resolves a serial number to a TCGplayer product ID. Reprints and alternate arts can share a serial
+// number, so when the search does not return exactly one product the returned ID is 0 and the matching products are
+// returned as candidates for the user to choose from instead.
+func FindProduct(ctx context.Context, retriever external.ExtRetriever, serial string) (int, []models.ProductCandidate, error) {
+	basicCardInfo, err := retriever.BasicCardSearch(ctx, serial)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(basicCardInfo.Results) == 1 {
+		return basicCardInfo.Results[0], nil, nil
+	}
+
+	candidates := make([]models.ProductCandidate, 0)
+	if len(basicCardInfo.Results) == 0 {
+		return 0, candidates, nil
+	}
+
+	// The candidates are retrieved in a single request, however many products share the serial number.
+	extendedCardInfo, err := retriever.ExtendedCardSearchMultiple(ctx, basicCardInfo.Results)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, card := range extendedCardInfo.Results {
+		candidates = append(candidates, ToProductCandidate(card))
+	}
+
+	return 0, candidates, nil
+}
+
+func ToProductCandidate(card models.Card) models.ProductCandidate {
+	candidate := models.ProductCandidate{
+		ProductId: card.ProductId,
+		Name:      card.Name,
+		GroupId:   card.GroupId,
+		ImageUrl:  card.ImageUrl,
+	}
+	for _, data := range card.ExtendedData {
+		switch data.Name {
+		case "Number":
+			candidate.Number = data.Value
+		case "Rarity":
+			candidate.Rarity = data.Value
+		}
+	}
+	return candidate
+}
+
+// GetCardWithPriceInfo retrieves the catalog and pricing information of a single product.
+func GetCardWithPriceInfo(ctx context.Context, retriever external.ExtRetriever, productId int) (*models.CardWithPriceInfo, error) {
+	extendedCardInfo, err := retriever.ExtendedCardSearch(ctx, productId)
+	if err != nil {
+		return nil, fmt.Errorf("error performing extended card search: %w", err)
+	}
+	if len(extendedCardInfo.Results) == 0 {
+		return nil, fmt.Errorf("no product found with ID %v", productId)
+	}
+	cardInfo := extendedCardInfo.Results[0]
+
+	cardPricingInfo, err := retriever.GetCardPricingInfo(ctx, productId)
+	if err != nil {
+		return nil, fmt.Errorf("error performing card price search: %w", err)
+	}
+
+	/* Rows without a market price are kept since cards without recent sales still have valid low, mid, high and
+	direct low prices. They are flagged instead, so valuations can tell a missing market price from a worthless card. */
+	noMarketData := true
+	priceResults := make([]models.PriceResults, 0)
+	for _, price := range cardPricingInfo.Results {
+		price.MissingMarketPrice = price.MarketPrice == 0.0
+		noMarketData = noMarketData && price.MissingMarketPrice
+		priceResults = append(priceResults, price)
+	}
+
+	skuPrices, err := getSkuPrices(ctx, retriever, productId)
+	if err != nil {
+		return nil, err
+	}
+	for _, sku := range skuPrices {
+		noMarketData = noMarketData && sku.MissingMarketPrice
+	}
+
+	card := models.CardWithPriceInfo{
+		CardInfo:     cardInfo,
+		PriceInfo:    priceResults,
+		SkuPriceInfo: skuPrices,
+		PricedAt:     time.Now(),
+		PriceSource:  models.PriceSourceTcgplayer,
+		NoMarketData: noMarketData,
+	}
+	card.PriceHistory = []models.PricePoint{{At: card.PricedAt, MarketPrice: highestMarketPrice(card)}}
+	return &card, nil
+}
+
+// getSkuPrices retrieves the prices of every condition, printing and language a product is sold in.
+func getSkuPrices(ctx context.Context, retriever external.ExtRetriever, productId int) ([]models.SkuPrice, error) {
+	skuInfo, err := retriever.GetProductSkus(ctx, productId)
+	if err != nil {
+		return nil, fmt.Errorf("error performing sku search: %w", err)
+	}
+
+	skuPrices := make([]models.SkuPrice, 0)
+	if len(skuInfo.Results) == 0 {
+		return skuPrices, nil
+	}
+
+	skuIds := make([]int, 0)
+	for _, sku := range skuInfo.Results {
+		skuIds = append(skuIds, sku.SkuId)
+	}
+
+	skuPricingInfo, err := retriever.GetSkuPricingInfo(ctx, skuIds)
+	if err != nil {
+		return nil, fmt.Errorf("error performing sku price search: %w", err)
+	}
+
+	pricesBySku := make(map[int]models.SkuPriceResults)
+	for _, price := range skuPricingInfo.Results {
+		pricesBySku[price.SkuId] = price
+	}
+
+	for _, sku := range skuInfo.Results {
+		price := pricesBySku[sku.SkuId]
+		skuPrices = append(skuPrices, models.SkuPrice{
+			SkuId:              sku.SkuId,
+			Condition:          sku.Condition,
+			Printing:           sku.Printing,
+			Language:           sku.Language,
+			LowPrice:           price.LowPrice,
+			MarketPrice:        price.MarketPrice,
+			DirectLowPrice:     price.DirectLowPrice,
+			MissingMarketPrice: price.MarketPrice == 0.0,
+		})
+	}
+	return skuPrices, nil
+}
@@ -0,0 +1,63 @@
+package producer
+
+import (
+	"context"
+
+	"ygo-card-processor/models"
+)
+
+// FanOutSink produces every event to each of several sinks.
+type FanOutSink struct {
+	Sinks []EventSink
+}
+
+func (s *FanOutSink) Produce(ctx context.Context, event string, message string, isError bool) {
+	for _, sink := range s.Sinks {
+		sink.Produce(ctx, event, message, isError)
+	}
+}
+
+// ProduceAndWait produces an event to every sink, returning the first error encountered.
+func (s *FanOutSink) ProduceAndWait(ctx context.Context, event string, message string, isError bool) error {
+	var firstErr error
+	for _, sink := range s.Sinks {
+		if err := sink.ProduceAndWait(ctx, event, message, isError); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Publish publishes a domain event to every sink, returning the first error encountered.
+func (s *FanOutSink) Publish(ctx context.Context, event models.DomainEvent) error {
+	var firstErr error
+	for _, sink := range s.Sinks {
+		if err := sink.Publish(ctx, event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stats sums the delivery counts of every sink.
+func (s *FanOutSink) Stats() DeliveryStats {
+	var stats DeliveryStats
+	for _, sink := range s.Sinks {
+		sinkStats := sink.Stats()
+		stats.Delivered += sinkStats.Delivered
+		stats.Failed += sinkStats.Failed
+		stats.Retried += sinkStats.Retried
+	}
+	return stats
+}
+
+// Close closes every sink, returning the first error encountered.
+func (s *FanOutSink) Close() error {
+	var firstErr error
+	for _, sink := range s.Sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,34 @@
+package producer
+
+import "github.com/confluentinc/confluent-kafka-go/kafka"
+
+// KafkaHeaders carries a trace context in the headers of a Kafka message, for injecting and extracting it with a
+// propagator.
+type KafkaHeaders []kafka.Header
+
+func (h *KafkaHeaders) Get(key string) string {
+	for _, header := range *h {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (h *KafkaHeaders) Set(key string, value string) {
+	for i, header := range *h {
+		if header.Key == key {
+			(*h)[i].Value = []byte(value)
+			return
+		}
+	}
+	*h = append(*h, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (h *KafkaHeaders) Keys() []string {
+	keys := make([]string, 0, len(*h))
+	for _, header := range *h {
+		keys = append(keys, header.Key)
+	}
+	return keys
+}
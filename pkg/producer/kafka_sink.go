@@ -0,0 +1,264 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/events"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/metrics"
+)
+
+const (
+	defaultMaxRetries      = 3
+	defaultDeliveryTimeout = 30 * time.Second
+	retryBackoff           = 500 * time.Millisecond
+	flushTimeout           = 10 * time.Second
+	deliveryChannelSize    = 1000
+	cloudEventsContentType = "application/cloudevents+json; charset=UTF-8"
+)
+
+// ErrProducerClosed is returned when producing a message after the producer was closed, such as a retry whose backoff
+// outlasted flushing the producer.
+var ErrProducerClosed = errors.New("kafka producer is closed")
+
+type Producer struct {
+	Producer        *kafka.Producer
+	Topic           string
+	DeliveryChannel chan kafka.Event
+	MaxRetries      int
+	DeliveryTimeout time.Duration
+	Format          string
+
+	delivered      uint64
+	failed         uint64
+	retried        uint64
+	pendingRetries int64
+	closing        int32
+	done           chan struct{}
+
+	// mu keeps messages from being produced while or after the Kafka producer is closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// delivery is attached to each message as its opaque value, so the delivery report handler knows how often the
+// message was attempted and whom to tell about the outcome.
+type delivery struct {
+	attempts int
+	result   chan error
+	logger   *logrus.Entry
+}
+
+func CreateProducer(broker string, topic string, format string) (*Producer, error) {
+	return createProducer(&kafka.ConfigMap{
+		"bootstrap.servers": broker,
+	}, topic, format)
+}
+
+func createProducer(config *kafka.ConfigMap, topic string, format string) (*Producer, error) {
+	p, err := kafka.NewProducer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	producer := Producer{
+		Producer:        p,
+		Topic:           topic,
+		DeliveryChannel: make(chan kafka.Event, deliveryChannelSize),
+		MaxRetries:      defaultMaxRetries,
+		DeliveryTimeout: defaultDeliveryTimeout,
+		Format:          format,
+		done:            make(chan struct{}),
+	}
+	go producer.handleDeliveryReports()
+
+	return &producer, nil
+}
+
+func (p *Producer) Produce(ctx context.Context, event string, message string, isError bool) {
+	bytes, err := json.Marshal(newLog(event, message, isError))
+	if err != nil {
+		logging.From(ctx).WithError(err).Error("Error creating log")
+		return
+	}
+
+	if err := p.produce(bytes, traceHeaders(ctx), &delivery{logger: logging.From(ctx)}); err != nil {
+		p.count(&p.failed, "failed")
+		logging.From(ctx).WithError(err).Error("Error producing kafka message")
+	}
+}
+
+// ProduceAndWait produces an event and blocks until Kafka has acknowledged it, retries included, or the delivery
+// timeout has passed.
+func (p *Producer) ProduceAndWait(ctx context.Context, event string, message string, isError bool) error {
+	bytes, err := json.Marshal(newLog(event, message, isError))
+	if err != nil {
+		return err
+	}
+
+	return p.produceAndWait(ctx, bytes, traceHeaders(ctx))
+}
+
+// Publish produces a domain event in the producer's format and waits for Kafka to acknowledge it. CloudEvents are
+// marked with a content-type header, as structured mode requires. Like every message, it carries the trace context of
+// ctx in a traceparent header.
+func (p *Producer) Publish(ctx context.Context, event models.DomainEvent) error {
+	bytes, err := events.Encode(p.Format, event)
+	if err != nil {
+		return err
+	}
+
+	headers := traceHeaders(ctx)
+	if p.Format == events.FormatCloudEvents {
+		headers = append(headers, kafka.Header{Key: "content-type", Value: []byte(cloudEventsContentType)})
+	}
+	return p.produceAndWait(ctx, bytes, headers)
+}
+
+func (p *Producer) produceAndWait(ctx context.Context, message []byte, headers []kafka.Header) error {
+	result := make(chan error, 1)
+	if err := p.produce(message, headers, &delivery{result: result, logger: logging.From(ctx)}); err != nil {
+		p.count(&p.failed, "failed")
+		return err
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(p.DeliveryTimeout):
+		return errors.New("timed out waiting for kafka delivery report")
+	}
+}
+
+func (p *Producer) Stats() DeliveryStats {
+	return DeliveryStats{
+		Delivered: atomic.LoadUint64(&p.delivered),
+		Failed:    atomic.LoadUint64(&p.failed),
+		Retried:   atomic.LoadUint64(&p.retried),
+	}
+}
+
+// Close flushes outstanding messages, including messages waiting to be retried, before closing the producer. Retries
+// still waiting once the flush times out fail with ErrProducerClosed.
+func (p *Producer) Close() error {
+	atomic.StoreInt32(&p.closing, 1)
+
+	deadline := time.Now().Add(flushTimeout)
+	remaining := p.Producer.Flush(100)
+	for (remaining > 0 || atomic.LoadInt64(&p.pendingRetries) > 0) && time.Now().Before(deadline) {
+		remaining = p.Producer.Flush(100)
+	}
+
+	p.mu.Lock()
+	p.closed = true
+	p.Producer.Close()
+	p.mu.Unlock()
+	close(p.done)
+
+	remaining += int(atomic.LoadInt64(&p.pendingRetries))
+
+	if remaining > 0 {
+		return fmt.Errorf("%v kafka messages were not delivered before closing", remaining)
+	}
+	return nil
+}
+
+func (p *Producer) produce(message []byte, headers []kafka.Header, d *delivery) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrProducerClosed
+	}
+
+	d.attempts++
+	kMessage := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &p.Topic, Partition: kafka.PartitionAny},
+		Value:          message,
+		Headers:        headers,
+		Opaque:         d,
+	}
+
+	return p.Producer.Produce(kMessage, p.DeliveryChannel)
+}
+
+// handleDeliveryReports consumes delivery reports and other producer events until the producer is closed. Kafka blocks
+// producing once these channels are full, so they have to be read continuously.
+func (p *Producer) handleDeliveryReports() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case e := <-p.DeliveryChannel:
+			if message, ok := e.(*kafka.Message); ok {
+				p.handleDeliveryReport(message)
+			}
+		case e := <-p.Producer.Events():
+			// Events that aren't delivery reports, such as the broker being unreachable, only need to be logged.
+			if kafkaErr, ok := e.(kafka.Error); ok {
+				logrus.WithError(kafkaErr).Warn("Kafka producer error")
+			}
+		}
+	}
+}
+
+func (p *Producer) handleDeliveryReport(message *kafka.Message) {
+	d, ok := message.Opaque.(*delivery)
+	if !ok {
+		d = &delivery{attempts: 1, logger: logrus.NewEntry(logrus.StandardLogger())}
+	}
+
+	if message.TopicPartition.Error == nil {
+		p.count(&p.delivered, "delivered")
+		d.report(nil)
+		return
+	}
+
+	if d.attempts <= p.MaxRetries && atomic.LoadInt32(&p.closing) == 0 {
+		d.logger.WithError(message.TopicPartition.Error).Warn(fmt.Sprintf("Kafka delivery failed, retrying (attempt %v)", d.attempts+1))
+		p.count(&p.retried, "retried")
+		atomic.AddInt64(&p.pendingRetries, 1)
+		time.AfterFunc(time.Duration(d.attempts)*retryBackoff, func() {
+			defer atomic.AddInt64(&p.pendingRetries, -1)
+			if err := p.produce(message.Value, message.Headers, d); err != nil {
+				p.count(&p.failed, "failed")
+				d.logger.WithError(err).Error("Error producing kafka message")
+				d.report(err)
+			}
+		})
+		return
+	}
+
+	p.count(&p.failed, "failed")
+	d.logger.WithError(message.TopicPartition.Error).Error("Kafka delivery failed")
+	d.report(message.TopicPartition.Error)
+}
+
+// traceHeaders returns the headers propagating the trace context of ctx to consumers, if it carries a span.
+func traceHeaders(ctx context.Context) []kafka.Header {
+	var headers KafkaHeaders
+	otel.GetTextMapPropagator().Inject(ctx, &headers)
+	return headers
+}
+
+// count adds a message to one of the producer's delivery stats, and to the metric of its outcome.
+func (p *Producer) count(stat *uint64, outcome string) {
+	atomic.AddUint64(stat, 1)
+	metrics.KafkaMessages.WithLabelValues(outcome).Inc()
+}
+
+func (d *delivery) report(err error) {
+	if d.result != nil {
+		d.result <- err
+	}
+}
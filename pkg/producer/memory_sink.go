@@ -0,0 +1,66 @@
+package producer
+
+import (
+	"context"
+	"sync"
+
+	"ygo-card-processor/models"
+)
+
+// MemorySink keeps every event in memory, for local development and tests.
+type MemorySink struct {
+	mutex  sync.Mutex
+	logs   []models.Log
+	events []models.DomainEvent
+}
+
+func (s *MemorySink) Produce(ctx context.Context, event string, message string, isError bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.logs = append(s.logs, newLog(event, message, isError))
+}
+
+func (s *MemorySink) ProduceAndWait(ctx context.Context, event string, message string, isError bool) error {
+	s.Produce(ctx, event, message, isError)
+	return nil
+}
+
+func (s *MemorySink) Publish(ctx context.Context, event models.DomainEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *MemorySink) Stats() DeliveryStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return DeliveryStats{Delivered: uint64(len(s.logs) + len(s.events))}
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+// Logs returns a copy of the events produced so far.
+func (s *MemorySink) Logs() []models.Log {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	logs := make([]models.Log, len(s.logs))
+	copy(logs, s.logs)
+	return logs
+}
+
+// Events returns a copy of the domain events published so far.
+func (s *MemorySink) Events() []models.DomainEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	events := make([]models.DomainEvent, len(s.events))
+	copy(events, s.events)
+	return events
+}
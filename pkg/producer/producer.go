@@ -1,66 +1,34 @@
 package producer
 
 import (
-	"encoding/json"
+	"context"
 	"time"
 
-	"github.com/confluentinc/confluent-kafka-go/kafka"
-	"github.com/sirupsen/logrus"
-
 	"ygo-card-processor/models"
 )
 
-type KafkaProducer interface {
-	Produce(event string, message string, isError bool)
-}
+const appName = "ygo-card-processor"
 
-type Producer struct {
-	Producer        *kafka.Producer
-	Topic           string
-	DeliveryChannel chan kafka.Event
+type EventSink interface {
+	Produce(ctx context.Context, event string, message string, isError bool)
+	ProduceAndWait(ctx context.Context, event string, message string, isError bool) error
+	Publish(ctx context.Context, event models.DomainEvent) error
+	Stats() DeliveryStats
+	Close() error
 }
 
-func CreateProducer(broker string, topic string) (*Producer, error) {
-	p, err := kafka.NewProducer(&kafka.ConfigMap{
-		"bootstrap.servers": broker,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	producer := Producer{
-		Producer:        p,
-		Topic:           topic,
-		DeliveryChannel: make(chan kafka.Event),
-	}
-
-	return &producer, nil
+type DeliveryStats struct {
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+	Retried   uint64 `json:"retried"`
 }
 
-func (p *Producer) Produce(event string, message string, isError bool) {
-	log := models.Log{
-		AppName:   "ygo-card-processor",
+func newLog(event string, message string, isError bool) models.Log {
+	return models.Log{
+		AppName:   appName,
 		Event:     event,
 		Message:   message,
 		IsError:   isError,
 		TimeStamp: time.Now(),
 	}
-
-	bytes, err := json.Marshal(log)
-	if err != nil {
-		logrus.WithError(err).Error("Error creating log")
-	}
-
-	if err := p.produce(bytes); err != nil {
-		logrus.WithError(err).Error("Error producing kafka message")
-	}
-}
-
-func (p *Producer) produce(message []byte) error {
-	kMessage := &kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &p.Topic, Partition: kafka.PartitionAny},
-		Value:          message,
-	}
-
-	return p.Producer.Produce(kMessage, p.DeliveryChannel)
 }
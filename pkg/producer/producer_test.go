@@ -0,0 +1,199 @@
+package producer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/config"
+	"ygo-card-processor/pkg/events"
+)
+
+func TestProducer_CreateEventSink_ShouldReturnErrorIfNoSinksConfigured(t *testing.T) {
+	_, err := CreateEventSink(SinkConfig{})
+	require.NotNil(t, err)
+}
+
+func TestProducer_CreateEventSink_ShouldReturnErrorIfSinkTypeUnknown(t *testing.T) {
+	_, err := CreateEventSink(SinkConfig{Types: []string{"test"}})
+	require.NotNil(t, err)
+}
+
+func TestProducer_CreateEventSink_ShouldReturnErrorIfKafkaSinkHasNoBroker(t *testing.T) {
+	_, err := CreateEventSink(SinkConfig{Types: []string{SinkTypeKafka}, Topic: "test"})
+	require.NotNil(t, err)
+}
+
+func TestProducer_CreateEventSink_ShouldFanOutToSeveralSinks(t *testing.T) {
+	sink, err := CreateEventSink(SinkConfig{Types: ParseSinkTypes(" Memory, stdout ")})
+	require.Nil(t, err)
+
+	fanOut, ok := sink.(*FanOutSink)
+	require.True(t, ok)
+	require.Len(t, fanOut.Sinks, 2)
+
+	fanOut.Produce(context.Background(), "test", "test message", false)
+	require.Len(t, fanOut.Sinks[0].(*MemorySink).Logs(), 1)
+	require.Nil(t, fanOut.Close())
+}
+
+func TestProducer_NewSinkConfig_ShouldDefaultToKafkaIfBrokerConfigured(t *testing.T) {
+	sinkConfig := NewSinkConfig(config.EventsConfig{Broker: "localhost:9092", Topic: "events"})
+	require.Equal(t, []string{SinkTypeKafka}, sinkConfig.Types)
+	require.Equal(t, "localhost:9092", sinkConfig.Broker)
+	require.Equal(t, "events", sinkConfig.Topic)
+
+	require.Equal(t, []string{SinkTypeStdout}, NewSinkConfig(config.EventsConfig{}).Types)
+	require.Equal(t, []string{SinkTypeFile, SinkTypeStdout}, NewSinkConfig(config.EventsConfig{Sinks: []string{"File", "stdout"}}).Types)
+}
+
+func TestProducer_FileSink_ShouldWriteNewlineDelimitedJson(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+
+	sink, err := CreateFileSink(path, events.FormatJSON)
+	require.Nil(t, err)
+	sink.Produce(context.Background(), "processing_initiated", "card processing has started", false)
+	sink.Produce(context.Background(), "processing_error", "error updating card", true)
+	require.Nil(t, sink.Close())
+
+	file, err := os.Open(path)
+	require.Nil(t, err)
+	defer func() {
+		if err := file.Close(); err != nil {
+			t.Fatal("Unable to close file")
+		}
+	}()
+
+	var logs []models.Log
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var log models.Log
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &log))
+		logs = append(logs, log)
+	}
+	require.Len(t, logs, 2)
+	require.Equal(t, "processing_error", logs[1].Event)
+	require.True(t, logs[1].IsError)
+	require.Equal(t, appName, logs[1].AppName)
+}
+
+func TestProducer_CreateEventSink_ShouldReturnErrorIfFormatUnknown(t *testing.T) {
+	_, err := CreateEventSink(SinkConfig{Types: []string{SinkTypeMemory}, Format: "xml"})
+	require.NotNil(t, err)
+}
+
+func TestProducer_FileSink_ShouldPublishCloudEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+
+	sink, err := CreateFileSink(path, events.FormatCloudEvents)
+	require.Nil(t, err)
+	event := events.NewJobStartedEvent(context.Background(), models.Job{Id: primitive.NewObjectID(), Type: models.JobTypeRefresh})
+	require.Nil(t, sink.Publish(context.Background(), event))
+	require.Nil(t, sink.Close())
+
+	contents, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+
+	var cloudEvent events.CloudEvent
+	require.Nil(t, json.Unmarshal(contents, &cloudEvent))
+	require.Equal(t, "1.0", cloudEvent.SpecVersion)
+	require.Equal(t, event.Id, cloudEvent.Id)
+	require.Equal(t, "com.ygo-card-processor.job_started", cloudEvent.Type)
+	require.Equal(t, event.Job.Id, cloudEvent.Data.JobId)
+	require.Equal(t, uint64(1), sink.Stats().Delivered)
+}
+
+func TestProducer_FanOutSink_ShouldPublishToEverySink(t *testing.T) {
+	first := &MemorySink{}
+	second := &MemorySink{}
+	sink := FanOutSink{Sinks: []EventSink{first, second}}
+
+	require.Nil(t, sink.Publish(context.Background(), events.NewCardEvent(context.Background(), models.EventCardAdded, models.CardWithPriceInfo{})))
+	require.Len(t, first.Events(), 1)
+	require.Len(t, second.Events(), 1)
+	require.Equal(t, uint64(2), sink.Stats().Delivered)
+}
+
+func TestProducer_Producer_ShouldRetryAndReportFailedDelivery(t *testing.T) {
+	p, err := createProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  "localhost:1",
+		"message.timeout.ms": 100,
+	}, "test", events.FormatJSON)
+	require.Nil(t, err)
+	p.MaxRetries = 1
+
+	require.NotNil(t, p.ProduceAndWait(context.Background(), "test", "test message", false))
+
+	stats := p.Stats()
+	require.Equal(t, uint64(0), stats.Delivered)
+	require.Equal(t, uint64(1), stats.Failed)
+	require.Equal(t, uint64(1), stats.Retried)
+	require.Nil(t, p.Close())
+}
+
+func TestProducer_Producer_ShouldNotBlockWhenProducingSeveralMessages(t *testing.T) {
+	p, err := createProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  "localhost:1",
+		"message.timeout.ms": 100,
+	}, "test", events.FormatJSON)
+	require.Nil(t, err)
+	p.MaxRetries = 0
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			p.Produce(context.Background(), "test", "test message", false)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Producing messages blocked")
+	}
+	require.Nil(t, p.Close())
+	require.Equal(t, uint64(10), p.Stats().Failed)
+}
+
+func TestProducer_Producer_ShouldReturnErrorIfProducingAfterClose(t *testing.T) {
+	p, err := createProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  "localhost:1",
+		"message.timeout.ms": 100,
+	}, "test", events.FormatJSON)
+	require.Nil(t, err)
+	require.Nil(t, p.Close())
+
+	err = p.produce([]byte("test message"), nil, &delivery{})
+	require.Equal(t, ErrProducerClosed, err)
+}
+
+func TestProducer_KafkaHeaders_ShouldCarryTraceContext(t *testing.T) {
+	traceId, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.Nil(t, err)
+	spanId, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.Nil(t, err)
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceId, SpanID: spanId, TraceFlags: trace.FlagsSampled})
+
+	headers := KafkaHeaders{{Key: "content-type", Value: []byte("application/json")}}
+	propagation.TraceContext{}.Inject(trace.ContextWithSpanContext(context.Background(), spanContext), &headers)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", headers.Get("traceparent"))
+	require.Equal(t, "application/json", headers.Get("content-type"))
+
+	extracted := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), &headers))
+	require.Equal(t, traceId, extracted.TraceID())
+	require.Equal(t, spanId, extracted.SpanID())
+	require.True(t, extracted.IsRemote())
+}
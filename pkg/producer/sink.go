@@ -0,0 +1,111 @@
+package producer
+
+import (
+	"fmt"
+	"strings"
+
+	"ygo-card-processor/pkg/config"
+	"ygo-card-processor/pkg/events"
+)
+
+const (
+	SinkTypeKafka  = "kafka"
+	SinkTypeFile   = "file"
+	SinkTypeStdout = "stdout"
+	SinkTypeStderr = "stderr"
+	SinkTypeMemory = "memory"
+)
+
+type SinkConfig struct {
+	Types    []string
+	Broker   string
+	Topic    string
+	FilePath string
+	Format   string
+}
+
+// NewSinkConfig selects the sinks listed in the configuration. If none are listed, events go to Kafka when a broker is
+// configured and to stdout otherwise, so the processor can run locally without a broker.
+func NewSinkConfig(config config.EventsConfig) SinkConfig {
+	sinkTypes := make([]string, 0)
+	for _, sinkType := range config.Sinks {
+		sinkTypes = append(sinkTypes, ParseSinkTypes(sinkType)...)
+	}
+	if len(sinkTypes) == 0 && config.Broker != "" {
+		sinkTypes = []string{SinkTypeKafka}
+	} else if len(sinkTypes) == 0 {
+		sinkTypes = []string{SinkTypeStdout}
+	}
+
+	return SinkConfig{
+		Types:    sinkTypes,
+		Broker:   config.Broker,
+		Topic:    config.Topic,
+		FilePath: config.File,
+		Format:   config.Format,
+	}
+}
+
+// ParseSinkTypes parses a comma separated list of sink types such as "kafka,file".
+func ParseSinkTypes(value string) []string {
+	types := make([]string, 0)
+	for _, sinkType := range strings.Split(value, ",") {
+		if sinkType = strings.TrimSpace(sinkType); sinkType != "" {
+			types = append(types, strings.ToLower(sinkType))
+		}
+	}
+	return types
+}
+
+// CreateEventSink creates the sinks named in the config. Several sinks are combined into a FanOutSink.
+func CreateEventSink(config SinkConfig) (EventSink, error) {
+	if len(config.Types) == 0 {
+		return nil, fmt.Errorf("no event sink configured")
+	}
+
+	format, err := events.ParseFormat(config.Format)
+	if err != nil {
+		return nil, err
+	}
+	config.Format = format
+
+	sinks := make([]EventSink, 0)
+	for _, sinkType := range config.Types {
+		sink, err := createSink(sinkType, config)
+		if err != nil {
+			for _, created := range sinks {
+				_ = created.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return &FanOutSink{Sinks: sinks}, nil
+}
+
+func createSink(sinkType string, config SinkConfig) (EventSink, error) {
+	switch sinkType {
+	case SinkTypeKafka:
+		if config.Broker == "" || config.Topic == "" {
+			return nil, fmt.Errorf("kafka event sink requires a broker and a topic")
+		}
+		return CreateProducer(config.Broker, config.Topic, config.Format)
+	case SinkTypeFile:
+		if config.FilePath == "" {
+			return nil, fmt.Errorf("file event sink requires a file path")
+		}
+		return CreateFileSink(config.FilePath, config.Format)
+	case SinkTypeStdout:
+		return CreateStdoutSink(config.Format), nil
+	case SinkTypeStderr:
+		return CreateStderrSink(config.Format), nil
+	case SinkTypeMemory:
+		return &MemorySink{}, nil
+	default:
+		return nil, fmt.Errorf("unknown event sink type '%v'", sinkType)
+	}
+}
@@ -0,0 +1,49 @@
+package producer
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/tracing"
+)
+
+// TracedSink records each event produced to the sink it wraps as a span.
+type TracedSink struct {
+	Sink EventSink
+}
+
+func (s *TracedSink) Produce(ctx context.Context, event string, message string, isError bool) {
+	ctx, span := startSpan(ctx, event)
+	defer span.End()
+	s.Sink.Produce(ctx, event, message, isError)
+}
+
+func (s *TracedSink) ProduceAndWait(ctx context.Context, event string, message string, isError bool) error {
+	ctx, span := startSpan(ctx, event)
+	err := s.Sink.ProduceAndWait(ctx, event, message, isError)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedSink) Publish(ctx context.Context, event models.DomainEvent) error {
+	ctx, span := startSpan(ctx, event.Type, attribute.String("event.id", event.Id))
+	err := s.Sink.Publish(ctx, event)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedSink) Stats() DeliveryStats {
+	return s.Sink.Stats()
+}
+
+func (s *TracedSink) Close() error {
+	return s.Sink.Close()
+}
+
+func startSpan(ctx context.Context, event string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes, attribute.String("event.type", event))
+	return tracing.Start(ctx, "produce "+event, trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(attributes...))
+}
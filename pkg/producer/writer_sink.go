@@ -0,0 +1,89 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/events"
+	"ygo-card-processor/pkg/logging"
+)
+
+// WriterSink writes events as newline delimited JSON, to a file, stdout or stderr.
+type WriterSink struct {
+	Writer io.Writer
+	Closer io.Closer
+	Format string
+
+	mutex sync.Mutex
+	stats DeliveryStats
+}
+
+func CreateFileSink(path string, format string) (*WriterSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WriterSink{Writer: f, Closer: f, Format: format}, nil
+}
+
+func CreateStdoutSink(format string) *WriterSink {
+	return &WriterSink{Writer: os.Stdout, Format: format}
+}
+
+func CreateStderrSink(format string) *WriterSink {
+	return &WriterSink{Writer: os.Stderr, Format: format}
+}
+
+func (s *WriterSink) Produce(ctx context.Context, event string, message string, isError bool) {
+	if err := s.ProduceAndWait(ctx, event, message, isError); err != nil {
+		logging.From(ctx).WithError(err).Error("Error writing event")
+	}
+}
+
+func (s *WriterSink) ProduceAndWait(ctx context.Context, event string, message string, isError bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := json.NewEncoder(s.Writer).Encode(newLog(event, message, isError)); err != nil {
+		s.stats.Failed++
+		return err
+	}
+	s.stats.Delivered++
+	return nil
+}
+
+func (s *WriterSink) Publish(ctx context.Context, event models.DomainEvent) error {
+	bytes, err := events.Encode(s.Format, event)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.Writer.Write(append(bytes, '\n')); err != nil {
+		s.stats.Failed++
+		return err
+	}
+	s.stats.Delivered++
+	return nil
+}
+
+func (s *WriterSink) Stats() DeliveryStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.stats
+}
+
+func (s *WriterSink) Close() error {
+	if s.Closer == nil {
+		return nil
+	}
+	return s.Closer.Close()
+}
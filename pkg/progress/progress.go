@@ -0,0 +1,107 @@
+package progress
+
+import (
+	"sync"
+	"time"
+
+	"ygo-card-processor/models"
+)
+
+// subscriberBuffer is how many updates a slow client may fall behind before further updates are dropped for it. Each
+// update is a complete snapshot, so a client that misses some is still current once it catches up.
+const subscriberBuffer = 16
+
+// Broadcaster fans out the progress of running jobs to the clients following them.
+type Broadcaster struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan models.JobProgress]struct{}
+	latest      map[string]models.JobProgress
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		subscribers: make(map[string]map[chan models.JobProgress]struct{}),
+		latest:      make(map[string]models.JobProgress),
+	}
+}
+
+// Publish sends a job's progress to its subscribers. Once a job has ended its subscribers are closed.
+func (b *Broadcaster) Publish(progress models.JobProgress) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for subscriber := range b.subscribers[progress.JobId] {
+		select {
+		case subscriber <- progress:
+		default:
+			// The final update must arrive, so it replaces the oldest one still waiting.
+			if Ended(progress.Status) {
+				select {
+				case <-subscriber:
+				default:
+				}
+				subscriber <- progress
+			}
+		}
+	}
+
+	if Ended(progress.Status) {
+		for subscriber := range b.subscribers[progress.JobId] {
+			close(subscriber)
+		}
+		delete(b.subscribers, progress.JobId)
+		delete(b.latest, progress.JobId)
+		return
+	}
+	b.latest[progress.JobId] = progress
+}
+
+// Subscribe follows a job's progress, returning the latest update if the job has published any. The returned function
+// unsubscribes and must be called once the client stops following the job.
+func (b *Broadcaster) Subscribe(jobId string) (<-chan models.JobProgress, *models.JobProgress, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subscriber := make(chan models.JobProgress, subscriberBuffer)
+	if b.subscribers[jobId] == nil {
+		b.subscribers[jobId] = make(map[chan models.JobProgress]struct{})
+	}
+	b.subscribers[jobId][subscriber] = struct{}{}
+
+	var latest *models.JobProgress
+	if progress, ok := b.latest[jobId]; ok {
+		latest = &progress
+	}
+
+	unsubscribe := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, ok := b.subscribers[jobId][subscriber]; ok {
+			delete(b.subscribers[jobId], subscriber)
+			close(subscriber)
+			if len(b.subscribers[jobId]) == 0 {
+				delete(b.subscribers, jobId)
+			}
+		}
+	}
+	return subscriber, latest, unsubscribe
+}
+
+// FromJob returns the progress of a job as stored, for clients that start following it.
+func FromJob(job models.Job) models.JobProgress {
+	return models.JobProgress{
+		JobId:      job.Id.Hex(),
+		Type:       job.Type,
+		Status:     job.Status,
+		Total:      job.Total,
+		Processed:  job.Processed,
+		Failed:     job.Failed,
+		Unresolved: len(job.Ambiguous) + len(job.NotFound),
+		Time:       time.Now(),
+	}
+}
+
+// Ended reports whether a job with the given status has stopped for good.
+func Ended(status string) bool {
+	return status == models.JobStatusFinished || status == models.JobStatusCancelled
+}
@@ -0,0 +1,50 @@
+package progress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"ygo-card-processor/models"
+)
+
+func TestProgress_Broadcaster_ShouldSendUpdatesToSubscribers(t *testing.T) {
+	b := NewBroadcaster()
+	updates, latest, unsubscribe := b.Subscribe("job")
+	defer unsubscribe()
+	require.Nil(t, latest)
+
+	b.Publish(models.JobProgress{JobId: "other", Status: models.JobStatusRunning})
+	b.Publish(models.JobProgress{JobId: "job", Status: models.JobStatusRunning, Processed: 1})
+	require.Equal(t, 1, (<-updates).Processed)
+}
+
+func TestProgress_Broadcaster_ShouldReturnLatestUpdateOnSubscribe(t *testing.T) {
+	b := NewBroadcaster()
+	b.Publish(models.JobProgress{JobId: "job", Status: models.JobStatusRunning, Processed: 2})
+
+	_, latest, unsubscribe := b.Subscribe("job")
+	defer unsubscribe()
+	require.Equal(t, 2, latest.Processed)
+}
+
+func TestProgress_Broadcaster_ShouldDeliverFinalUpdateAndCloseSubscribers(t *testing.T) {
+	b := NewBroadcaster()
+	updates, _, unsubscribe := b.Subscribe("job")
+	defer unsubscribe()
+
+	for i := 0; i < subscriberBuffer+5; i++ {
+		b.Publish(models.JobProgress{JobId: "job", Status: models.JobStatusRunning})
+	}
+	b.Publish(models.JobProgress{JobId: "job", Status: models.JobStatusFinished})
+
+	var last models.JobProgress
+	for update := range updates {
+		last = update
+	}
+	require.Equal(t, models.JobStatusFinished, last.Status)
+
+	_, latest, unsubscribeLater := b.Subscribe("job")
+	defer unsubscribeLater()
+	require.Nil(t, latest)
+}
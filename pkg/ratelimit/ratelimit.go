@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that have refilled completely are dropped, so clients seen once are forgotten.
+const sweepInterval = time.Minute
+
+// Limiter is a token bucket per client. Each bucket holds up to burst tokens and refills at the limiter's rate; every
+// request takes a token, and is refused if there is none.
+type Limiter struct {
+	burst     int
+	perSecond float64
+	clock     func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// Result is the outcome of a request for a token, and the state of the client's bucket after it.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a refused client has a token again.
+	RetryAfter time.Duration
+}
+
+// New creates a limiter allowing each client perMinute requests a minute, in bursts of up to burst requests. A nil
+// clock defaults to time.Now.
+func New(perMinute int, burst int, clock func() time.Time) *Limiter {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &Limiter{
+		burst:     burst,
+		perSecond: float64(perMinute) / 60,
+		clock:     clock,
+		buckets:   make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of the client with the given key, if it has one.
+func (l *Limiter) Allow(key string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), updatedAt: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, l.perSecond, l.burst)
+
+	result := Result{Limit: l.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.timeFor(1 - b.tokens)
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = l.timeFor(float64(l.burst) - b.tokens)
+	return result
+}
+
+func (b *bucket) refill(now time.Time, perSecond float64, burst int) {
+	if elapsed := now.Sub(b.updatedAt).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(burst), b.tokens+elapsed*perSecond)
+	}
+	b.updatedAt = now
+}
+
+// timeFor returns how long refilling the given number of tokens takes.
+func (l *Limiter) timeFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(tokens / l.perSecond * float64(time.Second)))
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updatedAt).Seconds()*l.perSecond >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_Allow_ShouldRefuseClientAfterBurst(t *testing.T) {
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	limiter := New(60, 2, func() time.Time { return now })
+
+	require.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}, limiter.Allow("a"))
+	require.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}, limiter.Allow("a"))
+	require.Equal(t, Result{Limit: 2, Remaining: 0, Reset: 2 * time.Second, RetryAfter: time.Second}, limiter.Allow("a"))
+
+	// Other clients have buckets of their own.
+	require.True(t, limiter.Allow("b").Allowed)
+}
+
+func TestLimiter_Allow_ShouldRefillBucketOverTime(t *testing.T) {
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	limiter := New(60, 2, func() time.Time { return now })
+
+	require.True(t, limiter.Allow("a").Allowed)
+	require.True(t, limiter.Allow("a").Allowed)
+	require.False(t, limiter.Allow("a").Allowed)
+
+	now = now.Add(500 * time.Millisecond)
+	require.Equal(t, 500*time.Millisecond, limiter.Allow("a").RetryAfter)
+
+	now = now.Add(500 * time.Millisecond)
+	require.True(t, limiter.Allow("a").Allowed)
+	require.False(t, limiter.Allow("a").Allowed)
+
+	now = now.Add(time.Hour)
+	require.Equal(t, 1, limiter.Allow("a").Remaining)
+}
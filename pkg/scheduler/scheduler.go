@@ -0,0 +1,210 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/dao"
+	"ygo-card-processor/pkg/logging"
+	"ygo-card-processor/pkg/processor"
+	"ygo-card-processor/pkg/tenant"
+)
+
+const defaultInterval = 30 * time.Second
+
+// Scheduler starts refreshes whenever a stored schedule comes due. Each schedule's last run is stored, so a restart
+// neither repeats a run that already happened nor skips one that came due while the processor was down; missed runs
+// are caught up once. A schedule that comes due while another refresh is running is retried on the next check. Every
+// replica checks the schedules, and a run is claimed in the database before it starts, so each runs on one replica only.
+type Scheduler struct {
+	Handler   dao.DbHandler
+	Processor processor.CardProcessor
+	Interval  time.Duration
+}
+
+// Run checks for due schedules every Interval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunDue(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue starts a refresh for every enabled schedule that is due at now.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) {
+	schedules, err := s.Handler.GetSchedules(ctx)
+	if err != nil {
+		logging.From(ctx).WithError(err).Error("Error retrieving schedules")
+		return
+	}
+
+	for _, schedule := range schedules {
+		if schedule.Disabled {
+			continue
+		}
+
+		nextRun, err := NextRun(schedule)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error(fmt.Sprintf("Invalid schedule '%v'", schedule.Name))
+			continue
+		}
+		if nextRun.After(now) {
+			continue
+		}
+
+		claimed, err := s.Handler.ClaimScheduleRun(ctx, schedule.Name, nextRun, now)
+		if err != nil {
+			logging.From(ctx).WithError(err).Error(fmt.Sprintf("Error claiming run of schedule '%v'", schedule.Name))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		job, err := s.Processor.Refresh(tenant.WithCollection(ctx, schedule.Collection), Filter(schedule), schedule.Budget)
+		if errors.Is(err, processor.ErrRefreshInProgress) {
+			logging.From(ctx).Info(fmt.Sprintf("Schedule '%v' is due but a refresh is in progress, retrying later", schedule.Name))
+			// The claim is given back, so that the schedule is still due on the next check.
+			if err := s.Handler.MarkScheduleRun(ctx, schedule.Name, schedule.LastRunAt, schedule.LastJobId); err != nil {
+				logging.From(ctx).WithError(err).Error(fmt.Sprintf("Error releasing run of schedule '%v'", schedule.Name))
+			}
+			continue
+		}
+
+		jobId := ""
+		if err != nil {
+			logging.From(ctx).WithError(err).Error(fmt.Sprintf("Error running schedule '%v'", schedule.Name))
+		} else {
+			jobId = job.Id.Hex()
+		}
+
+		// Failed runs are marked as well, so that a failing schedule waits for its next slot instead of retrying.
+		if err := s.Handler.MarkScheduleRun(ctx, schedule.Name, now, jobId); err != nil {
+			logging.From(ctx).WithError(err).Error(fmt.Sprintf("Error saving run of schedule '%v'", schedule.Name))
+		}
+	}
+}
+
+// Seed stores the given schedules, leaving schedules that already exist untouched.
+func (s *Scheduler) Seed(ctx context.Context, schedules []models.Schedule) error {
+	for _, schedule := range schedules {
+		if err := Validate(schedule); err != nil {
+			return fmt.Errorf("invalid schedule '%v': %w", schedule.Name, err)
+		}
+		schedule.CreatedAt = time.Now()
+		if err := s.Handler.AddScheduleIfMissing(ctx, schedule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks that a schedule has a name, a valid cron expression and a known target.
+func Validate(schedule models.Schedule) error {
+	if schedule.Name == "" {
+		return errors.New("schedule requires a name")
+	}
+
+	if _, err := cron.ParseStandard(schedule.Cron); err != nil {
+		return fmt.Errorf("invalid cron expression: %w", err)
+	}
+
+	if schedule.Budget < 0 {
+		return errors.New("budget must not be negative")
+	}
+
+	switch schedule.Target {
+	case models.ScheduleTargetAll, models.ScheduleTargetNew:
+		return nil
+	case models.ScheduleTargetHighValue:
+		if schedule.MinMarketPrice <= 0 {
+			return errors.New("high_value schedule requires a minMarketPrice")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown schedule target '%v'", schedule.Target)
+	}
+}
+
+// NextRun returns when a schedule is next due, counting from its last run or, if it never ran, from its creation.
+func NextRun(schedule models.Schedule) (time.Time, error) {
+	cronSchedule, err := cron.ParseStandard(schedule.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cronSchedule.Next(lastRun(schedule)), nil
+}
+
+// Filter returns which cards a run of a schedule refreshes.
+func Filter(schedule models.Schedule) models.CardFilter {
+	switch schedule.Target {
+	case models.ScheduleTargetHighValue:
+		return models.CardFilter{MinMarketPrice: schedule.MinMarketPrice}
+	case models.ScheduleTargetNew:
+		return models.CardFilter{AddedAfter: lastRun(schedule)}
+	default:
+		return models.CardFilter{}
+	}
+}
+
+// ParseSchedules parses a JSON list of schedules, such as the value of the SCHEDULES environment variable.
+func ParseSchedules(value []byte) ([]models.Schedule, error) {
+	schedules := make([]models.Schedule, 0)
+	if len(value) == 0 {
+		return schedules, nil
+	}
+
+	if err := json.Unmarshal(value, &schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// LoadSchedules reads the configured schedules from a JSON file, if a path is given, and from a JSON string.
+func LoadSchedules(path string, value string) ([]models.Schedule, error) {
+	schedules := make([]models.Schedule, 0)
+	if path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fileSchedules, err := ParseSchedules(contents)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing schedule file: %w", err)
+		}
+		schedules = append(schedules, fileSchedules...)
+	}
+
+	envSchedules, err := ParseSchedules([]byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing schedules: %w", err)
+	}
+	return append(schedules, envSchedules...), nil
+}
+
+func lastRun(schedule models.Schedule) time.Time {
+	if schedule.LastRunAt.IsZero() {
+		return schedule.CreatedAt
+	}
+	return schedule.LastRunAt
+}
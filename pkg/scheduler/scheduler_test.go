@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ygo-card-processor/models"
+	"ygo-card-processor/pkg/processor"
+	"ygo-card-processor/pkg/testhelper/mocks"
+)
+
+var lastNight = time.Date(2021, 3, 1, 3, 0, 0, 0, time.Local)
+
+func TestScheduler_RunDue_ShouldRefreshDueScheduleAndMarkRun(t *testing.T) {
+	now := lastNight.Add(24 * time.Hour)
+	job := &models.Job{Id: primitive.NewObjectID()}
+
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSchedules", mock.Anything).Return([]models.Schedule{
+		{Name: "nightly", Cron: "0 3 * * *", Target: models.ScheduleTargetAll, LastRunAt: lastNight},
+	}, nil)
+	dbHandler.On("ClaimScheduleRun", mock.Anything, "nightly", now, now).Return(true, nil)
+	dbHandler.On("MarkScheduleRun", mock.Anything, "nightly", now, job.Id.Hex()).Return(nil)
+
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Refresh", mock.Anything, models.CardFilter{}, 0).Return(job, nil)
+
+	s := Scheduler{Handler: dbHandler, Processor: cardProcessor}
+	s.RunDue(context.Background(), now)
+	dbHandler.AssertExpectations(t)
+	cardProcessor.AssertExpectations(t)
+}
+
+func TestScheduler_RunDue_ShouldRefreshWithinScheduleBudget(t *testing.T) {
+	now := lastNight.Add(time.Hour)
+	job := &models.Job{Id: primitive.NewObjectID()}
+
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSchedules", mock.Anything).Return([]models.Schedule{
+		{Name: "frequent", Cron: "@hourly", Target: models.ScheduleTargetAll, Budget: 25, LastRunAt: lastNight},
+	}, nil)
+	dbHandler.On("ClaimScheduleRun", mock.Anything, "frequent", now, now).Return(true, nil)
+	dbHandler.On("MarkScheduleRun", mock.Anything, "frequent", now, job.Id.Hex()).Return(nil)
+
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Refresh", mock.Anything, models.CardFilter{}, 25).Return(job, nil)
+
+	s := Scheduler{Handler: dbHandler, Processor: cardProcessor}
+	s.RunDue(context.Background(), now)
+	cardProcessor.AssertExpectations(t)
+}
+
+func TestScheduler_RunDue_ShouldNotRunScheduleTwiceAfterRestart(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSchedules", mock.Anything).Return([]models.Schedule{
+		{Name: "nightly", Cron: "0 3 * * *", Target: models.ScheduleTargetAll, LastRunAt: lastNight},
+		{Name: "paused", Cron: "* * * * *", Target: models.ScheduleTargetAll, LastRunAt: lastNight, Disabled: true},
+	}, nil)
+
+	cardProcessor := &mocks.CardProcessor{}
+
+	s := Scheduler{Handler: dbHandler, Processor: cardProcessor}
+	s.RunDue(context.Background(), lastNight.Add(time.Hour))
+	cardProcessor.AssertNotCalled(t, "Refresh", mock.Anything, mock.Anything, mock.Anything)
+	dbHandler.AssertNotCalled(t, "MarkScheduleRun", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestScheduler_RunDue_ShouldRetryLaterIfRefreshInProgress(t *testing.T) {
+	now := lastNight.Add(25 * time.Hour)
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSchedules", mock.Anything).Return([]models.Schedule{
+		{Name: "nightly", Cron: "0 3 * * *", Target: models.ScheduleTargetAll, LastRunAt: lastNight, LastJobId: "previous"},
+	}, nil)
+	dbHandler.On("ClaimScheduleRun", mock.Anything, "nightly", lastNight.Add(24*time.Hour), now).Return(true, nil)
+	dbHandler.On("MarkScheduleRun", mock.Anything, "nightly", lastNight, "previous").Return(nil)
+
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Refresh", mock.Anything, mock.Anything, mock.Anything).Return(nil, processor.ErrRefreshInProgress)
+
+	s := Scheduler{Handler: dbHandler, Processor: cardProcessor}
+	s.RunDue(context.Background(), now)
+	dbHandler.AssertExpectations(t)
+	dbHandler.AssertNotCalled(t, "MarkScheduleRun", mock.Anything, "nightly", now, mock.Anything)
+}
+
+func TestScheduler_RunDue_ShouldNotRunScheduleClaimedByOtherReplica(t *testing.T) {
+	now := lastNight.Add(25 * time.Hour)
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSchedules", mock.Anything).Return([]models.Schedule{
+		{Name: "nightly", Cron: "0 3 * * *", Target: models.ScheduleTargetAll, LastRunAt: lastNight},
+	}, nil)
+	dbHandler.On("ClaimScheduleRun", mock.Anything, "nightly", lastNight.Add(24*time.Hour), now).Return(false, nil)
+
+	cardProcessor := &mocks.CardProcessor{}
+
+	s := Scheduler{Handler: dbHandler, Processor: cardProcessor}
+	s.RunDue(context.Background(), now)
+	cardProcessor.AssertNotCalled(t, "Refresh", mock.Anything, mock.Anything, mock.Anything)
+	dbHandler.AssertNotCalled(t, "MarkScheduleRun", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestScheduler_RunDue_ShouldMarkFailedRun(t *testing.T) {
+	now := lastNight.Add(25 * time.Hour)
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("GetSchedules", mock.Anything).Return([]models.Schedule{
+		{Name: "nightly", Cron: "0 3 * * *", Target: models.ScheduleTargetAll, LastRunAt: lastNight},
+	}, nil)
+	dbHandler.On("ClaimScheduleRun", mock.Anything, "nightly", mock.Anything, now).Return(true, nil)
+	dbHandler.On("MarkScheduleRun", mock.Anything, "nightly", now, "").Return(nil)
+
+	cardProcessor := &mocks.CardProcessor{}
+	cardProcessor.On("Refresh", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("test"))
+
+	s := Scheduler{Handler: dbHandler, Processor: cardProcessor}
+	s.RunDue(context.Background(), now)
+	dbHandler.AssertExpectations(t)
+}
+
+func TestScheduler_Filter_ShouldSelectCardsByTarget(t *testing.T) {
+	require.Equal(t, models.CardFilter{MinMarketPrice: 50}, Filter(models.Schedule{Target: models.ScheduleTargetHighValue, MinMarketPrice: 50}))
+	require.Equal(t, models.CardFilter{AddedAfter: lastNight}, Filter(models.Schedule{Target: models.ScheduleTargetNew, LastRunAt: lastNight}))
+	require.Equal(t, models.CardFilter{AddedAfter: lastNight}, Filter(models.Schedule{Target: models.ScheduleTargetNew, CreatedAt: lastNight}))
+	require.Equal(t, models.CardFilter{}, Filter(models.Schedule{Target: models.ScheduleTargetAll}))
+}
+
+func TestScheduler_NextRun_ShouldCountFromCreationIfNeverRun(t *testing.T) {
+	nextRun, err := NextRun(models.Schedule{Cron: "0 * * * *", CreatedAt: lastNight.Add(30 * time.Minute)})
+	require.Nil(t, err)
+	require.Equal(t, lastNight.Add(time.Hour), nextRun)
+}
+
+func TestScheduler_Validate_ShouldRejectInvalidSchedules(t *testing.T) {
+	require.NotNil(t, Validate(models.Schedule{Cron: "0 3 * * *", Target: models.ScheduleTargetAll}))
+	require.NotNil(t, Validate(models.Schedule{Name: "test", Cron: "every night", Target: models.ScheduleTargetAll}))
+	require.NotNil(t, Validate(models.Schedule{Name: "test", Cron: "0 3 * * *", Target: "rare"}))
+	require.NotNil(t, Validate(models.Schedule{Name: "test", Cron: "0 3 * * *", Target: models.ScheduleTargetHighValue}))
+	require.Nil(t, Validate(models.Schedule{Name: "test", Cron: "@hourly", Target: models.ScheduleTargetHighValue, MinMarketPrice: 20}))
+}
+
+func TestScheduler_Seed_ShouldAddConfiguredSchedulesIfMissing(t *testing.T) {
+	dbHandler := &mocks.DbHandler{}
+	dbHandler.On("AddScheduleIfMissing", mock.Anything, mock.MatchedBy(func(schedule models.Schedule) bool {
+		return schedule.Name == "nightly" && !schedule.CreatedAt.IsZero()
+	})).Return(nil)
+
+	schedules, err := LoadSchedules("", `[{"name": "nightly", "cron": "0 3 * * *", "target": "all"}]`)
+	require.Nil(t, err)
+
+	s := Scheduler{Handler: dbHandler}
+	require.Nil(t, s.Seed(context.Background(), schedules))
+	dbHandler.AssertExpectations(t)
+}
+
+func TestScheduler_Seed_ShouldReturnErrorIfScheduleInvalid(t *testing.T) {
+	s := Scheduler{Handler: &mocks.DbHandler{}}
+	require.NotNil(t, s.Seed(context.Background(), []models.Schedule{{Name: "test", Cron: "0 3 * * *"}}))
+}
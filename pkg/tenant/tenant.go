@@ -0,0 +1,25 @@
+package tenant
+
+import (
+	"context"
+
+	"ygo-card-processor/models"
+)
+
+type contextKey int
+
+const collectionIdKey contextKey = iota
+
+// WithCollection returns a context scoped to the card collection with the given ID. Cards and jobs stored or retrieved
+// with it belong to that collection.
+func WithCollection(ctx context.Context, collectionId string) context.Context {
+	return context.WithValue(ctx, collectionIdKey, collectionId)
+}
+
+// CollectionId returns the ID of the collection ctx is scoped to, or models.DefaultCollectionId if it is not scoped.
+func CollectionId(ctx context.Context) string {
+	if collectionId, ok := ctx.Value(collectionIdKey).(string); ok && collectionId != "" {
+		return collectionId
+	}
+	return models.DefaultCollectionId
+}
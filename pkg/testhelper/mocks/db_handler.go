@@ -4,6 +4,7 @@ package mocks
 
 import (
 	context "context"
+	time "time"
 
 	mock "github.com/stretchr/testify/mock"
 
@@ -17,6 +18,50 @@ type DbHandler struct {
 	mock.Mock
 }
 
+// AcquireLock provides a mock function with given fields: ctx, name, owner, duration
+func (_m *DbHandler) AcquireLock(ctx context.Context, name string, owner string, duration time.Duration) (bool, error) {
+	ret := _m.Called(ctx, name, owner, duration)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Duration) bool); ok {
+		r0 = rf(ctx, name, owner, duration)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, time.Duration) error); ok {
+		r1 = rf(ctx, name, owner, duration)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddApiKey provides a mock function with given fields: ctx, key
+func (_m *DbHandler) AddApiKey(ctx context.Context, key models.ApiKey) (primitive.ObjectID, error) {
+	ret := _m.Called(ctx, key)
+
+	var r0 primitive.ObjectID
+	if rf, ok := ret.Get(0).(func(context.Context, models.ApiKey) primitive.ObjectID); ok {
+		r0 = rf(ctx, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(primitive.ObjectID)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, models.ApiKey) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // AddCard provides a mock function with given fields: ctx, card
 func (_m *DbHandler) AddCard(ctx context.Context, card models.CardWithPriceInfo) (interface{}, error) {
 	ret := _m.Called(ctx, card)
@@ -61,6 +106,115 @@ func (_m *DbHandler) AddCards(ctx context.Context, cardList []interface{}) (int,
 	return r0, r1
 }
 
+// AddCollection provides a mock function with given fields: ctx, collection
+func (_m *DbHandler) AddCollection(ctx context.Context, collection models.Collection) error {
+	ret := _m.Called(ctx, collection)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.Collection) error); ok {
+		r0 = rf(ctx, collection)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddJob provides a mock function with given fields: ctx, job
+func (_m *DbHandler) AddJob(ctx context.Context, job models.Job) (primitive.ObjectID, error) {
+	ret := _m.Called(ctx, job)
+
+	var r0 primitive.ObjectID
+	if rf, ok := ret.Get(0).(func(context.Context, models.Job) primitive.ObjectID); ok {
+		r0 = rf(ctx, job)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(primitive.ObjectID)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, models.Job) error); ok {
+		r1 = rf(ctx, job)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddScheduleIfMissing provides a mock function with given fields: ctx, schedule
+func (_m *DbHandler) AddScheduleIfMissing(ctx context.Context, schedule models.Schedule) error {
+	ret := _m.Called(ctx, schedule)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.Schedule) error); ok {
+		r0 = rf(ctx, schedule)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ClaimScheduleRun provides a mock function with given fields: ctx, name, due, runAt
+func (_m *DbHandler) ClaimScheduleRun(ctx context.Context, name string, due time.Time, runAt time.Time) (bool, error) {
+	ret := _m.Called(ctx, name, due, runAt)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time) bool); ok {
+		r0 = rf(ctx, name, due, runAt)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time, time.Time) error); ok {
+		r1 = rf(ctx, name, due, runAt)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DedupeCards provides a mock function with given fields: ctx, dryRun
+func (_m *DbHandler) DedupeCards(ctx context.Context, dryRun bool) ([]models.DuplicateCards, error) {
+	ret := _m.Called(ctx, dryRun)
+
+	var r0 []models.DuplicateCards
+	if rf, ok := ret.Get(0).(func(context.Context, bool) []models.DuplicateCards); ok {
+		r0 = rf(ctx, dryRun)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.DuplicateCards)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, bool) error); ok {
+		r1 = rf(ctx, dryRun)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteApiKey provides a mock function with given fields: ctx, id
+func (_m *DbHandler) DeleteApiKey(ctx context.Context, id primitive.ObjectID) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, primitive.ObjectID) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteCard provides a mock function with given fields: ctx, serial
 func (_m *DbHandler) DeleteCard(ctx context.Context, serial string) error {
 	ret := _m.Called(ctx, serial)
@@ -75,6 +229,80 @@ func (_m *DbHandler) DeleteCard(ctx context.Context, serial string) error {
 	return r0
 }
 
+// DeleteCollection provides a mock function with given fields: ctx, id
+func (_m *DbHandler) DeleteCollection(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteSchedule provides a mock function with given fields: ctx, name
+func (_m *DbHandler) DeleteSchedule(ctx context.Context, name string) error {
+	ret := _m.Called(ctx, name)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetApiKeyByHash provides a mock function with given fields: ctx, hash
+func (_m *DbHandler) GetApiKeyByHash(ctx context.Context, hash string) (*models.ApiKey, error) {
+	ret := _m.Called(ctx, hash)
+
+	var r0 *models.ApiKey
+	if rf, ok := ret.Get(0).(func(context.Context, string) *models.ApiKey); ok {
+		r0 = rf(ctx, hash)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.ApiKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, hash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetApiKeys provides a mock function with given fields: ctx
+func (_m *DbHandler) GetApiKeys(ctx context.Context) ([]models.ApiKey, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []models.ApiKey
+	if rf, ok := ret.Get(0).(func(context.Context) []models.ApiKey); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.ApiKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetCardByNumber provides a mock function with given fields: ctx, serial
 func (_m *DbHandler) GetCardByNumber(ctx context.Context, serial string) (*models.CardWithPriceInfo, error) {
 	ret := _m.Called(ctx, serial)
@@ -121,6 +349,264 @@ func (_m *DbHandler) GetCards(ctx context.Context, filters map[string]interface{
 	return r0, r1
 }
 
+// GetCollection provides a mock function with given fields: ctx, id
+func (_m *DbHandler) GetCollection(ctx context.Context, id string) (*models.Collection, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *models.Collection
+	if rf, ok := ret.Get(0).(func(context.Context, string) *models.Collection); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Collection)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetCollections provides a mock function with given fields: ctx, user
+func (_m *DbHandler) GetCollections(ctx context.Context, user string) ([]models.Collection, error) {
+	ret := _m.Called(ctx, user)
+
+	var r0 []models.Collection
+	if rf, ok := ret.Get(0).(func(context.Context, string) []models.Collection); ok {
+		r0 = rf(ctx, user)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Collection)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, user)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetJob provides a mock function with given fields: ctx, id
+func (_m *DbHandler) GetJob(ctx context.Context, id primitive.ObjectID) (*models.Job, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *models.Job
+	if rf, ok := ret.Get(0).(func(context.Context, primitive.ObjectID) *models.Job); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Job)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, primitive.ObjectID) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetJobsByStatus provides a mock function with given fields: ctx, status
+func (_m *DbHandler) GetJobsByStatus(ctx context.Context, status string) ([]models.Job, error) {
+	ret := _m.Called(ctx, status)
+
+	var r0 []models.Job
+	if rf, ok := ret.Get(0).(func(context.Context, string) []models.Job); ok {
+		r0 = rf(ctx, status)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Job)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, status)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPendingOutboxEvents provides a mock function with given fields: ctx, limit
+func (_m *DbHandler) GetPendingOutboxEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	ret := _m.Called(ctx, limit)
+
+	var r0 []models.OutboxEvent
+	if rf, ok := ret.Get(0).(func(context.Context, int) []models.OutboxEvent); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.OutboxEvent)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSchedule provides a mock function with given fields: ctx, name
+func (_m *DbHandler) GetSchedule(ctx context.Context, name string) (*models.Schedule, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 *models.Schedule
+	if rf, ok := ret.Get(0).(func(context.Context, string) *models.Schedule); ok {
+		r0 = rf(ctx, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Schedule)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSchedules provides a mock function with given fields: ctx
+func (_m *DbHandler) GetSchedules(ctx context.Context) ([]models.Schedule, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []models.Schedule
+	if rf, ok := ret.Get(0).(func(context.Context) []models.Schedule); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Schedule)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSet provides a mock function with given fields: ctx, groupId
+func (_m *DbHandler) GetSet(ctx context.Context, groupId int) (*models.Set, error) {
+	ret := _m.Called(ctx, groupId)
+
+	var r0 *models.Set
+	if rf, ok := ret.Get(0).(func(context.Context, int) *models.Set); ok {
+		r0 = rf(ctx, groupId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Set)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, groupId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSets provides a mock function with given fields: ctx
+func (_m *DbHandler) GetSets(ctx context.Context) ([]models.Set, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []models.Set
+	if rf, ok := ret.Get(0).(func(context.Context) []models.Set); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Set)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MarkOutboxEventSent provides a mock function with given fields: ctx, id
+func (_m *DbHandler) MarkOutboxEventSent(ctx context.Context, id primitive.ObjectID) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, primitive.ObjectID) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MarkScheduleRun provides a mock function with given fields: ctx, name, runAt, jobId
+func (_m *DbHandler) MarkScheduleRun(ctx context.Context, name string, runAt time.Time, jobId string) error {
+	ret := _m.Called(ctx, name, runAt, jobId)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, string) error); ok {
+		r0 = rf(ctx, name, runAt, jobId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Migrate provides a mock function with given fields: ctx
+func (_m *DbHandler) Migrate(ctx context.Context) ([]string, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context) []string); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Ping provides a mock function with given fields: ctx
 func (_m *DbHandler) Ping(ctx context.Context) error {
 	ret := _m.Called(ctx)
@@ -135,6 +621,20 @@ func (_m *DbHandler) Ping(ctx context.Context) error {
 	return r0
 }
 
+// ReleaseLock provides a mock function with given fields: ctx, name, owner
+func (_m *DbHandler) ReleaseLock(ctx context.Context, name string, owner string) error {
+	ret := _m.Called(ctx, name, owner)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, name, owner)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateCardById provides a mock function with given fields: ctx, id, card
 func (_m *DbHandler) UpdateCardById(ctx context.Context, id primitive.ObjectID, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error) {
 	ret := _m.Called(ctx, id, card)
@@ -180,3 +680,82 @@ func (_m *DbHandler) UpdateCardByNumber(ctx context.Context, serial string, card
 
 	return r0, r1
 }
+
+// UpdateCardPrices provides a mock function with given fields: ctx, serial, card
+func (_m *DbHandler) UpdateCardPrices(ctx context.Context, serial string, card models.CardWithPriceInfo) (*models.CardWithPriceInfo, error) {
+	ret := _m.Called(ctx, serial, card)
+
+	var r0 *models.CardWithPriceInfo
+	if rf, ok := ret.Get(0).(func(context.Context, string, models.CardWithPriceInfo) *models.CardWithPriceInfo); ok {
+		r0 = rf(ctx, serial, card)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.CardWithPriceInfo)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, models.CardWithPriceInfo) error); ok {
+		r1 = rf(ctx, serial, card)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateCollection provides a mock function with given fields: ctx, collection
+func (_m *DbHandler) UpdateCollection(ctx context.Context, collection models.Collection) error {
+	ret := _m.Called(ctx, collection)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.Collection) error); ok {
+		r0 = rf(ctx, collection)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateJob provides a mock function with given fields: ctx, job
+func (_m *DbHandler) UpdateJob(ctx context.Context, job models.Job) error {
+	ret := _m.Called(ctx, job)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.Job) error); ok {
+		r0 = rf(ctx, job)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpsertSchedule provides a mock function with given fields: ctx, schedule
+func (_m *DbHandler) UpsertSchedule(ctx context.Context, schedule models.Schedule) error {
+	ret := _m.Called(ctx, schedule)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.Schedule) error); ok {
+		r0 = rf(ctx, schedule)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpsertSet provides a mock function with given fields: ctx, set
+func (_m *DbHandler) UpsertSet(ctx context.Context, set models.Set) error {
+	ret := _m.Called(ctx, set)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.Set) error); ok {
+		r0 = rf(ctx, set)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -38,6 +38,29 @@ func (_m *ExtRetriever) BasicCardSearch(ctx context.Context, serial string) (*mo
 	return r0, r1
 }
 
+// CatalogSearch provides a mock function with given fields: ctx, filters, offset, limit
+func (_m *ExtRetriever) CatalogSearch(ctx context.Context, filters []models.CardSearchFilter, offset int, limit int) (*models.SearchResponse, error) {
+	ret := _m.Called(ctx, filters, offset, limit)
+
+	var r0 *models.SearchResponse
+	if rf, ok := ret.Get(0).(func(context.Context, []models.CardSearchFilter, int, int) *models.SearchResponse); ok {
+		r0 = rf(ctx, filters, offset, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.SearchResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []models.CardSearchFilter, int, int) error); ok {
+		r1 = rf(ctx, filters, offset, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ExtendedCardSearch provides a mock function with given fields: ctx, productId
 func (_m *ExtRetriever) ExtendedCardSearch(ctx context.Context, productId int) (*models.ExtendedSearchResponse, error) {
 	ret := _m.Called(ctx, productId)
@@ -61,6 +84,29 @@ func (_m *ExtRetriever) ExtendedCardSearch(ctx context.Context, productId int) (
 	return r0, r1
 }
 
+// ExtendedCardSearchMultiple provides a mock function with given fields: ctx, productIds
+func (_m *ExtRetriever) ExtendedCardSearchMultiple(ctx context.Context, productIds []int) (*models.ExtendedSearchResponse, error) {
+	ret := _m.Called(ctx, productIds)
+
+	var r0 *models.ExtendedSearchResponse
+	if rf, ok := ret.Get(0).(func(context.Context, []int) *models.ExtendedSearchResponse); ok {
+		r0 = rf(ctx, productIds)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.ExtendedSearchResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []int) error); ok {
+		r1 = rf(ctx, productIds)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetCardPricingInfo provides a mock function with given fields: ctx, productId
 func (_m *ExtRetriever) GetCardPricingInfo(ctx context.Context, productId int) (*models.PriceResponse, error) {
 	ret := _m.Called(ctx, productId)
@@ -84,6 +130,144 @@ func (_m *ExtRetriever) GetCardPricingInfo(ctx context.Context, productId int) (
 	return r0, r1
 }
 
+// GetCardPricingInfoMultiple provides a mock function with given fields: ctx, productIds
+func (_m *ExtRetriever) GetCardPricingInfoMultiple(ctx context.Context, productIds []int) (*models.PriceResponse, error) {
+	ret := _m.Called(ctx, productIds)
+
+	var r0 *models.PriceResponse
+	if rf, ok := ret.Get(0).(func(context.Context, []int) *models.PriceResponse); ok {
+		r0 = rf(ctx, productIds)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.PriceResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []int) error); ok {
+		r1 = rf(ctx, productIds)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetGroupPricingInfo provides a mock function with given fields: ctx, groupId
+func (_m *ExtRetriever) GetGroupPricingInfo(ctx context.Context, groupId int) (*models.PriceResponse, error) {
+	ret := _m.Called(ctx, groupId)
+
+	var r0 *models.PriceResponse
+	if rf, ok := ret.Get(0).(func(context.Context, int) *models.PriceResponse); ok {
+		r0 = rf(ctx, groupId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.PriceResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, groupId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetGroupProducts provides a mock function with given fields: ctx, groupId, offset, limit
+func (_m *ExtRetriever) GetGroupProducts(ctx context.Context, groupId int, offset int, limit int) (*models.ExtendedSearchResponse, error) {
+	ret := _m.Called(ctx, groupId, offset, limit)
+
+	var r0 *models.ExtendedSearchResponse
+	if rf, ok := ret.Get(0).(func(context.Context, int, int, int) *models.ExtendedSearchResponse); ok {
+		r0 = rf(ctx, groupId, offset, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.ExtendedSearchResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, int, int) error); ok {
+		r1 = rf(ctx, groupId, offset, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetGroups provides a mock function with given fields: ctx, offset, limit
+func (_m *ExtRetriever) GetGroups(ctx context.Context, offset int, limit int) (*models.GroupResponse, error) {
+	ret := _m.Called(ctx, offset, limit)
+
+	var r0 *models.GroupResponse
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) *models.GroupResponse); ok {
+		r0 = rf(ctx, offset, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.GroupResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, int) error); ok {
+		r1 = rf(ctx, offset, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetProductSkus provides a mock function with given fields: ctx, productId
+func (_m *ExtRetriever) GetProductSkus(ctx context.Context, productId int) (*models.SkuResponse, error) {
+	ret := _m.Called(ctx, productId)
+
+	var r0 *models.SkuResponse
+	if rf, ok := ret.Get(0).(func(context.Context, int) *models.SkuResponse); ok {
+		r0 = rf(ctx, productId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.SkuResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, productId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSkuPricingInfo provides a mock function with given fields: ctx, skuIds
+func (_m *ExtRetriever) GetSkuPricingInfo(ctx context.Context, skuIds []int) (*models.SkuPriceResponse, error) {
+	ret := _m.Called(ctx, skuIds)
+
+	var r0 *models.SkuPriceResponse
+	if rf, ok := ret.Get(0).(func(context.Context, []int) *models.SkuPriceResponse); ok {
+		r0 = rf(ctx, skuIds)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.SkuPriceResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []int) error); ok {
+		r1 = rf(ctx, skuIds)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // RefreshToken provides a mock function with given fields: ctx, publicKey, privateKey
 func (_m *ExtRetriever) RefreshToken(ctx context.Context, publicKey string, privateKey string) error {
 	ret := _m.Called(ctx, publicKey, privateKey)
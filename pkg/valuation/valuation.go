@@ -0,0 +1,74 @@
+package valuation
+
+import (
+	"strings"
+
+	"ygo-card-processor/models"
+)
+
+// DefaultLanguage is assumed for owned copies whose language is not given.
+const DefaultLanguage = "English"
+
+// MarketValue returns the market price of a stored card. If the condition of the owned copy is known, the price of
+// the SKU matching its condition, printing and language is used. Otherwise, or if that SKU has no market price, the
+// product level market price of the matching printing is used, falling back to the cheapest printing.
+func MarketValue(card models.CardWithPriceInfo) (float64, string) {
+	if card.Condition != "" {
+		language := card.Language
+		if language == "" {
+			language = DefaultLanguage
+		}
+		for _, sku := range card.SkuPriceInfo {
+			if !strings.EqualFold(sku.Condition, card.Condition) || !strings.EqualFold(sku.Language, language) {
+				continue
+			}
+			if card.Printing != "" && !strings.EqualFold(sku.Printing, card.Printing) {
+				continue
+			}
+			if sku.MarketPrice != 0.0 {
+				return sku.MarketPrice, models.PriceSourceSku
+			}
+		}
+	}
+
+	marketPrice := 0.0
+	for _, price := range card.PriceInfo {
+		if price.MarketPrice == 0.0 {
+			continue
+		}
+		if card.Printing != "" && strings.EqualFold(price.SubTypeName, card.Printing) {
+			return price.MarketPrice, models.PriceSourceProduct
+		}
+		if marketPrice == 0.0 || price.MarketPrice < marketPrice {
+			marketPrice = price.MarketPrice
+		}
+	}
+	return marketPrice, models.PriceSourceProduct
+}
+
+// CollectionValue totals the market value of the given cards.
+func CollectionValue(cards []models.CardWithPriceInfo) models.CollectionValue {
+	value := models.CollectionValue{
+		CardCount: len(cards),
+		Cards:     make([]models.CardValue, 0),
+	}
+	for _, card := range cards {
+		marketPrice, source := MarketValue(card)
+		value.TotalMarketValue += marketPrice
+		if marketPrice == 0.0 {
+			value.UnpricedCount++
+		}
+		value.Cards = append(value.Cards, models.CardValue{
+			Serial:       card.CardInfo.Serial(),
+			Name:         card.CardInfo.Name,
+			Condition:    card.Condition,
+			Printing:     card.Printing,
+			Language:     card.Language,
+			MarketPrice:  marketPrice,
+			PriceSource:  source,
+			NoMarketData: marketPrice == 0.0,
+			PricedAt:     card.PricedAt,
+		})
+	}
+	return value
+}